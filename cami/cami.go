@@ -5,36 +5,277 @@ import (
 	"context"
 	"errors"
 	"fmt"
+	"log/slog"
+	"slices"
+	"sort"
+	"strings"
+	"sync"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/imagebuilder"
+	"github.com/aws/aws-sdk-go-v2/service/rbin"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 )
 
 type ec2If interface {
 	DescribeImages(context.Context, *ec2.DescribeImagesInput, ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error)
 	DescribeInstances(context.Context, *ec2.DescribeInstancesInput, ...func(*ec2.Options)) (*ec2.DescribeInstancesOutput, error)
+	DescribeSnapshots(context.Context, *ec2.DescribeSnapshotsInput, ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error)
+	DescribeImageAttribute(context.Context, *ec2.DescribeImageAttributeInput, ...func(*ec2.Options)) (*ec2.DescribeImageAttributeOutput, error)
 	DeregisterImage(context.Context, *ec2.DeregisterImageInput, ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error)
 	DeleteSnapshot(context.Context, *ec2.DeleteSnapshotInput, ...func(*ec2.Options)) (*ec2.DeleteSnapshotOutput, error)
+	EnableImageDeprecation(context.Context, *ec2.EnableImageDeprecationInput, ...func(*ec2.Options)) (*ec2.EnableImageDeprecationOutput, error)
+	DisableImage(context.Context, *ec2.DisableImageInput, ...func(*ec2.Options)) (*ec2.DisableImageOutput, error)
+	CreateTags(context.Context, *ec2.CreateTagsInput, ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error)
+	DeleteTags(context.Context, *ec2.DeleteTagsInput, ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error)
+	RestoreImageFromRecycleBin(context.Context, *ec2.RestoreImageFromRecycleBinInput, ...func(*ec2.Options)) (*ec2.RestoreImageFromRecycleBinOutput, error)
+	RestoreSnapshotFromRecycleBin(context.Context, *ec2.RestoreSnapshotFromRecycleBinInput, ...func(*ec2.Options)) (*ec2.RestoreSnapshotFromRecycleBinOutput, error)
+	ListSnapshotsInRecycleBin(context.Context, *ec2.ListSnapshotsInRecycleBinInput, ...func(*ec2.Options)) (*ec2.ListSnapshotsInRecycleBinOutput, error)
+	RegisterImage(context.Context, *ec2.RegisterImageInput, ...func(*ec2.Options)) (*ec2.RegisterImageOutput, error)
+	ModifySnapshotTier(context.Context, *ec2.ModifySnapshotTierInput, ...func(*ec2.Options)) (*ec2.ModifySnapshotTierOutput, error)
 }
 
 // Config holds the configuration for our AWS struct.
 type Config struct {
 	// Set to true to run non-destructively
 	DryRun bool
+
+	// Region, if set, is the AWS region to run in instead of the one from the default
+	// AWS configuration.
+	Region string
+	// ImageFilters, if set, limits the AMIs cami considers to those matching every
+	// filter. Keys and values are DescribeImages filters, such as "name" or "tag:team",
+	// and values may contain * wildcards. Safety limit percentages are relative to the
+	// matching AMIs.
+	ImageFilters map[string][]string
+	// OwnerTagKeys are the tag keys that name the owner of an AMI, such as owner or team.
+	// The first key an AMI has a non-empty tag for is its owner, and AMIs with none of
+	// them belong to NoOwner. See Plan.ByOwner.
+	OwnerTagKeys []string
+
+	// InstanceStates are the instance states that count as using an AMI. Defaults to
+	// DefaultInstanceStates, which excludes shutting-down and terminated instances.
+	InstanceStates []types.InstanceStateName
+	// StoppedMaxAge, if set, only counts a stopped instance as using its AMI if the
+	// instance was stopped less than StoppedMaxAge ago.
+	StoppedMaxAge time.Duration
+	// LaunchedWithin, if set, protects AMIs that were used to launch an instance less
+	// than LaunchedWithin ago, even if no instance is currently using them.
+	LaunchedWithin time.Duration
+
+	// Action is what DeleteAMIs does to unused AMIs. Defaults to ActionDeregister.
+	Action Action
+	// DeprecateAfter is how long from now ActionDeprecate sets the deprecation time.
+	// Must be at least one minute, which is also the default.
+	DeprecateAfter time.Duration
+	// TagKey is the tag key ActionTag sets on AMIs. Defaults to DefaultTagKey.
+	TagKey string
+
+	// GracePeriod is how long MarkAndSweep waits after marking an AMI as unused before
+	// deleting it.
+	GracePeriod time.Duration
+	// MarkTagKey is the tag key MarkAndSweep uses to record when an AMI was marked as
+	// unused. Defaults to DefaultMarkTagKey.
+	MarkTagKey string
+	// ConfirmSweep, if set, is called by MarkAndSweep with a plan whose candidates are
+	// the AMIs it is about to act on, and returns the ones to act on. Nothing is swept
+	// if it returns none.
+	ConfirmSweep func(*Plan) []types.Image
+
+	// RecycleBin controls the Recycle Bin preflight that runs before AMIs are
+	// deregistered. Defaults to RecycleBinOff.
+	RecycleBin RecycleBinMode
+	// CreateRecycleBinRule creates a cami managed Recycle Bin retention rule for AMIs
+	// and EBS snapshots if none exists.
+	CreateRecycleBinRule bool
+	// RecycleBinRetentionDays is how many days a cami managed Recycle Bin rule retains
+	// resources. Defaults to DefaultRecycleBinRetentionDays.
+	RecycleBinRetentionDays int32
+
+	// Prices is the price table used to estimate snapshot storage costs. Defaults to
+	// DefaultPrices.
+	Prices PriceTable
+
+	// MaxImages, if set, is the maximum number of AMIs a single run may act on. Runs that
+	// would exceed it fail with ErrSafetyLimit before anything is deleted.
+	MaxImages int
+	// MaxPercent, if set, is the maximum percentage of owned AMIs a single run may act on.
+	// Runs that would exceed it fail with ErrSafetyLimit before anything is deleted.
+	MaxPercent float64
+	// OverrideLimits ignores MaxImages and MaxPercent.
+	OverrideLimits bool
+
+	// ArchivePath, if set, is a file that the full description of every AMI is appended
+	// to before it is deregistered, so that it can be re-registered with
+	// ReregisterImages as long as its snapshots are kept.
+	ArchivePath string
+
+	// AuditLogPath, if set, is a file that a record of every API call that changes a
+	// resource is appended to as the call is made. See AuditRecord.
+	AuditLogPath string
+
+	// Logger receives debug events for every AWS API call, with its timing and error.
+	// Defaults to discarding everything.
+	Logger *slog.Logger
+	// Metrics, if set, records Prometheus metrics about runs and AWS API calls.
+	Metrics *Metrics
+
+	// Detectors find AMIs that are in use outside of EC2. AMIs they find are never
+	// candidates. See Plan.Protected.
+	Detectors []Detector
+	// TerraformStatePaths, if set, adds a detector for AMIs referenced by these local
+	// Terraform state files (format version 4).
+	TerraformStatePaths []string
+	// SSMParameterPaths, if set, adds a detector for AMIs referenced by the SSM
+	// parameters under these paths, such as /ami.
+	SSMParameterPaths []string
+	// CloudFormation, if set, adds a detector for AMIs referenced by the parameters of
+	// active CloudFormation stacks. CloudFormationTemplates also scans their processed
+	// templates.
+	CloudFormation          bool
+	CloudFormationTemplates bool
+	// EKS, if set, adds a detector for AMIs in the launch templates of EKS managed node
+	// groups and of Karpenter EC2NodeClasses.
+	EKS bool
+	// ImageBuilder controls how AMIs produced by EC2 Image Builder are handled.
+	// Defaults to ImageBuilderOff.
+	ImageBuilder ImageBuilderMode
+
+	// Publishers receive an ImageEvent for every AMI that is deregistered. Publishing
+	// failures are logged and do not fail the run.
+	Publishers []Publisher
+	// SNSTopicARN, if set, adds a publisher for this SNS topic when authenticating.
+	SNSTopicARN string
+	// EventBusName, if set, adds a publisher for this EventBridge bus when authenticating.
+	EventBusName string
+
+	// Context, if set, is used for every AWS API call. Cancelling it stops a run: reads
+	// are aborted, and DeleteAMIs finishes acting on the current AMI and its snapshots
+	// before returning, so no AMI is left half deleted.
+	Context context.Context //nolint:containedctx
+}
+
+// DefaultInstanceStates are the instance states that count as using an AMI when
+// Config.InstanceStates is empty.
+func DefaultInstanceStates() []types.InstanceStateName {
+	return []types.InstanceStateName{
+		types.InstanceStateNamePending,
+		types.InstanceStateNameRunning,
+		types.InstanceStateNameStopping,
+		types.InstanceStateNameStopped,
+	}
+}
+
+// instanceStates returns the configured instance states or the defaults.
+func (c *Config) instanceStates() []types.InstanceStateName {
+	if c == nil || len(c.InstanceStates) == 0 {
+		return DefaultInstanceStates()
+	}
+	return c.InstanceStates
+}
+
+// Validate returns an error if the configuration has a value that is not supported,
+// such as a misspelled instance state. Auth and Plan validate the configuration before
+// making any AWS API call.
+func (c *Config) Validate() error {
+	if c == nil {
+		return nil
+	}
+
+	for _, state := range c.InstanceStates {
+		if !slices.Contains(state.Values(), state) {
+			return fmt.Errorf("%w: %q", ErrInvalidInstanceState, state)
+		}
+	}
+	if mode := c.imageBuilder(); !mode.valid() {
+		return fmt.Errorf("%w: %s", ErrInvalidImageBuilderMode, mode)
+	}
+	if mode := c.recycleBin(); !mode.valid() {
+		return fmt.Errorf("%w: %s", ErrInvalidRecycleBinMode, mode)
+	}
+
+	return nil
+}
+
+// imageFilters returns the configured image filters as DescribeImages filters, sorted by
+// name.
+func (c *Config) imageFilters() []types.Filter {
+	if c == nil || len(c.ImageFilters) == 0 {
+		return nil
+	}
+
+	names := make([]string, 0, len(c.ImageFilters))
+	for name := range c.ImageFilters {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	filters := make([]types.Filter, 0, len(names))
+	for _, name := range names {
+		filters = append(filters, types.Filter{Name: aws.String(name), Values: c.ImageFilters[name]})
+	}
+	return filters
+}
+
+// stoppedMaxAge returns the configured stopped max age or zero.
+func (c *Config) stoppedMaxAge() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.StoppedMaxAge
+}
+
+// launchedWithin returns the configured launched within duration or zero.
+func (c *Config) launchedWithin() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.LaunchedWithin
+}
+
+// dryRun returns true if DryRun is set.
+func (c *Config) dryRun() bool {
+	return c != nil && c.DryRun
 }
 
 // AWS is the main struct that holds our client and info.
 type AWS struct {
 	cfg *Config
 
+	// lastLaunched caches the last launched time of AMIs within a run
+	lastLaunched   map[string]time.Time
+	lastLaunchedMu sync.Mutex
+
+	// rbinChecked is true once the Recycle Bin preflight has passed
+	rbinChecked bool
+	// region is the AWS region we are authenticated to
+	region string
+	// audit holds the identity of the caller and serializes writes to the audit log
+	audit auditLog
+	// publishers receive an event for every deregistered AMI
+	publishers []Publisher
+	// detectors find AMIs that are in use outside of EC2
+	detectors []Detector
+	// protected is why detectors kept AMIs in the last FilterAMIs call, keyed by AMI ID
+	protected map[string]string
+
 	// Used for testing
-	ec2         ec2If
-	filterErr   bool
-	newEC2Fn    func(aws.Config, ...func(*ec2.Options)) *ec2.Client
-	newConfigFn func(context.Context, ...func(*config.LoadOptions) error) (aws.Config, error)
+	ec2               ec2If
+	rbin              rbinIf
+	sts               stsIf
+	imagebuilder      imageBuilderIf
+	filterErr         bool
+	nowFn             func() time.Time
+	newEC2Fn          func(aws.Config, ...func(*ec2.Options)) *ec2.Client
+	newRbinFn         func(aws.Config, ...func(*rbin.Options)) *rbin.Client
+	newSTSFn          func(aws.Config, ...func(*sts.Options)) *sts.Client
+	newImageBuilderFn func(aws.Config, ...func(*imagebuilder.Options)) *imagebuilder.Client
+	newConfigFn       func(context.Context, ...func(*config.LoadOptions) error) (aws.Config, error)
 }
 
 // NewAWS returns a new AWS struct.
@@ -42,6 +283,9 @@ func NewAWS(c *Config) (*AWS, error) {
 	a := &AWS{cfg: c}
 
 	a.newEC2Fn = ec2.NewFromConfig
+	a.newRbinFn = rbin.NewFromConfig
+	a.newSTSFn = sts.NewFromConfig
+	a.newImageBuilderFn = imagebuilder.NewFromConfig
 	a.newConfigFn = config.LoadDefaultConfig
 
 	return a, nil
@@ -49,28 +293,52 @@ func NewAWS(c *Config) (*AWS, error) {
 
 // Auth sets up our AWS session and service clients.
 func (a *AWS) Auth() error {
-	var err error
+	err := a.cfg.Validate()
+	if err != nil {
+		return err
+	}
 
-	cfg, err := a.newConfigFn(context.TODO())
+	var opts []func(*config.LoadOptions) error
+	if a.cfg != nil && a.cfg.Region != "" {
+		opts = append(opts, config.WithRegion(a.cfg.Region))
+	}
+
+	cfg, err := a.newConfigFn(a.ctx(), opts...)
 	if err != nil {
 		return fmt.Errorf("%w", ErrCreateSession)
 	}
 
+	a.region = cfg.Region
 	ec2 := a.newEC2Fn(cfg)
 	a.ec2 = ec2
+	a.rbin = a.newRbinFn(cfg)
+	a.sts = a.newSTSFn(cfg)
+	a.imagebuilder = a.newImageBuilderFn(cfg)
+	a.publishers = a.cfg.publishers(cfg)
+	a.detectors = a.cfg.detectors(cfg)
 
 	return err
 }
 
+// Region returns the AWS region we are authenticated to.
+func (a *AWS) Region() string {
+	return a.region
+}
+
 // AMIs returns a list of all our AMIs.
 func (a *AWS) AMIs() ([]types.Image, error) {
 	var err error
 	var output []types.Image
 
 	amiI := &ec2.DescribeImagesInput{
-		Owners: []string{"self"},
+		Owners:  []string{"self"},
+		Filters: a.cfg.imageFilters(),
 	}
-	amiO, err := a.ec2.DescribeImages(context.TODO(), amiI)
+	var amiO *ec2.DescribeImagesOutput
+	err = a.call("DescribeImages", func() error {
+		amiO, err = a.ec2.DescribeImages(a.ctx(), amiI)
+		return err
+	})
 	if err != nil {
 		return output, fmt.Errorf("%w", ErrDesribeImages)
 	}
@@ -88,22 +356,37 @@ func (a *AWS) EC2s(amis []types.Image) ([]types.Instance, error) {
 		amiIDs = append(amiIDs, *ami.ImageId)
 	}
 
+	states := a.cfg.instanceStates()
+	stateNames := make([]string, 0, len(states))
+	for _, state := range states {
+		stateNames = append(stateNames, string(state))
+	}
+
 	var nextToken *string
 	for {
 		ec2I := &ec2.DescribeInstancesInput{
-			MaxResults: 1000, // nolint:gomnd
+			MaxResults: aws.Int32(1000), //nolint:mnd
 			Filters: []types.Filter{
 				{
 					Name:   aws.String("image-id"),
 					Values: amiIDs,
 				},
+				{
+					Name:   aws.String("instance-state-name"),
+					Values: stateNames,
+				},
 			},
 		}
 		if nextToken != nil {
 			ec2I.NextToken = nextToken
 		}
 
-		out, err := a.ec2.DescribeInstances(context.TODO(), ec2I)
+		var out *ec2.DescribeInstancesOutput
+		err := a.call("DescribeInstances", func() error {
+			var err error
+			out, err = a.ec2.DescribeInstances(a.ctx(), ec2I)
+			return err
+		})
 		if err != nil {
 			return output, fmt.Errorf("%w", ErrDesribeInstances)
 		}
@@ -119,13 +402,20 @@ func (a *AWS) EC2s(amis []types.Image) ([]types.Instance, error) {
 	return output, nil
 }
 
-// FilterAMIs returns back the list of AMIs with images in ec2s removed.
+// FilterAMIs returns back the list of AMIs with images in ec2s removed. Instances
+// that are not in one of the configured states, or that have been stopped for
+// longer than the configured StoppedMaxAge, do not count as using their AMI. If
+// LaunchedWithin is configured, AMIs launched within that duration are also removed,
+// as are AMIs any configured Detector finds a reference to.
 func (a *AWS) FilterAMIs(amis []types.Image, ec2s []types.Instance) ([]types.Image, error) {
 	var err error
 	var output []types.Image
 
 	hasD := make(map[string]bool)
 	for _, ec2 := range ec2s {
+		if !a.inUse(ec2) {
+			continue
+		}
 		hasD[*ec2.ImageId] = true
 	}
 
@@ -135,6 +425,18 @@ func (a *AWS) FilterAMIs(amis []types.Image, ec2s []types.Instance) ([]types.Ima
 		}
 	}
 
+	if within := a.cfg.launchedWithin(); within > 0 && len(output) > 0 {
+		output, err = a.filterLaunched(output, a.now().Add(-within))
+		if err != nil {
+			return output, err
+		}
+	}
+
+	output, err = a.filterUsed(output)
+	if err != nil {
+		return output, err
+	}
+
 	if a.filterErr {
 		return output, ErrFilterAMIs
 	}
@@ -142,82 +444,263 @@ func (a *AWS) FilterAMIs(amis []types.Image, ec2s []types.Instance) ([]types.Ima
 	return output, err
 }
 
+// inUse returns true if the instance counts as using its AMI.
+func (a *AWS) inUse(ec2 types.Instance) bool {
+	// instances without a state are assumed to be in use
+	if ec2.State == nil || ec2.State.Name == "" {
+		return true
+	}
+
+	counts := false
+	for _, state := range a.cfg.instanceStates() {
+		if ec2.State.Name == state {
+			counts = true
+			break
+		}
+	}
+	if !counts {
+		return false
+	}
+
+	maxAge := a.cfg.stoppedMaxAge()
+	if ec2.State.Name != types.InstanceStateNameStopped || maxAge <= 0 {
+		return true
+	}
+
+	stoppedAt, ok := stoppedAt(ec2)
+	if !ok {
+		return true
+	}
+
+	return a.now().Sub(stoppedAt) < maxAge
+}
+
+// stoppedAt parses the time an instance was stopped from its state transition
+// reason, which looks like "User initiated (2021-01-02 15:04:05 GMT)".
+func stoppedAt(ec2 types.Instance) (time.Time, bool) {
+	if ec2.StateTransitionReason == nil {
+		return time.Time{}, false
+	}
+
+	reason := *ec2.StateTransitionReason
+	start := strings.LastIndex(reason, "(")
+	end := strings.LastIndex(reason, ")")
+	if start < 0 || end < start {
+		return time.Time{}, false
+	}
+
+	t, err := time.Parse("2006-01-02 15:04:05 MST", reason[start+1:end])
+	if err != nil {
+		return time.Time{}, false
+	}
+
+	return t, true
+}
+
+// ctx returns the configured context or a background context.
+func (a *AWS) ctx() context.Context {
+	if a.cfg == nil || a.cfg.Context == nil {
+		return context.Background()
+	}
+	return a.cfg.Context
+}
+
+// mutationCtx returns the context used for API calls that change resources. It is
+// never cancelled so that a started change is always completed.
+func (a *AWS) mutationCtx() context.Context {
+	return context.WithoutCancel(a.ctx())
+}
+
+// now returns the current time.
+func (a *AWS) now() time.Time {
+	if a.nowFn != nil {
+		return a.nowFn()
+	}
+	return time.Now()
+}
+
 // DeleteAMIs deregisters all AMIs in the provided list and deletes the snapshots
 // associated with the deregistered AMI. Returns a list of IDs that were successfully
-// deleted. If DryDrun == true does not actually delete.
+// deleted. If DryDrun == true does not actually delete. If a different Action is
+// configured the AMIs are deprecated, disabled or tagged instead and their snapshots
+// are left alone, or with ActionArchive their snapshots are moved to the archive tier.
+// AMIs that are already deprecated or tagged are skipped by those actions.
+// If Config.Context is cancelled DeleteAMIs stops before the next AMI and returns the
+// context's error. It also stops, returning ErrWriteAudit, once a change could not be
+// recorded in the audit log. Image Builder images deleted with ImageBuilderDelete are not
+// returned, Apply reports them in Result.ImageBuilderImages.
 func (a *AWS) DeleteAMIs(amis []types.Image) ([]string, error) {
+	result, err := a.deleteAMIs(amis)
+	return result.IDs, err
+}
+
+// deleteAMIs implements DeleteAMIs, returning the deleted Image Builder images as well.
+func (a *AWS) deleteAMIs(amis []types.Image) (*Result, error) {
 	var output []string
+	result := &Result{RunID: newRunID()}
 	eda := &ErrDeleteAMIs{}
 
-	for _, ami := range amis {
-		amiI := &ec2.DeregisterImageInput{
-			ImageId: ami.ImageId,
-			DryRun:  a.cfg.DryRun,
-		}
-		_, err := a.ec2.DeregisterImage(context.TODO(), amiI)
+	action := a.cfg.action()
+	if !action.valid() {
+		return result, fmt.Errorf("%w: %s", ErrInvalidAction, action)
+	}
+
+	if path := a.cfg.archivePath(); path != "" && action.Deregisters() && !a.cfg.DryRun && len(amis) > 0 {
+		err := a.ArchiveImages(path, amis)
 		if err != nil {
-			var apiErr smithy.APIError
-			if errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation" {
-				output = append(output, *ami.ImageId)
-			} else {
-				eda.Append(*ami.ImageId)
-			}
-		} else {
-			output = append(output, *ami.ImageId)
+			return result, err
 		}
+	}
 
-		for _, bdm := range ami.BlockDeviceMappings {
-			if bdm.Ebs == nil {
-				continue
-			}
+	builds, err := a.imageBuildsOf(action, amis)
+	if err != nil {
+		return result, err
+	}
+
+	var stopErr error
+	for _, ami := range amis {
+		stopErr = a.stopped()
+		if stopErr != nil {
+			break
+		}
 
-			snapID := bdm.Ebs.SnapshotId
-			snapI := &ec2.DeleteSnapshotInput{
-				SnapshotId: snapID,
-				DryRun:     a.cfg.DryRun,
+		if a.applied(action, ami) {
+			a.cfg.logger().Info("skipping AMI the action was already applied to", "id", *ami.ImageId, "action", action)
+			continue
+		}
+
+		switch action {
+		case ActionDeprecate:
+			a.mutate(*ami.ImageId, &output, eda, func() error { return a.deprecateImage(ami) })
+		case ActionDisable:
+			a.mutate(*ami.ImageId, &output, eda, func() error { return a.disableImage(ami) })
+		case ActionTag:
+			a.mutate(*ami.ImageId, &output, eda, func() error { return a.tagImage(ami) })
+		case ActionArchive:
+			if a.archiveAMI(ami, &output, eda) {
+				a.publishDeregistered(result.RunID, action, ami)
 			}
-			_, err := a.ec2.DeleteSnapshot(context.TODO(), snapI)
-			if err != nil {
-				var apiErr smithy.APIError
-				if errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation" {
-					output = append(output, *bdm.Ebs.SnapshotId)
-				} else {
-					eda.Append(*snapID)
-				}
-			} else {
-				output = append(output, *bdm.Ebs.SnapshotId)
+		default:
+			if a.deregisterAMI(ami, &output, eda) {
+				a.publishDeregistered(result.RunID, action, ami)
 			}
 		}
 	}
 
-	return output, eda.ErrorOrNil()
-}
+	a.deleteImageBuilds(builds, output, &result.ImageBuilderImages, eda)
+	result.IDs = output
+	// nothing was acted on in a dry run, but failures are still worth counting
+	acted := output
+	if a.cfg.dryRun() {
+		acted = nil
+	}
+	a.cfg.metrics().acted(action, acted, eda.ErrorOrNil())
 
-// DeleteUnusedAMIs finds and deletes all AMIs (and their associated snapshots)
-// that are not being used by any current EC2 instances in the same account.
-func (a *AWS) DeleteUnusedAMIs() ([]string, error) {
-	var err error
-	var output []string
+	if stopErr == nil {
+		stopErr = a.auditWriteErr()
+	}
+	if stopErr != nil {
+		return result, errors.Join(stopErr, eda.ErrorOrNil())
+	}
+	return result, eda.ErrorOrNil()
+}
 
-	amis, err := a.AMIs()
+// stopped returns why a run must stop before acting on the next AMI, either because
+// the context was cancelled or because a change could not be recorded in the audit log.
+func (a *AWS) stopped() error {
+	err := a.ctx().Err()
 	if err != nil {
-		return output, err
+		return err
+	}
+	return a.auditWriteErr()
+}
+
+// deregisterAMI deregisters an AMI and deletes the snapshots associated with it.
+// Returns true if the AMI was deregistered.
+func (a *AWS) deregisterAMI(ami types.Image, output *[]string, eda *ErrDeleteAMIs) bool {
+	ok := a.mutate(*ami.ImageId, output, eda, func() error { return a.deregisterImage(ami) })
+
+	for _, bdm := range ami.BlockDeviceMappings {
+		if bdm.Ebs == nil {
+			continue
+		}
+
+		snapID := bdm.Ebs.SnapshotId
+		a.mutate(*snapID, output, eda, func() error { return a.deleteSnapshot(*snapID) })
 	}
 
-	ec2s, err := a.EC2s(amis)
+	return ok
+}
+
+// mutate calls fn and adds id to output if fn succeeded or would have succeeded in a
+// dry run, or adds id to failed if fn failed. A change that was made but could not be
+// recorded in the audit log counts as a success. Returns true on success.
+func (a *AWS) mutate(id string, output *[]string, failed *ErrDeleteAMIs, fn func() error) bool {
+	err := fn()
+	if err != nil && !isDryRun(err) && !errors.Is(err, ErrWriteAudit) {
+		failed.Append(id)
+		return false
+	}
+
+	*output = append(*output, id)
+	return true
+}
+
+// isDryRun returns true if err is the error AWS returns when a dry run would have succeeded.
+func isDryRun(err error) bool {
+	var apiErr smithy.APIError
+	return errors.As(err, &apiErr) && apiErr.ErrorCode() == "DryRunOperation"
+}
+
+// deregisterImage deregisters a single AMI.
+func (a *AWS) deregisterImage(ami types.Image) error {
+	amiI := &ec2.DeregisterImageInput{
+		ImageId: ami.ImageId,
+		DryRun:  aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("DeregisterImage", *ami.ImageId, func() error {
+		_, err := a.ec2.DeregisterImage(a.mutationCtx(), amiI)
+		return err
+	})
 	if err != nil {
-		return output, err
+		return fmt.Errorf("%w: %w", ErrDeregisterImage, err)
 	}
 
-	amis, err = a.FilterAMIs(amis, ec2s)
+	return nil
+}
+
+// deleteSnapshot deletes a single snapshot.
+func (a *AWS) deleteSnapshot(id string) error {
+	snapI := &ec2.DeleteSnapshotInput{
+		SnapshotId: aws.String(id),
+		DryRun:     aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("DeleteSnapshot", id, func() error {
+		_, err := a.ec2.DeleteSnapshot(a.mutationCtx(), snapI)
+		return err
+	})
 	if err != nil {
-		return output, err
+		return fmt.Errorf("%w: %w", ErrDeleteSnapshot, err)
 	}
 
-	output, err = a.DeleteAMIs(amis)
+	return nil
+}
+
+// DeleteUnusedAMIs finds and deletes all AMIs (and their associated snapshots)
+// that are not being used by any current EC2 instances in the same account.
+func (a *AWS) DeleteUnusedAMIs() ([]string, error) {
+	var err error
+	var output []string
+	start := time.Now()
+
+	plan, err := a.Plan()
 	if err != nil {
+		a.cfg.metrics().ObserveRun(time.Since(start), err)
 		return output, err
 	}
 
-	return output, err
+	result, err := a.Apply(plan)
+	a.cfg.metrics().ObserveRun(time.Since(start), err)
+
+	return result.IDs, err
 }
@@ -0,0 +1,216 @@
+package cami
+
+import (
+	"fmt"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Action is what DeleteAMIs does to each unused AMI.
+type Action string
+
+const (
+	// ActionDeregister deregisters the AMI and deletes its snapshots. This is the default.
+	ActionDeregister Action = "deregister"
+	// ActionDeprecate sets a deprecation time on the AMI.
+	ActionDeprecate Action = "deprecate"
+	// ActionDisable disables the AMI so that it can no longer be used to launch instances.
+	ActionDisable Action = "disable"
+	// ActionTag tags the AMI with Config.TagKey and the current time.
+	ActionTag Action = "tag"
+	// ActionArchive deregisters the AMI and moves its snapshots to the EBS archive tier
+	// instead of deleting them. The snapshots are tagged with the ID and name of the AMI.
+	ActionArchive Action = "archive"
+)
+
+const (
+	// DefaultTagKey is the tag key used by ActionTag when Config.TagKey is empty.
+	DefaultTagKey = "cami:unused"
+	// SourceAMIIDTagKey is the tag key ActionArchive sets to the ID of the AMI on its snapshots.
+	SourceAMIIDTagKey = "cami:source-ami-id"
+	// SourceAMINameTagKey is the tag key ActionArchive sets to the name of the AMI on its snapshots.
+	SourceAMINameTagKey = "cami:source-ami-name"
+
+	// minDeprecateAfter is the minimum time until deprecation, AWS requires a future time.
+	minDeprecateAfter = time.Minute
+)
+
+// Actions returns all supported actions.
+func Actions() []Action {
+	return []Action{ActionDeregister, ActionDeprecate, ActionDisable, ActionTag, ActionArchive}
+}
+
+// ActionNames returns the names of all supported actions joined by sep.
+func ActionNames(sep string) string {
+	names := make([]string, 0, len(Actions()))
+	for _, action := range Actions() {
+		names = append(names, string(action))
+	}
+	return strings.Join(names, sep)
+}
+
+// valid returns true if the action is supported.
+func (action Action) valid() bool {
+	for _, a := range Actions() {
+		if action == a {
+			return true
+		}
+	}
+	return false
+}
+
+// Deregisters returns true if the action deregisters AMIs.
+func (action Action) Deregisters() bool {
+	return action == ActionDeregister || action == ActionArchive
+}
+
+// action returns the configured action or the default.
+func (c *Config) action() Action {
+	if c == nil || c.Action == "" {
+		return ActionDeregister
+	}
+	return c.Action
+}
+
+// tagKey returns the configured tag key or the default.
+func (c *Config) tagKey() string {
+	if c == nil || c.TagKey == "" {
+		return DefaultTagKey
+	}
+	return c.TagKey
+}
+
+// deprecateAfter returns the configured time until deprecation, at least one minute.
+func (c *Config) deprecateAfter() time.Duration {
+	if c == nil || c.DeprecateAfter < minDeprecateAfter {
+		return minDeprecateAfter
+	}
+	return c.DeprecateAfter
+}
+
+// applied returns true if the action has already been applied to the AMI by an earlier
+// run. Applying it again would push back the deprecation time or overwrite the time the
+// AMI was first tagged, so that a scheduled run never lets the AMI age out.
+func (a *AWS) applied(action Action, ami types.Image) bool {
+	switch action {
+	case ActionDeprecate:
+		return aws.ToString(ami.DeprecationTime) != ""
+	case ActionTag:
+		for _, tag := range ami.Tags {
+			if aws.ToString(tag.Key) == a.cfg.tagKey() {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// deprecateImage sets a deprecation time on a single AMI.
+func (a *AWS) deprecateImage(ami types.Image) error {
+	depI := &ec2.EnableImageDeprecationInput{
+		ImageId:     ami.ImageId,
+		DeprecateAt: aws.Time(a.now().Add(a.cfg.deprecateAfter())),
+		DryRun:      aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("EnableImageDeprecation", *ami.ImageId, func() error {
+		_, err := a.ec2.EnableImageDeprecation(a.mutationCtx(), depI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeprecateImage, err)
+	}
+
+	return nil
+}
+
+// disableImage disables a single AMI.
+func (a *AWS) disableImage(ami types.Image) error {
+	disI := &ec2.DisableImageInput{
+		ImageId: ami.ImageId,
+		DryRun:  aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("DisableImage", *ami.ImageId, func() error {
+		_, err := a.ec2.DisableImage(a.mutationCtx(), disI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDisableImage, err)
+	}
+
+	return nil
+}
+
+// tagImage tags a single AMI with the configured tag key and the current time.
+func (a *AWS) tagImage(ami types.Image) error {
+	return a.createTags([]string{*ami.ImageId}, types.Tag{
+		Key:   aws.String(a.cfg.tagKey()),
+		Value: aws.String(a.now().UTC().Format(time.RFC3339)),
+	})
+}
+
+// archiveAMI deregisters an AMI and moves the snapshots associated with it to the
+// archive tier, tagging them with the AMI ID and name so they can be found later.
+// Returns true if the AMI was deregistered.
+func (a *AWS) archiveAMI(ami types.Image, output *[]string, eda *ErrDeleteAMIs) bool {
+	ok := a.mutate(*ami.ImageId, output, eda, func() error { return a.deregisterImage(ami) })
+
+	for _, bdm := range ami.BlockDeviceMappings {
+		if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+			continue
+		}
+
+		snapID := *bdm.Ebs.SnapshotId
+		a.mutate(snapID, output, eda, func() error { return a.archiveSnapshot(ami, snapID) })
+	}
+
+	return ok
+}
+
+// archiveSnapshot tags a single snapshot with its source AMI and moves it to the
+// archive tier.
+func (a *AWS) archiveSnapshot(ami types.Image, id string) error {
+	err := a.createTags([]string{id},
+		types.Tag{Key: aws.String(SourceAMIIDTagKey), Value: ami.ImageId},
+		types.Tag{Key: aws.String(SourceAMINameTagKey), Value: aws.String(aws.ToString(ami.Name))},
+	)
+	if err != nil && !isDryRun(err) {
+		return err
+	}
+
+	tierI := &ec2.ModifySnapshotTierInput{
+		SnapshotId:  aws.String(id),
+		StorageTier: types.TargetStorageTierArchive,
+		DryRun:      aws.Bool(a.cfg.DryRun),
+	}
+	err = a.audited("ModifySnapshotTier", id, func() error {
+		_, err := a.ec2.ModifySnapshotTier(a.mutationCtx(), tierI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrModifySnapshotTier, err)
+	}
+
+	return nil
+}
+
+// createTags adds tags to the resources with the provided IDs.
+func (a *AWS) createTags(ids []string, tags ...types.Tag) error {
+	tagI := &ec2.CreateTagsInput{
+		Resources: ids,
+		Tags:      tags,
+		DryRun:    aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("CreateTags", strings.Join(ids, ","), func() error {
+		_, err := a.ec2.CreateTags(a.mutationCtx(), tagI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCreateTags, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,231 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteAMIsActions(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{
+			ImageId: aws.String("ami-123"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+			},
+		},
+		{ImageId: aws.String("ami-456")},
+	}
+
+	tests := []struct {
+		name     string
+		giveCfg  *Config
+		giveEC2  *mockEC2
+		wantIDs  []string
+		wantErr  error
+		wantType error
+	}{
+		{
+			name:    "invalid",
+			giveCfg: &Config{Action: "explode"},
+			giveEC2: &mockEC2{},
+			wantIDs: nil,
+			wantErr: ErrInvalidAction,
+		},
+		{
+			name:    "deprecate",
+			giveCfg: &Config{Action: ActionDeprecate},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"ami-123", "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:    "deprecate dry run",
+			giveCfg: &Config{Action: ActionDeprecate, DryRun: true},
+			giveEC2: &mockEC2{RespEnableImageDeprecationErr: mockErr{ErrCode: "DryRunOperation"}},
+			wantIDs: []string{"ami-123", "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:     "deprecate error",
+			giveCfg:  &Config{Action: ActionDeprecate},
+			giveEC2:  &mockEC2{RespEnableImageDeprecationErr: fmt.Errorf("FAIL")},
+			wantIDs:  nil,
+			wantType: &ErrDeleteAMIs{IDs: []string{"ami-123", "ami-456"}},
+		},
+		{
+			name:    "disable",
+			giveCfg: &Config{Action: ActionDisable},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"ami-123", "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:     "disable error",
+			giveCfg:  &Config{Action: ActionDisable},
+			giveEC2:  &mockEC2{RespDisableImageErr: fmt.Errorf("FAIL")},
+			wantIDs:  nil,
+			wantType: &ErrDeleteAMIs{IDs: []string{"ami-123", "ami-456"}},
+		},
+		{
+			name:    "tag",
+			giveCfg: &Config{Action: ActionTag},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"ami-123", "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:     "tag error",
+			giveCfg:  &Config{Action: ActionTag},
+			giveEC2:  &mockEC2{RespCreateTagsErr: fmt.Errorf("FAIL")},
+			wantIDs:  nil,
+			wantType: &ErrDeleteAMIs{IDs: []string{"ami-123", "ami-456"}},
+		},
+		{
+			name:    "archive",
+			giveCfg: &Config{Action: ActionArchive},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"ami-123", "snap-123", "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:    "archive dry run",
+			giveCfg: &Config{Action: ActionArchive, DryRun: true},
+			giveEC2: &mockEC2{
+				RespDeregisterImageErr:    mockErr{ErrCode: "DryRunOperation"},
+				RespCreateTagsErr:         mockErr{ErrCode: "DryRunOperation"},
+				RespModifySnapshotTierErr: mockErr{ErrCode: "DryRunOperation"},
+			},
+			wantIDs: []string{"ami-123", "snap-123", "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:     "archive tag error",
+			giveCfg:  &Config{Action: ActionArchive},
+			giveEC2:  &mockEC2{RespCreateTagsErr: fmt.Errorf("FAIL")},
+			wantIDs:  []string{"ami-123", "ami-456"},
+			wantType: &ErrDeleteAMIs{IDs: []string{"snap-123"}},
+		},
+		{
+			name:     "archive tier error",
+			giveCfg:  &Config{Action: ActionArchive},
+			giveEC2:  &mockEC2{RespModifySnapshotTierErr: fmt.Errorf("FAIL")},
+			wantIDs:  []string{"ami-123", "ami-456"},
+			wantType: &ErrDeleteAMIs{IDs: []string{"snap-123"}},
+		},
+		{
+			name:    "deregister",
+			giveCfg: &Config{Action: ActionDeregister},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"ami-123", "snap-123", "ami-456"},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{
+				cfg: tt.giveCfg,
+				ec2: tt.giveEC2,
+			}
+
+			ids, err := aws.DeleteAMIs(amis)
+
+			switch {
+			case tt.wantType != nil:
+				assert.Equal(t, tt.wantType, err)
+			case tt.wantErr == nil:
+				assert.Nil(t, err)
+			default:
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func TestDeleteAMIsApplied(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{ImageId: aws.String("ami-123")},
+		{
+			ImageId:         aws.String("ami-456"),
+			DeprecationTime: aws.String("2021-02-01T00:00:00.000Z"),
+			Tags:            []types.Tag{{Key: aws.String("other"), Value: aws.String("x")}},
+		},
+		{
+			ImageId: aws.String("ami-789"),
+			Tags:    []types.Tag{{Key: aws.String(DefaultTagKey), Value: aws.String("2021-01-01T00:00:00Z")}},
+		},
+	}
+
+	tests := []struct {
+		name    string
+		giveCfg *Config
+		wantIDs []string
+	}{
+		{
+			name:    "deprecate",
+			giveCfg: &Config{Action: ActionDeprecate},
+			wantIDs: []string{"ami-123", "ami-789"},
+		},
+		{
+			name:    "tag",
+			giveCfg: &Config{Action: ActionTag},
+			wantIDs: []string{"ami-123", "ami-456"},
+		},
+		{
+			name:    "tag custom key",
+			giveCfg: &Config{Action: ActionTag, TagKey: "other"},
+			wantIDs: []string{"ami-123", "ami-789"},
+		},
+		{
+			name:    "disable",
+			giveCfg: &Config{Action: ActionDisable},
+			wantIDs: []string{"ami-123", "ami-456", "ami-789"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := AWS{cfg: tt.giveCfg, ec2: &mockEC2{}}
+
+			ids, err := a.DeleteAMIs(amis)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
+
+func TestActionDeregisters(t *testing.T) {
+	t.Parallel()
+
+	assert.True(t, ActionDeregister.Deregisters())
+	assert.True(t, ActionArchive.Deregisters())
+	assert.False(t, ActionDeprecate.Deregisters())
+	assert.False(t, ActionDisable.Deregisters())
+	assert.False(t, ActionTag.Deregisters())
+}
+
+func TestDeprecateAfter(t *testing.T) {
+	t.Parallel()
+
+	assert.Equal(t, time.Minute, (*Config)(nil).deprecateAfter())
+	assert.Equal(t, time.Minute, (&Config{DeprecateAfter: time.Second}).deprecateAfter())
+	assert.Equal(t, time.Hour, (&Config{DeprecateAfter: time.Hour}).deprecateAfter())
+}
@@ -0,0 +1,178 @@
+package cami
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// archiveFileMode is the file mode used when creating an archive file.
+const archiveFileMode = 0o600
+
+// ArchiveRecord is the full description of an AMI, saved to the archive file before
+// the AMI is deregistered so that it can be re-registered later.
+type ArchiveRecord struct {
+	// ArchivedAt is when the record was written
+	ArchivedAt time.Time
+	// Image is the AMI as returned by DescribeImages
+	Image types.Image
+}
+
+// archivePath returns the configured archive path or an empty string.
+func (c *Config) archivePath() string {
+	if c == nil {
+		return ""
+	}
+	return c.ArchivePath
+}
+
+// ArchiveImages appends a record for each AMI to the archive file at path, one JSON
+// object per line.
+func (a *AWS) ArchiveImages(path string, amis []types.Image) error {
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, archiveFileMode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrArchiveImages, err)
+	}
+	defer f.Close()
+
+	enc := json.NewEncoder(f)
+	for _, ami := range amis {
+		err = enc.Encode(ArchiveRecord{ArchivedAt: a.now().UTC(), Image: ami})
+		if err != nil {
+			return fmt.Errorf("%w: %w", ErrArchiveImages, err)
+		}
+	}
+
+	err = f.Close()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrArchiveImages, err)
+	}
+
+	return nil
+}
+
+// ReadArchive returns the records in the archive file at path. If an AMI was archived
+// more than once only its latest record is returned.
+func ReadArchive(path string) ([]ArchiveRecord, error) {
+	var output []ArchiveRecord
+
+	f, err := os.Open(path)
+	if err != nil {
+		return output, fmt.Errorf("%w: %w", ErrReadArchive, err)
+	}
+	defer f.Close()
+
+	index := make(map[string]int)
+	scanner := bufio.NewScanner(f)
+	scanner.Buffer(nil, 1024*1024) //nolint:mnd
+	for scanner.Scan() {
+		if strings.TrimSpace(scanner.Text()) == "" {
+			continue
+		}
+
+		var record ArchiveRecord
+		err = json.Unmarshal(scanner.Bytes(), &record)
+		if err != nil || record.Image.ImageId == nil {
+			return output, fmt.Errorf("%w: invalid record: %s", ErrReadArchive, scanner.Text())
+		}
+
+		if i, ok := index[*record.Image.ImageId]; ok {
+			output[i] = record
+			continue
+		}
+		index[*record.Image.ImageId] = len(output)
+		output = append(output, record)
+	}
+	if err = scanner.Err(); err != nil {
+		return output, fmt.Errorf("%w: %w", ErrReadArchive, err)
+	}
+
+	return output, nil
+}
+
+// ReregisterImages registers a new AMI for each archived record with the same
+// configuration as the original. The snapshots referenced by the original AMI must
+// still exist. Returns a map of original AMI IDs to new AMI IDs. If DryRun == true
+// does not actually register.
+func (a *AWS) ReregisterImages(records []ArchiveRecord) (map[string]string, error) {
+	output := make(map[string]string)
+	er := &ErrDeleteAMIs{Op: OpReregister}
+
+	for _, record := range records {
+		id := *record.Image.ImageId
+
+		var regO *ec2.RegisterImageOutput
+		err := a.audited("RegisterImage", id, func() error {
+			var err error
+			regO, err = a.ec2.RegisterImage(a.mutationCtx(), registerImageInput(record.Image, a.cfg.DryRun))
+			return err
+		})
+		switch {
+		case err != nil && isDryRun(err):
+			output[id] = ""
+		case err != nil:
+			er.Append(id)
+		default:
+			output[id] = aws.ToString(regO.ImageId)
+		}
+	}
+
+	return output, er.ErrorOrNil()
+}
+
+// registerImageInput returns the input to register a new AMI identical to ami.
+func registerImageInput(ami types.Image, dryRun bool) *ec2.RegisterImageInput {
+	bdms := make([]types.BlockDeviceMapping, 0, len(ami.BlockDeviceMappings))
+	for _, bdm := range ami.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			// encryption is inherited from the snapshot and cannot be set alongside it
+			ebs := *bdm.Ebs
+			ebs.Encrypted = nil
+			ebs.KmsKeyId = nil
+			bdm.Ebs = &ebs
+		}
+		bdms = append(bdms, bdm)
+	}
+
+	var tags []types.Tag
+	for _, tag := range ami.Tags {
+		// tags with the aws: prefix are reserved
+		if tag.Key != nil && strings.HasPrefix(*tag.Key, "aws:") {
+			continue
+		}
+		tags = append(tags, tag)
+	}
+
+	regI := &ec2.RegisterImageInput{
+		Name:                ami.Name,
+		Description:         ami.Description,
+		Architecture:        ami.Architecture,
+		BlockDeviceMappings: bdms,
+		BootMode:            ami.BootMode,
+		EnaSupport:          ami.EnaSupport,
+		ImdsSupport:         ami.ImdsSupport,
+		KernelId:            ami.KernelId,
+		RamdiskId:           ami.RamdiskId,
+		RootDeviceName:      ami.RootDeviceName,
+		SriovNetSupport:     ami.SriovNetSupport,
+		TpmSupport:          ami.TpmSupport,
+		DryRun:              aws.Bool(dryRun),
+	}
+	if ami.VirtualizationType != "" {
+		regI.VirtualizationType = aws.String(string(ami.VirtualizationType))
+	}
+	if len(tags) > 0 {
+		regI.TagSpecifications = []types.TagSpecification{
+			{ResourceType: types.ResourceTypeImage, Tags: tags},
+		}
+	}
+
+	return regI
+}
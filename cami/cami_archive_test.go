@@ -0,0 +1,207 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestArchive(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	path := filepath.Join(t.TempDir(), "archive.jsonl")
+
+	a := AWS{nowFn: func() time.Time { return now }}
+
+	old := types.Image{ImageId: aws.String("ami-123"), Name: aws.String("old")}
+	amis := []types.Image{
+		{
+			ImageId:            aws.String("ami-123"),
+			Name:               aws.String("new"),
+			Architecture:       types.ArchitectureValuesArm64,
+			VirtualizationType: types.VirtualizationTypeHvm,
+			EnaSupport:         aws.Bool(true),
+			BootMode:           types.BootModeValuesUefi,
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{
+					DeviceName: aws.String("/dev/xvda"),
+					Ebs:        &types.EbsBlockDevice{SnapshotId: aws.String("snap-123"), VolumeSize: aws.Int32(8)},
+				},
+			},
+			Tags: []types.Tag{{Key: aws.String("team"), Value: aws.String("infra")}},
+		},
+		{ImageId: aws.String("ami-456")},
+	}
+
+	assert.Nil(t, a.ArchiveImages(path, []types.Image{old}))
+	assert.Nil(t, a.ArchiveImages(path, amis))
+
+	records, err := ReadArchive(path)
+	assert.Nil(t, err)
+	assert.Equal(t, []ArchiveRecord{
+		{ArchivedAt: now, Image: amis[0]},
+		{ArchivedAt: now, Image: amis[1]},
+	}, records)
+}
+
+func TestArchiveErrors(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	a := AWS{}
+
+	err := a.ArchiveImages(filepath.Join(dir, "missing", "archive.jsonl"), []types.Image{{ImageId: aws.String("ami-123")}})
+	assert.True(t, errors.Is(err, ErrArchiveImages), fmt.Sprintf("expected: %s\ngot: %s", ErrArchiveImages, err))
+
+	_, err = ReadArchive(filepath.Join(dir, "missing.jsonl"))
+	assert.True(t, errors.Is(err, ErrReadArchive), fmt.Sprintf("expected: %s\ngot: %s", ErrReadArchive, err))
+
+	invalid := filepath.Join(dir, "invalid.jsonl")
+	assert.Nil(t, os.WriteFile(invalid, []byte("{}\n"), archiveFileMode))
+	_, err = ReadArchive(invalid)
+	assert.True(t, errors.Is(err, ErrReadArchive), fmt.Sprintf("expected: %s\ngot: %s", ErrReadArchive, err))
+}
+
+func TestDeleteAMIsArchive(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	amis := []types.Image{{ImageId: aws.String("ami-123")}}
+
+	a := AWS{
+		cfg: &Config{ArchivePath: filepath.Join(dir, "archive.jsonl")},
+		ec2: &mockEC2{},
+	}
+	ids, err := a.DeleteAMIs(amis)
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ami-123"}, ids)
+
+	records, err := ReadArchive(a.cfg.ArchivePath)
+	assert.Nil(t, err)
+	assert.Len(t, records, 1)
+
+	a.cfg.ArchivePath = filepath.Join(dir, "missing", "archive.jsonl")
+	ids, err = a.DeleteAMIs(amis)
+	assert.True(t, errors.Is(err, ErrArchiveImages), fmt.Sprintf("expected: %s\ngot: %s", ErrArchiveImages, err))
+	assert.Nil(t, ids)
+}
+
+func TestRegisterImageInput(t *testing.T) {
+	t.Parallel()
+
+	ami := types.Image{
+		ImageId:            aws.String("ami-123"),
+		Name:               aws.String("base"),
+		Architecture:       types.ArchitectureValuesX8664,
+		VirtualizationType: types.VirtualizationTypeHvm,
+		RootDeviceName:     aws.String("/dev/xvda"),
+		SriovNetSupport:    aws.String("simple"),
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs: &types.EbsBlockDevice{
+					SnapshotId: aws.String("snap-123"),
+					Encrypted:  aws.Bool(true),
+					KmsKeyId:   aws.String("key"),
+				},
+			},
+			{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+		},
+		Tags: []types.Tag{
+			{Key: aws.String("aws:reserved"), Value: aws.String("x")},
+			{Key: aws.String("team"), Value: aws.String("infra")},
+		},
+	}
+
+	regI := registerImageInput(ami, true)
+
+	assert.Equal(t, &ec2.RegisterImageInput{
+		Name:               aws.String("base"),
+		Architecture:       types.ArchitectureValuesX8664,
+		VirtualizationType: aws.String("hvm"),
+		RootDeviceName:     aws.String("/dev/xvda"),
+		SriovNetSupport:    aws.String("simple"),
+		BlockDeviceMappings: []types.BlockDeviceMapping{
+			{
+				DeviceName: aws.String("/dev/xvda"),
+				Ebs:        &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")},
+			},
+			{DeviceName: aws.String("/dev/sdb"), VirtualName: aws.String("ephemeral0")},
+		},
+		TagSpecifications: []types.TagSpecification{
+			{
+				ResourceType: types.ResourceTypeImage,
+				Tags:         []types.Tag{{Key: aws.String("team"), Value: aws.String("infra")}},
+			},
+		},
+		DryRun: aws.Bool(true),
+	}, regI)
+
+	// the original image is left untouched
+	assert.Equal(t, aws.Bool(true), ami.BlockDeviceMappings[0].Ebs.Encrypted)
+}
+
+func TestReregisterImages(t *testing.T) {
+	t.Parallel()
+
+	records := []ArchiveRecord{
+		{Image: types.Image{ImageId: aws.String("ami-123")}},
+	}
+
+	tests := []struct {
+		name    string
+		giveEC2 *mockEC2
+		wantIDs map[string]string
+		wantErr error
+	}{
+		{
+			name:    "success",
+			giveEC2: &mockEC2{RespRegisterImage: ec2.RegisterImageOutput{ImageId: aws.String("ami-456")}},
+			wantIDs: map[string]string{"ami-123": "ami-456"},
+			wantErr: nil,
+		},
+		{
+			name:    "dry run",
+			giveEC2: &mockEC2{RespRegisterImageErr: mockErr{ErrCode: "DryRunOperation"}},
+			wantIDs: map[string]string{"ami-123": ""},
+			wantErr: nil,
+		},
+		{
+			name:    "error",
+			giveEC2: &mockEC2{RespRegisterImageErr: fmt.Errorf("FAIL")},
+			wantIDs: map[string]string{},
+			wantErr: &ErrDeleteAMIs{Op: OpReregister, IDs: []string{"ami-123"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{
+				cfg: &Config{},
+				ec2: tt.giveEC2,
+			}
+
+			ids, err := aws.ReregisterImages(records)
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.Equal(t, tt.wantErr, err)
+			}
+
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
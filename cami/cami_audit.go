@@ -0,0 +1,194 @@
+package cami
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+)
+
+type stsIf interface {
+	GetCallerIdentity(context.Context, *sts.GetCallerIdentityInput, ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error)
+}
+
+// Outcomes of an audited API call.
+const (
+	// OutcomeSuccess is when the call succeeded.
+	OutcomeSuccess = "success"
+	// OutcomeDryRun is when the call would have succeeded but DryRun was set.
+	OutcomeDryRun = "dryrun"
+	// OutcomeError is when the call failed.
+	OutcomeError = "error"
+)
+
+// AuditRecord is a single line in the audit log, written for every API call that
+// changes a resource.
+type AuditRecord struct {
+	// Time is when the call completed
+	Time time.Time `json:"time"`
+	// Account is the AWS account ID the call was made in
+	Account string `json:"account"`
+	// Region is the AWS region the call was made in
+	Region string `json:"region"`
+	// Principal is the ARN of the identity that made the call
+	Principal string `json:"principal"`
+	// Operation is the name of the API call, such as DeregisterImage
+	Operation string `json:"operation"`
+	// ResourceID is the ID of the resource the call changed
+	ResourceID string `json:"resource_id"`
+	// DryRun is true if the call was made with DryRun set
+	DryRun bool `json:"dry_run"`
+	// Outcome is one of OutcomeSuccess, OutcomeDryRun or OutcomeError
+	Outcome string `json:"outcome"`
+	// Error is the error returned by the call, if any
+	Error string `json:"error,omitempty"`
+}
+
+// auditLog holds the state of the audit log.
+type auditLog struct {
+	mu sync.Mutex
+
+	// identity is looked up once, on the first audited call
+	account   string
+	principal string
+	ready     bool
+
+	// writeErr is set once a change could not be recorded and stops further changes
+	writeErr error
+}
+
+// auditPath returns the configured audit log path or an empty string.
+func (c *Config) auditPath() string {
+	if c == nil {
+		return ""
+	}
+	return c.AuditLogPath
+}
+
+// audited runs fn, an API call that changes the resource with the provided ID, and
+// appends a record of it to the audit log if one is configured. The call is not made
+// if the identity of the caller cannot be determined, or if an earlier change could not
+// be recorded. If fn succeeds but its record cannot be written, returns ErrWriteAudit.
+func (a *AWS) audited(operation, id string, fn func() error) error {
+	path := a.cfg.auditPath()
+	if path == "" {
+		return a.call(operation, fn, "resource_id", id)
+	}
+
+	err := a.auditIdentity()
+	if err != nil {
+		return err
+	}
+
+	if a.auditWriteErr() != nil {
+		return fmt.Errorf("%w: %s not called after an earlier record could not be written", ErrAuditLog, operation)
+	}
+
+	callErr := a.call(operation, fn, "resource_id", id)
+
+	record := AuditRecord{
+		Time:       a.now().UTC(),
+		Account:    a.audit.account,
+		Region:     a.region,
+		Principal:  a.audit.principal,
+		Operation:  operation,
+		ResourceID: id,
+		DryRun:     a.cfg.DryRun,
+		Outcome:    OutcomeSuccess,
+	}
+	switch {
+	case callErr != nil && isDryRun(callErr):
+		record.Outcome = OutcomeDryRun
+	case callErr != nil:
+		record.Outcome = OutcomeError
+		record.Error = callErr.Error()
+	}
+
+	err = a.writeAudit(path, record)
+	if err != nil {
+		if record.Outcome == OutcomeError {
+			return fmt.Errorf("%w: %w", callErr, err)
+		}
+
+		err = fmt.Errorf("%w: %s %s: %w", ErrWriteAudit, operation, id, err)
+		a.audit.mu.Lock()
+		a.audit.writeErr = err
+		a.audit.mu.Unlock()
+		return err
+	}
+
+	return callErr
+}
+
+// auditWriteErr returns the error of the first change that could not be recorded in
+// the audit log, or nil.
+func (a *AWS) auditWriteErr() error {
+	a.audit.mu.Lock()
+	defer a.audit.mu.Unlock()
+	return a.audit.writeErr
+}
+
+// Account returns the ID of the AWS account the caller belongs to. It is looked up with
+// STS GetCallerIdentity once and cached.
+func (a *AWS) Account() (string, error) {
+	err := a.auditIdentity()
+	if err != nil {
+		return "", err
+	}
+	return a.audit.account, nil
+}
+
+// auditIdentity looks up the account and principal of the caller once.
+func (a *AWS) auditIdentity() error {
+	a.audit.mu.Lock()
+	defer a.audit.mu.Unlock()
+
+	if a.audit.ready {
+		return nil
+	}
+
+	var idO *sts.GetCallerIdentityOutput
+	err := a.call("GetCallerIdentity", func() error {
+		var err error
+		idO, err = a.sts.GetCallerIdentity(a.ctx(), &sts.GetCallerIdentityInput{})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrGetCallerIdentity, err)
+	}
+
+	a.audit.account = aws.ToString(idO.Account)
+	a.audit.principal = aws.ToString(idO.Arn)
+	a.audit.ready = true
+
+	return nil
+}
+
+// writeAudit appends a record to the audit log at path and syncs it to disk.
+func (a *AWS) writeAudit(path string, record AuditRecord) error {
+	a.audit.mu.Lock()
+	defer a.audit.mu.Unlock()
+
+	f, err := os.OpenFile(path, os.O_APPEND|os.O_CREATE|os.O_WRONLY, archiveFileMode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuditLog, err)
+	}
+	defer f.Close()
+
+	err = json.NewEncoder(f).Encode(record)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuditLog, err)
+	}
+
+	err = f.Sync()
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrAuditLog, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,198 @@
+package cami
+
+import (
+	"bufio"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
+	"github.com/stretchr/testify/assert"
+)
+
+// readAudit returns all records in the audit log at path.
+func readAudit(t *testing.T, path string) []AuditRecord {
+	t.Helper()
+
+	f, err := os.Open(path)
+	assert.Nil(t, err)
+	defer f.Close()
+
+	var records []AuditRecord
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		var record AuditRecord
+		assert.Nil(t, json.Unmarshal(scanner.Bytes(), &record))
+		records = append(records, record)
+	}
+
+	return records
+}
+
+func TestDeleteAMIsAudit(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	identity := sts.GetCallerIdentityOutput{
+		Account: aws.String("123456789012"),
+		Arn:     aws.String("arn:aws:iam::123456789012:user/cami"),
+	}
+	amis := []types.Image{
+		{
+			ImageId: aws.String("ami-123"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+			},
+		},
+	}
+
+	tests := []struct {
+		name        string
+		giveDryRun  bool
+		giveEC2     *mockEC2
+		giveSTS     *mockSTS
+		wantRecords []AuditRecord
+		wantErr     error
+	}{
+		{
+			name:    "success",
+			giveEC2: &mockEC2{},
+			giveSTS: &mockSTS{RespGetCallerIdentity: identity},
+			wantRecords: []AuditRecord{
+				{Operation: "DeregisterImage", ResourceID: "ami-123", Outcome: OutcomeSuccess},
+				{Operation: "DeleteSnapshot", ResourceID: "snap-123", Outcome: OutcomeSuccess},
+			},
+		},
+		{
+			name:       "dryrun",
+			giveDryRun: true,
+			giveEC2: &mockEC2{
+				RespDeregisterImageErr: mockErr{ErrCode: "DryRunOperation"},
+				RespDeleteSnapshotErr:  mockErr{ErrCode: "DryRunOperation"},
+			},
+			giveSTS: &mockSTS{RespGetCallerIdentity: identity},
+			wantRecords: []AuditRecord{
+				{Operation: "DeregisterImage", ResourceID: "ami-123", DryRun: true, Outcome: OutcomeDryRun},
+				{Operation: "DeleteSnapshot", ResourceID: "snap-123", DryRun: true, Outcome: OutcomeDryRun},
+			},
+		},
+		{
+			name:    "error",
+			giveEC2: &mockEC2{RespDeleteSnapshotErr: fmt.Errorf("FAIL")},
+			giveSTS: &mockSTS{RespGetCallerIdentity: identity},
+			wantRecords: []AuditRecord{
+				{Operation: "DeregisterImage", ResourceID: "ami-123", Outcome: OutcomeSuccess},
+				{Operation: "DeleteSnapshot", ResourceID: "snap-123", Outcome: OutcomeError, Error: "FAIL"},
+			},
+			wantErr: &ErrDeleteAMIs{},
+		},
+		{
+			name:        "identity error",
+			giveEC2:     &mockEC2{},
+			giveSTS:     &mockSTS{RespGetCallerIdentityErr: fmt.Errorf("FAIL")},
+			wantRecords: nil,
+			wantErr:     &ErrDeleteAMIs{},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			path := filepath.Join(t.TempDir(), "audit.jsonl")
+			a := AWS{
+				cfg:    &Config{DryRun: tt.giveDryRun, AuditLogPath: path},
+				ec2:    tt.giveEC2,
+				sts:    tt.giveSTS,
+				region: "us-east-1",
+				nowFn:  func() time.Time { return now },
+			}
+
+			_, err := a.DeleteAMIs(amis)
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				var eda *ErrDeleteAMIs
+				assert.True(t, errors.As(err, &eda), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			if tt.wantRecords == nil {
+				_, err := os.Stat(path)
+				assert.True(t, errors.Is(err, os.ErrNotExist))
+				return
+			}
+
+			for i := range tt.wantRecords {
+				tt.wantRecords[i].Time = now
+				tt.wantRecords[i].Account = "123456789012"
+				tt.wantRecords[i].Region = "us-east-1"
+				tt.wantRecords[i].Principal = "arn:aws:iam::123456789012:user/cami"
+			}
+			assert.Equal(t, tt.wantRecords, readAudit(t, path))
+		})
+	}
+}
+
+func TestAuditWriteError(t *testing.T) {
+	t.Parallel()
+
+	a := AWS{
+		cfg: &Config{AuditLogPath: filepath.Join(t.TempDir(), "missing", "audit.jsonl")},
+		ec2: &mockEC2{},
+		sts: &mockSTS{},
+	}
+
+	err := a.deregisterImage(types.Image{ImageId: aws.String("ami-123")})
+	assert.True(t, errors.Is(err, ErrAuditLog), fmt.Sprintf("expected: %s\ngot: %s", ErrAuditLog, err))
+	assert.True(t, errors.Is(err, ErrWriteAudit), fmt.Sprintf("expected: %s\ngot: %s", ErrWriteAudit, err))
+}
+
+func TestDeleteAMIsAuditWriteError(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{
+			ImageId: aws.String("ami-123"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+			},
+		},
+		{ImageId: aws.String("ami-456")},
+	}
+
+	a := AWS{
+		cfg: &Config{AuditLogPath: filepath.Join(t.TempDir(), "missing", "audit.jsonl")},
+		ec2: &mockEC2{},
+		sts: &mockSTS{},
+	}
+
+	result, err := a.deleteAMIs(amis)
+
+	assert.True(t, errors.Is(err, ErrWriteAudit), fmt.Sprintf("expected: %s\ngot: %s", ErrWriteAudit, err))
+	// the AMI was deregistered, its snapshot was not deleted without a record and the
+	// run stopped before the next AMI
+	assert.Equal(t, []string{"ami-123"}, result.IDs)
+	var eda *ErrDeleteAMIs
+	assert.True(t, errors.As(err, &eda))
+	assert.Equal(t, []string{"snap-123"}, eda.IDs)
+}
+
+func TestAccount(t *testing.T) {
+	t.Parallel()
+
+	a := AWS{sts: &mockSTS{RespGetCallerIdentity: sts.GetCallerIdentityOutput{Account: aws.String("123456789012")}}}
+	account, err := a.Account()
+	assert.Nil(t, err)
+	assert.Equal(t, "123456789012", account)
+
+	a = AWS{sts: &mockSTS{RespGetCallerIdentityErr: fmt.Errorf("FAIL")}}
+	_, err = a.Account()
+	assert.True(t, errors.Is(err, ErrGetCallerIdentity), fmt.Sprintf("expected: %s\ngot: %s", ErrGetCallerIdentity, err))
+}
@@ -0,0 +1,114 @@
+package cami
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+)
+
+type cloudFormationIf interface {
+	DescribeStacks(context.Context, *cloudformation.DescribeStacksInput, ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error)
+	GetTemplate(context.Context, *cloudformation.GetTemplateInput, ...func(*cloudformation.Options)) (*cloudformation.GetTemplateOutput, error)
+}
+
+// CloudFormationDetector finds AMIs referenced by active CloudFormation stacks, either
+// as a parameter value (including the resolved value of SSM parameter types) or,
+// optionally, anywhere in the stack's processed template.
+type CloudFormationDetector struct {
+	// Templates also scans the processed template of every stack, which makes one
+	// extra API call per stack
+	Templates bool
+
+	cfn cloudFormationIf
+}
+
+// NewCloudFormationDetector returns a detector for the stacks in the region of cfg.
+func NewCloudFormationDetector(cfg aws.Config, templates bool) *CloudFormationDetector {
+	return &CloudFormationDetector{Templates: templates, cfn: cloudformation.NewFromConfig(cfg)}
+}
+
+// Name returns "cloudformation".
+func (d *CloudFormationDetector) Name() string {
+	return "cloudformation"
+}
+
+// Detect returns every AMI ID referenced by an active stack.
+func (d *CloudFormationDetector) Detect(ctx context.Context) ([]Usage, error) {
+	var output []Usage
+
+	var nextToken *string
+	for {
+		out, err := d.cfn.DescribeStacks(ctx, &cloudformation.DescribeStacksInput{NextToken: nextToken})
+		if err != nil {
+			return output, fmt.Errorf("%w: %w", ErrDescribeStacks, err)
+		}
+
+		for _, stack := range out.Stacks {
+			if stack.StackStatus == cfntypes.StackStatusDeleteComplete {
+				continue
+			}
+
+			usages, err := d.stackUsages(ctx, stack)
+			if err != nil {
+				return output, err
+			}
+			output = append(output, usages...)
+		}
+
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+
+	return output, nil
+}
+
+// stackUsages returns the AMI IDs referenced by a single stack.
+func (d *CloudFormationDetector) stackUsages(ctx context.Context, stack cfntypes.Stack) ([]Usage, error) {
+	var output []Usage
+	name := aws.ToString(stack.StackName)
+
+	seen := make(map[string]bool)
+	for _, p := range stack.Parameters {
+		for _, value := range []*string{p.ParameterValue, p.ResolvedValue} {
+			for _, id := range findAMIIDs(aws.ToString(value)) {
+				if seen[id] {
+					continue
+				}
+				seen[id] = true
+				output = append(output, Usage{
+					ImageID: id,
+					Reason:  fmt.Sprintf("parameter %s of CloudFormation stack %s", aws.ToString(p.ParameterKey), name),
+				})
+			}
+		}
+	}
+
+	if !d.Templates {
+		return output, nil
+	}
+
+	out, err := d.cfn.GetTemplate(ctx, &cloudformation.GetTemplateInput{
+		StackName:     stack.StackId,
+		TemplateStage: cfntypes.TemplateStageProcessed,
+	})
+	if err != nil {
+		return output, fmt.Errorf("%w: %s: %w", ErrGetTemplate, name, err)
+	}
+	for _, id := range findAMIIDs(aws.ToString(out.TemplateBody)) {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		output = append(output, Usage{
+			ImageID: id,
+			Reason:  fmt.Sprintf("template of CloudFormation stack %s", name),
+		})
+	}
+
+	return output, nil
+}
@@ -0,0 +1,129 @@
+package cami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
+	cfntypes "github.com/aws/aws-sdk-go-v2/service/cloudformation/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCloudFormationDetector(t *testing.T) {
+	t.Parallel()
+
+	stacks := map[string]cloudformation.DescribeStacksOutput{
+		"": {
+			Stacks: []cfntypes.Stack{
+				{
+					StackId:     aws.String("arn:web"),
+					StackName:   aws.String("web"),
+					StackStatus: cfntypes.StackStatusUpdateComplete,
+					Parameters: []cfntypes.Parameter{
+						{ParameterKey: aws.String("ImageId"), ParameterValue: aws.String("ami-0123456789abcdef0")},
+						{ParameterKey: aws.String("InstanceType"), ParameterValue: aws.String("t3.micro")},
+					},
+				},
+				{
+					StackId:     aws.String("arn:old"),
+					StackName:   aws.String("old"),
+					StackStatus: cfntypes.StackStatusDeleteComplete,
+					Parameters: []cfntypes.Parameter{
+						{ParameterKey: aws.String("ImageId"), ParameterValue: aws.String("ami-99999999")},
+					},
+				},
+			},
+			NextToken: aws.String("page2"),
+		},
+		"page2": {
+			Stacks: []cfntypes.Stack{
+				{
+					StackId:     aws.String("arn:workers"),
+					StackName:   aws.String("workers"),
+					StackStatus: cfntypes.StackStatusCreateComplete,
+					Parameters: []cfntypes.Parameter{
+						{
+							ParameterKey:   aws.String("ImageId"),
+							ParameterValue: aws.String("/ami/base/latest"),
+							ResolvedValue:  aws.String("ami-12345678"),
+						},
+					},
+				},
+			},
+		},
+	}
+	templates := map[string]string{
+		"arn:web":     `{"Resources": {"Instance": {"Properties": {"ImageId": {"Ref": "ImageId"}}}}}`,
+		"arn:workers": "Resources:\n  LaunchTemplate:\n    Properties:\n      ImageId: ami-87654321\n      Fallback: ami-12345678\n",
+	}
+
+	paramUsages := []Usage{
+		{ImageID: "ami-0123456789abcdef0", Reason: "parameter ImageId of CloudFormation stack web"},
+		{ImageID: "ami-12345678", Reason: "parameter ImageId of CloudFormation stack workers"},
+	}
+
+	tests := []struct {
+		name          string
+		giveTemplates bool
+		giveCFN       *mockCloudFormation
+		want          []Usage
+		wantTemplates int
+		wantErr       error
+	}{
+		{
+			name:          "parameters",
+			giveTemplates: false,
+			giveCFN:       &mockCloudFormation{RespDescribeStacks: stacks, RespGetTemplate: templates},
+			want:          paramUsages,
+			wantTemplates: 0,
+		},
+		{
+			name:          "templates",
+			giveTemplates: true,
+			giveCFN:       &mockCloudFormation{RespDescribeStacks: stacks, RespGetTemplate: templates},
+			want: []Usage{
+				paramUsages[0],
+				paramUsages[1],
+				{ImageID: "ami-87654321", Reason: "template of CloudFormation stack workers"},
+			},
+			wantTemplates: 2,
+		},
+		{
+			name:    "describe error",
+			giveCFN: &mockCloudFormation{RespDescribeStacksErr: fmt.Errorf("FAIL")},
+			wantErr: ErrDescribeStacks,
+		},
+		{
+			name:          "template error",
+			giveTemplates: true,
+			giveCFN:       &mockCloudFormation{RespDescribeStacks: stacks, RespGetTemplateErr: fmt.Errorf("FAIL")},
+			wantTemplates: 1,
+			wantErr:       ErrGetTemplate,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := &CloudFormationDetector{Templates: tt.giveTemplates, cfn: tt.giveCFN}
+			got, err := d.Detect(context.Background())
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Len(t, tt.giveCFN.GetTemplateInputs, tt.wantTemplates)
+			for _, in := range tt.giveCFN.GetTemplateInputs {
+				assert.Equal(t, cfntypes.TemplateStageProcessed, in.TemplateStage)
+			}
+		})
+	}
+}
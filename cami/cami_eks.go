@@ -0,0 +1,223 @@
+package cami
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+)
+
+// KarpenterNodeClassTag is the tag Karpenter puts on the launch templates it creates,
+// with the name of the EC2NodeClass as its value.
+const KarpenterNodeClassTag = "karpenter.k8s.aws/ec2nodeclass"
+
+type eksIf interface {
+	ListClusters(context.Context, *eks.ListClustersInput, ...func(*eks.Options)) (*eks.ListClustersOutput, error)
+	ListNodegroups(context.Context, *eks.ListNodegroupsInput, ...func(*eks.Options)) (*eks.ListNodegroupsOutput, error)
+	DescribeNodegroup(context.Context, *eks.DescribeNodegroupInput, ...func(*eks.Options)) (*eks.DescribeNodegroupOutput, error)
+}
+
+type launchTemplatesIf interface {
+	DescribeLaunchTemplates(context.Context, *ec2.DescribeLaunchTemplatesInput, ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplatesOutput, error)
+	DescribeLaunchTemplateVersions(context.Context, *ec2.DescribeLaunchTemplateVersionsInput, ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplateVersionsOutput, error)
+}
+
+// EKSDetector finds AMIs that EKS can launch instances from later, even when no
+// instance is running from them now, such as a node group scaled to zero. It reads the
+// launch template version of every managed node group and the launch templates that
+// Karpenter created for its EC2NodeClasses.
+type EKSDetector struct {
+	eks eksIf
+	ec2 launchTemplatesIf
+}
+
+// NewEKSDetector returns a detector for the EKS clusters in the region of cfg.
+func NewEKSDetector(cfg aws.Config) *EKSDetector {
+	return &EKSDetector{eks: eks.NewFromConfig(cfg), ec2: ec2.NewFromConfig(cfg)}
+}
+
+// Name returns "eks".
+func (d *EKSDetector) Name() string {
+	return "eks"
+}
+
+// Detect returns the AMI of every managed node group with a custom AMI and of every
+// Karpenter launch template.
+func (d *EKSDetector) Detect(ctx context.Context) ([]Usage, error) {
+	output, err := d.nodegroupUsages(ctx)
+	if err != nil {
+		return output, err
+	}
+
+	usages, err := d.karpenterUsages(ctx)
+	if err != nil {
+		return output, err
+	}
+
+	return append(output, usages...), nil
+}
+
+// nodegroupUsages returns the AMI in the launch template of every managed node group.
+// Node groups without a launch template, or whose launch template has no image, use
+// an AMI published by EKS and are skipped.
+func (d *EKSDetector) nodegroupUsages(ctx context.Context) ([]Usage, error) {
+	var output []Usage
+
+	clusters, err := d.clusters(ctx)
+	if err != nil {
+		return output, err
+	}
+
+	for _, cluster := range clusters {
+		var nextToken *string
+		for {
+			out, err := d.eks.ListNodegroups(ctx, &eks.ListNodegroupsInput{
+				ClusterName: aws.String(cluster),
+				NextToken:   nextToken,
+			})
+			if err != nil {
+				return output, fmt.Errorf("%w: %s: %w", ErrDescribeNodegroups, cluster, err)
+			}
+
+			for _, name := range out.Nodegroups {
+				ng, err := d.eks.DescribeNodegroup(ctx, &eks.DescribeNodegroupInput{
+					ClusterName:   aws.String(cluster),
+					NodegroupName: aws.String(name),
+				})
+				if err != nil {
+					return output, fmt.Errorf("%w: %s/%s: %w", ErrDescribeNodegroups, cluster, name, err)
+				}
+
+				if ng.Nodegroup == nil || ng.Nodegroup.LaunchTemplate == nil {
+					continue
+				}
+				lt := ng.Nodegroup.LaunchTemplate
+				version := aws.ToString(lt.Version)
+				if version == "" {
+					version = "$Default"
+				}
+
+				// DescribeLaunchTemplateVersions takes an ID or a name, but not both
+				in := &ec2.DescribeLaunchTemplateVersionsInput{Versions: []string{version}}
+				if lt.Id != nil {
+					in.LaunchTemplateId = lt.Id
+				} else {
+					in.LaunchTemplateName = lt.Name
+				}
+				ids, err := d.launchTemplateImages(ctx, in)
+				if err != nil {
+					return output, err
+				}
+				for _, id := range ids {
+					output = append(output, Usage{
+						ImageID: id,
+						Reason:  fmt.Sprintf("launch template of EKS node group %s/%s", cluster, name),
+					})
+				}
+			}
+
+			if out.NextToken == nil {
+				break
+			}
+			nextToken = out.NextToken
+		}
+	}
+
+	return output, nil
+}
+
+// clusters returns the name of every EKS cluster.
+func (d *EKSDetector) clusters(ctx context.Context) ([]string, error) {
+	var output []string
+
+	var nextToken *string
+	for {
+		out, err := d.eks.ListClusters(ctx, &eks.ListClustersInput{NextToken: nextToken})
+		if err != nil {
+			return output, fmt.Errorf("%w: %w", ErrDescribeNodegroups, err)
+		}
+		output = append(output, out.Clusters...)
+
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+
+	return output, nil
+}
+
+// karpenterUsages returns the AMI in the latest version of every launch template
+// tagged with KarpenterNodeClassTag.
+func (d *EKSDetector) karpenterUsages(ctx context.Context) ([]Usage, error) {
+	var output []Usage
+
+	var nextToken *string
+	for {
+		out, err := d.ec2.DescribeLaunchTemplates(ctx, &ec2.DescribeLaunchTemplatesInput{
+			Filters:   []types.Filter{{Name: aws.String("tag-key"), Values: []string{KarpenterNodeClassTag}}},
+			NextToken: nextToken,
+		})
+		if err != nil {
+			return output, fmt.Errorf("%w: %w", ErrDescribeLaunchTemplates, err)
+		}
+
+		for _, lt := range out.LaunchTemplates {
+			ids, err := d.launchTemplateImages(ctx, &ec2.DescribeLaunchTemplateVersionsInput{
+				LaunchTemplateId: lt.LaunchTemplateId,
+				Versions:         []string{"$Latest"},
+			})
+			if err != nil {
+				return output, err
+			}
+
+			var class string
+			for _, tag := range lt.Tags {
+				if aws.ToString(tag.Key) == KarpenterNodeClassTag {
+					class = aws.ToString(tag.Value)
+				}
+			}
+			for _, id := range ids {
+				output = append(output, Usage{
+					ImageID: id,
+					Reason:  fmt.Sprintf("Karpenter launch template %s of EC2NodeClass %s", aws.ToString(lt.LaunchTemplateName), class),
+				})
+			}
+		}
+
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+
+	return output, nil
+}
+
+// launchTemplateImages returns the AMI IDs of the launch template versions described by
+// in. SSM parameters used as the image are resolved to the AMI they point at.
+func (d *EKSDetector) launchTemplateImages(ctx context.Context, in *ec2.DescribeLaunchTemplateVersionsInput) ([]string, error) {
+	var output []string
+
+	in.ResolveAlias = aws.Bool(true)
+	out, err := d.ec2.DescribeLaunchTemplateVersions(ctx, in)
+	if err != nil {
+		name := aws.ToString(in.LaunchTemplateId)
+		if name == "" {
+			name = aws.ToString(in.LaunchTemplateName)
+		}
+		return output, fmt.Errorf("%w: %s: %w", ErrDescribeLaunchTemplates, name, err)
+	}
+
+	for _, v := range out.LaunchTemplateVersions {
+		if v.LaunchTemplateData == nil {
+			continue
+		}
+		output = append(output, findAMIIDs(aws.ToString(v.LaunchTemplateData.ImageId))...)
+	}
+
+	return output, nil
+}
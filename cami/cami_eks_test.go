@@ -0,0 +1,138 @@
+package cami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	ekstypes "github.com/aws/aws-sdk-go-v2/service/eks/types"
+	"github.com/stretchr/testify/assert"
+)
+
+// testEKS returns two clusters: prod has a node group with a custom AMI pinned to
+// version 2 of its launch template, a node group using the default version by name,
+// and a node group using an EKS AMI. dev has no node groups.
+func testEKS() *mockEKS {
+	nodegroup := func(lt *ekstypes.LaunchTemplateSpecification) eks.DescribeNodegroupOutput {
+		return eks.DescribeNodegroupOutput{Nodegroup: &ekstypes.Nodegroup{LaunchTemplate: lt}}
+	}
+
+	return &mockEKS{
+		RespListClusters: map[string]eks.ListClustersOutput{
+			"":      {Clusters: []string{"prod"}, NextToken: aws.String("page2")},
+			"page2": {Clusters: []string{"dev"}},
+		},
+		RespListNodegroups: map[string]eks.ListNodegroupsOutput{
+			"prod": {Nodegroups: []string{"gpu", "web", "system"}},
+		},
+		RespDescribeNodegroup: map[string]eks.DescribeNodegroupOutput{
+			"prod/gpu": nodegroup(&ekstypes.LaunchTemplateSpecification{
+				Id:      aws.String("lt-gpu"),
+				Name:    aws.String("gpu"),
+				Version: aws.String("2"),
+			}),
+			"prod/web":    nodegroup(&ekstypes.LaunchTemplateSpecification{Name: aws.String("web")}),
+			"prod/system": nodegroup(nil),
+		},
+	}
+}
+
+// testLaunchTemplates returns the launch templates of testEKS and one Karpenter launch
+// template.
+func testLaunchTemplates() *mockLaunchTemplates {
+	return &mockLaunchTemplates{
+		RespDescribeLaunchTemplates: ec2.DescribeLaunchTemplatesOutput{
+			LaunchTemplates: []types.LaunchTemplate{{
+				LaunchTemplateId:   aws.String("lt-karpenter"),
+				LaunchTemplateName: aws.String("karpenter.k8s.aws/123"),
+				Tags:               []types.Tag{{Key: aws.String(KarpenterNodeClassTag), Value: aws.String("default")}},
+			}},
+		},
+		RespDescribeLaunchTemplateVersions: map[string]map[string]string{
+			"lt-gpu":       {"1": "ami-11111111", "2": "ami-22222222"},
+			"web":          {"$Default": "ami-33333333"},
+			"lt-karpenter": {"$Latest": "ami-44444444"},
+		},
+	}
+}
+
+func TestEKSDetector(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		giveEKS func(*mockEKS)
+		giveEC2 func(*mockLaunchTemplates)
+		want    []Usage
+		wantErr error
+	}{
+		{
+			name: "node groups and karpenter",
+			want: []Usage{
+				{ImageID: "ami-22222222", Reason: "launch template of EKS node group prod/gpu"},
+				{ImageID: "ami-33333333", Reason: "launch template of EKS node group prod/web"},
+				{ImageID: "ami-44444444", Reason: "Karpenter launch template karpenter.k8s.aws/123 of EC2NodeClass default"},
+			},
+		},
+		{
+			name:    "list clusters error",
+			giveEKS: func(m *mockEKS) { m.RespListClustersErr = fmt.Errorf("FAIL") },
+			wantErr: ErrDescribeNodegroups,
+		},
+		{
+			name:    "list node groups error",
+			giveEKS: func(m *mockEKS) { m.RespListNodegroupsErr = fmt.Errorf("FAIL") },
+			wantErr: ErrDescribeNodegroups,
+		},
+		{
+			name:    "describe node group error",
+			giveEKS: func(m *mockEKS) { m.RespDescribeNodegroupErr = fmt.Errorf("FAIL") },
+			wantErr: ErrDescribeNodegroups,
+		},
+		{
+			name:    "describe launch templates error",
+			giveEC2: func(m *mockLaunchTemplates) { m.RespDescribeLaunchTemplatesErr = fmt.Errorf("FAIL") },
+			wantErr: ErrDescribeLaunchTemplates,
+		},
+		{
+			name:    "describe launch template versions error",
+			giveEC2: func(m *mockLaunchTemplates) { m.RespDescribeLaunchTemplateVersionsErr = fmt.Errorf("FAIL") },
+			wantErr: ErrDescribeLaunchTemplates,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			e, lts := testEKS(), testLaunchTemplates()
+			if tt.giveEKS != nil {
+				tt.giveEKS(e)
+			}
+			if tt.giveEC2 != nil {
+				tt.giveEC2(lts)
+			}
+
+			d := &EKSDetector{eks: e, ec2: lts}
+			got, err := d.Detect(context.Background())
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			for _, in := range lts.VersionInputs {
+				assert.True(t, aws.ToBool(in.ResolveAlias))
+				assert.False(t, in.LaunchTemplateId != nil && in.LaunchTemplateName != nil)
+			}
+		})
+	}
+}
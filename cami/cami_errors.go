@@ -2,6 +2,7 @@ package cami
 
 import (
 	"errors"
+	"fmt"
 )
 
 var (
@@ -11,23 +12,106 @@ var (
 	ErrDesribeImages = errors.New("describe images")
 	// ErrDesribeInstances is when we fail to describe EC2 instances.
 	ErrDesribeInstances = errors.New("describe instances")
+	// ErrDescribeSnapshots is when we fail to describe EBS snapshots.
+	ErrDescribeSnapshots = errors.New("describe snapshots")
+	// ErrDescribeImageAttribute is when we fail to describe an attribute of an image (AMI).
+	ErrDescribeImageAttribute = errors.New("describe image attribute")
 	// ErrDeregisterImage is when we fail to deregister an image (AMI).
 	ErrDeregisterImage = errors.New("deregister image")
 	// ErrDeleteSnapshot is when we fail to delete a snapshot.
 	ErrDeleteSnapshot = errors.New("delete snapshot")
+	// ErrDeprecateImage is when we fail to deprecate an image (AMI).
+	ErrDeprecateImage = errors.New("deprecate image")
+	// ErrDisableImage is when we fail to disable an image (AMI).
+	ErrDisableImage = errors.New("disable image")
+	// ErrModifySnapshotTier is when we fail to move a snapshot to the archive tier.
+	ErrModifySnapshotTier = errors.New("modify snapshot tier")
+	// ErrCreateTags is when we fail to tag a resource.
+	ErrCreateTags = errors.New("create tags")
+	// ErrDeleteTags is when we fail to remove tags from a resource.
+	ErrDeleteTags = errors.New("delete tags")
+	// ErrInvalidAction is when the configured action is not supported.
+	ErrInvalidAction = errors.New("invalid action")
+	// ErrInvalidInstanceState is when a configured instance state is not an EC2 instance state.
+	ErrInvalidInstanceState = errors.New("invalid instance state")
+	// ErrListRecycleBinRules is when we fail to list or describe Recycle Bin retention rules.
+	ErrListRecycleBinRules = errors.New("list recycle bin rules")
+	// ErrCreateRecycleBinRule is when we fail to create a Recycle Bin retention rule.
+	ErrCreateRecycleBinRule = errors.New("create recycle bin rule")
+	// ErrInvalidImageBuilderMode is when the configured Image Builder mode is not supported.
+	ErrInvalidImageBuilderMode = errors.New("invalid image builder mode")
+	// ErrInvalidRecycleBinMode is when the configured Recycle Bin mode is not supported.
+	ErrInvalidRecycleBinMode = errors.New("invalid recycle bin mode")
+	// ErrNoRecycleBinRule is when Recycle Bin rules are required but do not cover AMIs or snapshots.
+	ErrNoRecycleBinRule = errors.New("no recycle bin rule")
+	// ErrRestoreImage is when we fail to restore an image (AMI) from the Recycle Bin.
+	ErrRestoreImage = errors.New("restore image")
+	// ErrRestoreSnapshot is when we fail to restore a snapshot from the Recycle Bin.
+	ErrRestoreSnapshot = errors.New("restore snapshot")
+	// ErrListRecycleBinSnapshots is when we fail to list the snapshots in the Recycle Bin.
+	ErrListRecycleBinSnapshots = errors.New("list recycle bin snapshots")
+	// ErrArchiveImages is when we fail to write images (AMIs) to the archive file.
+	ErrArchiveImages = errors.New("archive images")
+	// ErrReadArchive is when we fail to read the archive file.
+	ErrReadArchive = errors.New("read archive")
+	// ErrGetCallerIdentity is when we fail to look up the identity of the caller.
+	ErrGetCallerIdentity = errors.New("get caller identity")
+	// ErrAuditLog is when we fail to write to the audit log.
+	ErrAuditLog = errors.New("audit log")
+	// ErrWriteAudit is when a change was made but could not be recorded in the audit log.
+	// The run stops and no further changes are made.
+	ErrWriteAudit = errors.New("write audit record of a completed change")
+	// ErrWriteMetrics is when we fail to write metrics to a file.
+	ErrWriteMetrics = errors.New("write metrics")
+	// ErrPublish is when we fail to publish an image event.
+	ErrPublish = errors.New("publish image event")
+	// ErrDetectUsage is when a usage detector fails.
+	ErrDetectUsage = errors.New("detect usage")
+	// ErrTerraformState is when we fail to read a Terraform state file.
+	ErrTerraformState = errors.New("read terraform state")
+	// ErrGetParameters is when we fail to get SSM parameters.
+	ErrGetParameters = errors.New("get SSM parameters")
+	// ErrDescribeStacks is when we fail to describe CloudFormation stacks.
+	ErrDescribeStacks = errors.New("describe stacks")
+	// ErrGetTemplate is when we fail to get the template of a CloudFormation stack.
+	ErrGetTemplate = errors.New("get template")
+	// ErrListImageBuilderImages is when we fail to list Image Builder images.
+	ErrListImageBuilderImages = errors.New("list image builder images")
+	// ErrDeleteImageBuilderImage is when we fail to delete an Image Builder image.
+	ErrDeleteImageBuilderImage = errors.New("delete image builder image")
+	// ErrDescribeNodegroups is when we fail to list EKS clusters or describe their
+	// managed node groups.
+	ErrDescribeNodegroups = errors.New("describe EKS node groups")
+	// ErrDescribeLaunchTemplates is when we fail to describe launch templates.
+	ErrDescribeLaunchTemplates = errors.New("describe launch templates")
 	// ErrFilterAMIs is when when we fail to filter AMIs and EC2 instances.
 	ErrFilterAMIs = errors.New("filter AMIs")
 )
 
 // ErrDeleteAMIs is when we fail to delete (deregister image + snapshot delete) an image (AMI).
+// It is also returned for the IDs that could not be restored or re-registered, with Op
+// naming the operation.
 type ErrDeleteAMIs struct {
+	// Op is the operation that failed, "delete AMIs" if empty
+	Op string
 	// IDs is the list of AMI and snapshot IDs we failed to delete
 	IDs []string
 }
 
+// Operations other than deleting that return ErrDeleteAMIs.
+const (
+	// OpRestore is the Op of ErrDeleteAMIs returned by RestoreAMIs.
+	OpRestore = "restore"
+	// OpReregister is the Op of ErrDeleteAMIs returned by ReregisterImages.
+	OpReregister = "reregister"
+)
+
 // Error returns the error string for ErrDeleteAMIs.
 func (e *ErrDeleteAMIs) Error() string {
-	return "delete AMIs"
+	if e.Op == "" {
+		return "delete AMIs"
+	}
+	return e.Op
 }
 
 // Append adds a new ID to the list of IDs.
@@ -45,3 +129,30 @@ func (e *ErrDeleteAMIs) ErrorOrNil() error {
 	}
 	return e
 }
+
+// ErrSafetyLimit is when a run would act on more AMIs than the configured safety limits
+// allow. Nothing is deleted when this error is returned.
+type ErrSafetyLimit struct {
+	// Count is the number of AMIs the run would act on
+	Count int
+	// Owned is the number of AMIs owned by the account
+	Owned int
+	// MaxImages is the configured maximum number of AMIs
+	MaxImages int
+	// MaxPercent is the configured maximum percentage of owned AMIs
+	MaxPercent float64
+}
+
+// Error returns the error string for ErrSafetyLimit.
+func (e *ErrSafetyLimit) Error() string {
+	return fmt.Sprintf("safety limit: run would act on %d of %d AMIs (%.1f%%), limits are %d AMIs and %.1f%%",
+		e.Count, e.Owned, e.Percent(), e.MaxImages, e.MaxPercent)
+}
+
+// Percent returns the percentage of owned AMIs the run would act on.
+func (e *ErrSafetyLimit) Percent() float64 {
+	if e.Owned == 0 {
+		return 0
+	}
+	return float64(e.Count) / float64(e.Owned) * percent
+}
@@ -0,0 +1,194 @@
+package cami
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+)
+
+// Image events published by cami.
+const (
+	// ImageEventVersion is the version of the ImageEvent schema. It changes when a
+	// field is removed or changes meaning, not when a field is added.
+	ImageEventVersion = "1"
+	// ImageEventSource is the EventBridge source of image events.
+	ImageEventSource = "cami"
+	// ImageEventDetailType is the EventBridge detail type of image events.
+	ImageEventDetailType = "AMI Deregistered"
+)
+
+// ImageEvent is published once for every AMI that cami deregisters.
+type ImageEvent struct {
+	// Version is ImageEventVersion
+	Version string `json:"version"`
+	// RunID identifies the run that deregistered the AMI. Every event from the same
+	// run has the same RunID, which is also the RunID of its Result.
+	RunID string `json:"run_id"`
+	// Time is when the AMI was deregistered
+	Time time.Time `json:"time"`
+	// Region is the AWS region of the AMI
+	Region string `json:"region"`
+	// Action is the action that deregistered the AMI, deregister or archive
+	Action Action `json:"action"`
+	// ImageID is the ID of the AMI
+	ImageID string `json:"image_id"`
+	// Name is the name of the AMI
+	Name string `json:"name"`
+	// Tags are the tags the AMI had
+	Tags map[string]string `json:"tags"`
+	// Snapshots are the IDs of the snapshots that backed the AMI
+	Snapshots []string `json:"snapshots"`
+}
+
+// Publisher publishes image events somewhere, such as an SNS topic or an EventBridge bus.
+type Publisher interface {
+	Publish(ctx context.Context, event ImageEvent) error
+}
+
+type snsIf interface {
+	Publish(context.Context, *sns.PublishInput, ...func(*sns.Options)) (*sns.PublishOutput, error)
+}
+
+type eventBridgeIf interface {
+	PutEvents(context.Context, *eventbridge.PutEventsInput, ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error)
+}
+
+// SNSPublisher publishes each image event as a JSON message to an SNS topic.
+type SNSPublisher struct {
+	topicARN string
+	sns      snsIf
+}
+
+// NewSNSPublisher returns a Publisher for the SNS topic with the provided ARN.
+func NewSNSPublisher(cfg aws.Config, topicARN string) *SNSPublisher {
+	return &SNSPublisher{topicARN: topicARN, sns: sns.NewFromConfig(cfg)}
+}
+
+// Publish publishes event to the topic.
+func (p *SNSPublisher) Publish(ctx context.Context, event ImageEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublish, err)
+	}
+
+	_, err = p.sns.Publish(ctx, &sns.PublishInput{
+		TopicArn: aws.String(p.topicARN),
+		Subject:  aws.String(ImageEventDetailType),
+		Message:  aws.String(string(b)),
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublish, err)
+	}
+
+	return nil
+}
+
+// EventBridgePublisher puts each image event on an EventBridge bus, with source
+// ImageEventSource, detail type ImageEventDetailType and the event as its detail.
+type EventBridgePublisher struct {
+	busName string
+	eb      eventBridgeIf
+}
+
+// NewEventBridgePublisher returns a Publisher for the EventBridge bus with the provided
+// name or ARN.
+func NewEventBridgePublisher(cfg aws.Config, busName string) *EventBridgePublisher {
+	return &EventBridgePublisher{busName: busName, eb: eventbridge.NewFromConfig(cfg)}
+}
+
+// Publish puts event on the bus.
+func (p *EventBridgePublisher) Publish(ctx context.Context, event ImageEvent) error {
+	b, err := json.Marshal(event)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublish, err)
+	}
+
+	peO, err := p.eb.PutEvents(ctx, &eventbridge.PutEventsInput{
+		Entries: []ebtypes.PutEventsRequestEntry{{
+			EventBusName: aws.String(p.busName),
+			Source:       aws.String(ImageEventSource),
+			DetailType:   aws.String(ImageEventDetailType),
+			Detail:       aws.String(string(b)),
+			Resources:    []string{event.ImageID},
+			Time:         aws.Time(event.Time),
+		}},
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrPublish, err)
+	}
+	if peO.FailedEntryCount > 0 && len(peO.Entries) > 0 {
+		return fmt.Errorf("%w: %s: %s", ErrPublish,
+			aws.ToString(peO.Entries[0].ErrorCode), aws.ToString(peO.Entries[0].ErrorMessage))
+	}
+
+	return nil
+}
+
+// publishers returns the configured publishers and those for SNSTopicARN and
+// EventBusName, using the provided AWS config.
+func (c *Config) publishers(cfg aws.Config) []Publisher {
+	if c == nil {
+		return nil
+	}
+
+	ps := append([]Publisher{}, c.Publishers...)
+	if c.SNSTopicARN != "" {
+		ps = append(ps, NewSNSPublisher(cfg, c.SNSTopicARN))
+	}
+	if c.EventBusName != "" {
+		ps = append(ps, NewEventBridgePublisher(cfg, c.EventBusName))
+	}
+
+	return ps
+}
+
+// newRunID returns a random ID for a run.
+func newRunID() string {
+	b := make([]byte, 16) //nolint:mnd
+	_, _ = rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// publishDeregistered publishes an event for an AMI that was deregistered to every
+// publisher. Nothing is published in a dry run. A failure to publish is logged and
+// does not fail the run, since the AMI is already gone.
+func (a *AWS) publishDeregistered(runID string, action Action, ami types.Image) {
+	if len(a.publishers) == 0 || a.cfg.DryRun {
+		return
+	}
+
+	event := ImageEvent{
+		Version: ImageEventVersion,
+		RunID:   runID,
+		Time:    a.now().UTC(),
+		Region:  a.region,
+		Action:  action,
+		ImageID: aws.ToString(ami.ImageId),
+		Name:    aws.ToString(ami.Name),
+		Tags:    map[string]string{},
+	}
+	for _, tag := range ami.Tags {
+		event.Tags[aws.ToString(tag.Key)] = aws.ToString(tag.Value)
+	}
+	for _, bdm := range ami.BlockDeviceMappings {
+		if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+			event.Snapshots = append(event.Snapshots, *bdm.Ebs.SnapshotId)
+		}
+	}
+
+	for _, p := range a.publishers {
+		err := a.call("Publish", func() error { return p.Publish(a.mutationCtx(), event) }, "resource_id", event.ImageID)
+		if err != nil {
+			a.cfg.logger().Warn("failed to publish image event", "image_id", event.ImageID, "run_id", runID, "error", err)
+		}
+	}
+}
@@ -0,0 +1,269 @@
+package cami
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	ebtypes "github.com/aws/aws-sdk-go-v2/service/eventbridge/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestDeleteAMIsPublish(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	amis := []types.Image{
+		{
+			ImageId: aws.String("ami-123"),
+			Name:    aws.String("web-2021-01-02"),
+			Tags:    []types.Tag{{Key: aws.String("team"), Value: aws.String("web")}},
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+				{DeviceName: aws.String("/dev/sdb")},
+			},
+		},
+		{
+			ImageId: aws.String("ami-456"),
+			Name:    aws.String("web-2021-01-03"),
+		},
+	}
+
+	tests := []struct {
+		name          string
+		giveAction    Action
+		giveDryRun    bool
+		giveEC2       *mockEC2
+		givePubErr    error
+		wantImageIDs  []string
+		wantErr       bool
+		wantSnapshots []string
+	}{
+		{
+			name:          "deregister",
+			giveAction:    ActionDeregister,
+			giveEC2:       &mockEC2{},
+			wantImageIDs:  []string{"ami-123", "ami-456"},
+			wantSnapshots: []string{"snap-123"},
+		},
+		{
+			name:          "archive",
+			giveAction:    ActionArchive,
+			giveEC2:       &mockEC2{},
+			wantImageIDs:  []string{"ami-123", "ami-456"},
+			wantSnapshots: []string{"snap-123"},
+		},
+		{
+			name:          "snapshot error",
+			giveAction:    ActionDeregister,
+			giveEC2:       &mockEC2{RespDeleteSnapshotErr: fmt.Errorf("FAIL")},
+			wantImageIDs:  []string{"ami-123", "ami-456"},
+			wantSnapshots: []string{"snap-123"},
+			wantErr:       true,
+		},
+		{
+			name:         "deregister error",
+			giveAction:   ActionDeregister,
+			giveEC2:      &mockEC2{RespDeregisterImageErr: fmt.Errorf("FAIL")},
+			wantImageIDs: nil,
+			wantErr:      true,
+		},
+		{
+			name:       "dryrun",
+			giveAction: ActionDeregister,
+			giveDryRun: true,
+			giveEC2: &mockEC2{
+				RespDeregisterImageErr: mockErr{ErrCode: "DryRunOperation"},
+				RespDeleteSnapshotErr:  mockErr{ErrCode: "DryRunOperation"},
+			},
+			wantImageIDs: nil,
+		},
+		{
+			name:         "deprecate",
+			giveAction:   ActionDeprecate,
+			giveEC2:      &mockEC2{},
+			wantImageIDs: nil,
+		},
+		{
+			name:          "publish error",
+			giveAction:    ActionDeregister,
+			giveEC2:       &mockEC2{},
+			givePubErr:    fmt.Errorf("FAIL"),
+			wantImageIDs:  []string{"ami-123", "ami-456"},
+			wantSnapshots: []string{"snap-123"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			pub := &mockPublisher{RespPublishErr: tt.givePubErr}
+			a := AWS{
+				cfg:        &Config{Action: tt.giveAction, DryRun: tt.giveDryRun},
+				ec2:        tt.giveEC2,
+				region:     "us-east-1",
+				publishers: []Publisher{pub},
+				nowFn:      func() time.Time { return now },
+			}
+
+			result, err := a.deleteAMIs(amis)
+			if tt.wantErr {
+				assert.NotNil(t, err)
+			} else {
+				assert.Nil(t, err)
+			}
+
+			var ids []string
+			for _, event := range pub.Events {
+				ids = append(ids, event.ImageID)
+				assert.Equal(t, ImageEventVersion, event.Version)
+				assert.Equal(t, result.RunID, event.RunID)
+				assert.Len(t, event.RunID, 32)
+				assert.Equal(t, now, event.Time)
+				assert.Equal(t, "us-east-1", event.Region)
+				assert.Equal(t, tt.giveAction, event.Action)
+			}
+			assert.Equal(t, tt.wantImageIDs, ids)
+
+			if len(pub.Events) > 0 {
+				assert.Equal(t, "web-2021-01-02", pub.Events[0].Name)
+				assert.Equal(t, map[string]string{"team": "web"}, pub.Events[0].Tags)
+				assert.Equal(t, tt.wantSnapshots, pub.Events[0].Snapshots)
+			}
+		})
+	}
+}
+
+func TestSNSPublisher(t *testing.T) {
+	t.Parallel()
+
+	event := ImageEvent{
+		Version: ImageEventVersion,
+		RunID:   "0123456789abcdef0123456789abcdef",
+		ImageID: "ami-123",
+		Tags:    map[string]string{"team": "web"},
+	}
+
+	tests := []struct {
+		name    string
+		give    *mockSNS
+		wantErr error
+	}{
+		{
+			name:    "success",
+			give:    &mockSNS{},
+			wantErr: nil,
+		},
+		{
+			name:    "error",
+			give:    &mockSNS{RespPublishErr: fmt.Errorf("FAIL")},
+			wantErr: ErrPublish,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &SNSPublisher{topicARN: "arn:aws:sns:us-east-1:123456789012:cami", sns: tt.give}
+			err := p.Publish(context.Background(), event)
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, "arn:aws:sns:us-east-1:123456789012:cami", aws.ToString(tt.give.Input.TopicArn))
+			var got ImageEvent
+			assert.Nil(t, json.Unmarshal([]byte(aws.ToString(tt.give.Input.Message)), &got))
+			assert.Equal(t, event, got)
+		})
+	}
+}
+
+func TestEventBridgePublisher(t *testing.T) {
+	t.Parallel()
+
+	event := ImageEvent{
+		Version: ImageEventVersion,
+		RunID:   "0123456789abcdef0123456789abcdef",
+		ImageID: "ami-123",
+		Tags:    map[string]string{"team": "web"},
+	}
+
+	tests := []struct {
+		name    string
+		give    *mockEventBridge
+		wantErr error
+	}{
+		{
+			name:    "success",
+			give:    &mockEventBridge{},
+			wantErr: nil,
+		},
+		{
+			name:    "error",
+			give:    &mockEventBridge{RespPutEventsErr: fmt.Errorf("FAIL")},
+			wantErr: ErrPublish,
+		},
+		{
+			name: "failed entry",
+			give: &mockEventBridge{RespPutEvents: eventbridge.PutEventsOutput{
+				FailedEntryCount: 1,
+				Entries: []ebtypes.PutEventsResultEntry{
+					{ErrorCode: aws.String("InternalFailure"), ErrorMessage: aws.String("FAIL")},
+				},
+			}},
+			wantErr: ErrPublish,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			p := &EventBridgePublisher{busName: "cami", eb: tt.give}
+			err := p.Publish(context.Background(), event)
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Len(t, tt.give.Input.Entries, 1)
+			entry := tt.give.Input.Entries[0]
+			assert.Equal(t, "cami", aws.ToString(entry.EventBusName))
+			assert.Equal(t, ImageEventSource, aws.ToString(entry.Source))
+			assert.Equal(t, ImageEventDetailType, aws.ToString(entry.DetailType))
+			assert.Equal(t, []string{"ami-123"}, entry.Resources)
+			var got ImageEvent
+			assert.Nil(t, json.Unmarshal([]byte(aws.ToString(entry.Detail)), &got))
+			assert.Equal(t, event, got)
+		})
+	}
+}
+
+func TestConfigPublishers(t *testing.T) {
+	t.Parallel()
+
+	var c *Config
+	assert.Nil(t, c.publishers(aws.Config{}))
+
+	pub := &mockPublisher{}
+	c = &Config{Publishers: []Publisher{pub}, SNSTopicARN: "arn:aws:sns:us-east-1:123456789012:cami", EventBusName: "cami"}
+	ps := c.publishers(aws.Config{})
+	assert.Len(t, ps, 3)
+	assert.Equal(t, pub, ps[0])
+	assert.IsType(t, &SNSPublisher{}, ps[1])
+	assert.IsType(t, &EventBridgePublisher{}, ps[2])
+}
@@ -0,0 +1,243 @@
+package cami
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/imagebuilder"
+	ibtypes "github.com/aws/aws-sdk-go-v2/service/imagebuilder/types"
+)
+
+type imageBuilderIf interface {
+	ListImages(context.Context, *imagebuilder.ListImagesInput, ...func(*imagebuilder.Options)) (*imagebuilder.ListImagesOutput, error)
+	ListImageBuildVersions(context.Context, *imagebuilder.ListImageBuildVersionsInput, ...func(*imagebuilder.Options)) (*imagebuilder.ListImageBuildVersionsOutput, error)
+	DeleteImage(context.Context, *imagebuilder.DeleteImageInput, ...func(*imagebuilder.Options)) (*imagebuilder.DeleteImageOutput, error)
+}
+
+// ImageBuilderMode controls how AMIs produced by EC2 Image Builder are handled.
+type ImageBuilderMode string
+
+const (
+	// ImageBuilderOff treats AMIs produced by Image Builder like any other AMI, leaving
+	// the Image Builder image pointing at a deregistered AMI. This is the default.
+	ImageBuilderOff ImageBuilderMode = "off"
+	// ImageBuilderProtect never acts on AMIs produced by an Image Builder image that
+	// still exists.
+	ImageBuilderProtect ImageBuilderMode = "protect"
+	// ImageBuilderDelete deletes the Image Builder image with DeleteImage once every AMI
+	// it produced has been deregistered by DeleteAMIs. Images that still have AMIs in
+	// other regions or accounts are kept.
+	ImageBuilderDelete ImageBuilderMode = "delete"
+)
+
+// imageBuilder returns the configured Image Builder mode or the default.
+func (c *Config) imageBuilder() ImageBuilderMode {
+	if c == nil || c.ImageBuilder == "" {
+		return ImageBuilderOff
+	}
+	return c.ImageBuilder
+}
+
+// valid returns true if the mode is one of the supported modes.
+func (mode ImageBuilderMode) valid() bool {
+	switch mode {
+	case ImageBuilderOff, ImageBuilderProtect, ImageBuilderDelete:
+		return true
+	}
+	return false
+}
+
+// imageBuild is an Image Builder image build version and the AMIs it produced.
+type imageBuild struct {
+	Arn  string
+	AMIs []ibtypes.Ami
+}
+
+// callFunc makes a single AWS API call named operation by running fn.
+type callFunc func(operation string, fn func() error) error
+
+// runCall is a callFunc that only runs fn.
+func runCall(_ string, fn func() error) error {
+	return fn()
+}
+
+// listImageBuilds returns every AMI image build version owned by the account. Every
+// API call is made through call.
+func listImageBuilds(ctx context.Context, ib imageBuilderIf, call callFunc) ([]imageBuild, error) {
+	var output []imageBuild
+
+	var versions []string
+	var nextToken *string
+	for {
+		var out *imagebuilder.ListImagesOutput
+		err := call("ListImages", func() error {
+			var err error
+			out, err = ib.ListImages(ctx, &imagebuilder.ListImagesInput{
+				Owner:             ibtypes.OwnershipSelf,
+				IncludeDeprecated: aws.Bool(true),
+				NextToken:         nextToken,
+			})
+			return err
+		})
+		if err != nil {
+			return output, fmt.Errorf("%w: %w", ErrListImageBuilderImages, err)
+		}
+		for _, v := range out.ImageVersionList {
+			if v.Type == ibtypes.ImageTypeAmi {
+				versions = append(versions, aws.ToString(v.Arn))
+			}
+		}
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+
+	for _, version := range versions {
+		nextToken = nil
+		for {
+			var out *imagebuilder.ListImageBuildVersionsOutput
+			err := call("ListImageBuildVersions", func() error {
+				var err error
+				out, err = ib.ListImageBuildVersions(ctx, &imagebuilder.ListImageBuildVersionsInput{
+					ImageVersionArn: aws.String(version),
+					NextToken:       nextToken,
+				})
+				return err
+			})
+			if err != nil {
+				return output, fmt.Errorf("%w: %w", ErrListImageBuilderImages, err)
+			}
+			for _, s := range out.ImageSummaryList {
+				if s.OutputResources == nil || len(s.OutputResources.Amis) == 0 {
+					continue
+				}
+				output = append(output, imageBuild{Arn: aws.ToString(s.Arn), AMIs: s.OutputResources.Amis})
+			}
+			if out.NextToken == nil {
+				break
+			}
+			nextToken = out.NextToken
+		}
+	}
+
+	return output, nil
+}
+
+// ImageBuilderDetector finds AMIs produced by Image Builder images that still exist.
+type ImageBuilderDetector struct {
+	ib imageBuilderIf
+}
+
+// NewImageBuilderDetector returns a detector for the Image Builder images in the
+// region of cfg.
+func NewImageBuilderDetector(cfg aws.Config) *ImageBuilderDetector {
+	return &ImageBuilderDetector{ib: imagebuilder.NewFromConfig(cfg)}
+}
+
+// Name returns "imagebuilder".
+func (d *ImageBuilderDetector) Name() string {
+	return "imagebuilder"
+}
+
+// Detect returns every AMI produced by an Image Builder image owned by the account.
+func (d *ImageBuilderDetector) Detect(ctx context.Context) ([]Usage, error) {
+	var output []Usage
+
+	builds, err := listImageBuilds(ctx, d.ib, runCall)
+	if err != nil {
+		return output, err
+	}
+
+	for _, b := range builds {
+		for _, ami := range b.AMIs {
+			output = append(output, Usage{
+				ImageID: aws.ToString(ami.Image),
+				Reason:  fmt.Sprintf("produced by Image Builder image %s", b.Arn),
+			})
+		}
+	}
+
+	return output, nil
+}
+
+// imageBuildsOf returns the Image Builder image builds that produced any of the AMIs,
+// if ImageBuilderDelete is configured and the action deregisters AMIs.
+func (a *AWS) imageBuildsOf(action Action, amis []types.Image) ([]imageBuild, error) {
+	var output []imageBuild
+	if a.cfg.imageBuilder() != ImageBuilderDelete || !action.Deregisters() || len(amis) == 0 {
+		return output, nil
+	}
+
+	builds, err := listImageBuilds(a.ctx(), a.imagebuilder, func(operation string, fn func() error) error {
+		return a.call(operation, fn)
+	})
+	if err != nil {
+		return output, err
+	}
+
+	ids := make(map[string]bool, len(amis))
+	for _, ami := range amis {
+		ids[aws.ToString(ami.ImageId)] = true
+	}
+	for _, b := range builds {
+		for _, ami := range b.AMIs {
+			if ids[aws.ToString(ami.Image)] {
+				output = append(output, b)
+				break
+			}
+		}
+	}
+
+	return output, nil
+}
+
+// deleteImageBuilds deletes the Image Builder image of every build whose AMIs are all
+// in deregistered, adding its ARN to output. Builds with AMIs elsewhere, or with AMIs
+// that were not deregistered, are kept. Image Builder has no dry run, so nothing is
+// deleted or added to output in a dry run.
+func (a *AWS) deleteImageBuilds(builds []imageBuild, deregistered []string, output *[]string, eda *ErrDeleteAMIs) {
+	done := make(map[string]bool, len(deregistered))
+	for _, id := range deregistered {
+		done[id] = true
+	}
+
+	for _, b := range builds {
+		complete := true
+		for _, ami := range b.AMIs {
+			if aws.ToString(ami.Region) != a.region || !done[aws.ToString(ami.Image)] {
+				complete = false
+				break
+			}
+		}
+		if !complete {
+			a.cfg.logger().Info("keeping Image Builder image with AMIs that were not deregistered", "arn", b.Arn)
+			continue
+		}
+
+		if a.cfg.dryRun() {
+			a.cfg.logger().Info("would delete Image Builder image", "arn", b.Arn)
+			continue
+		}
+
+		arn := b.Arn
+		a.mutate(arn, output, eda, func() error { return a.deleteImageBuild(arn) })
+	}
+}
+
+// deleteImageBuild deletes a single Image Builder image build version.
+func (a *AWS) deleteImageBuild(arn string) error {
+	err := a.audited("DeleteImage", arn, func() error {
+		_, err := a.imagebuilder.DeleteImage(a.mutationCtx(), &imagebuilder.DeleteImageInput{
+			ImageBuildVersionArn: aws.String(arn),
+		})
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeleteImageBuilderImage, err)
+	}
+
+	return nil
+}
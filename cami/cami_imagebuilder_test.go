@@ -0,0 +1,287 @@
+package cami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/imagebuilder"
+	ibtypes "github.com/aws/aws-sdk-go-v2/service/imagebuilder/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+const (
+	testWebVersion    = "arn:aws:imagebuilder:us-east-1:123456789012:image/web/1.0.0"
+	testWebBuild1     = "arn:aws:imagebuilder:us-east-1:123456789012:image/web/1.0.0/1"
+	testWebBuild2     = "arn:aws:imagebuilder:us-east-1:123456789012:image/web/1.0.0/2"
+	testGPUVersion    = "arn:aws:imagebuilder:us-east-1:123456789012:image/gpu/1.0.0"
+	testGPUBuild      = "arn:aws:imagebuilder:us-east-1:123456789012:image/gpu/1.0.0/1"
+	testDockerVersion = "arn:aws:imagebuilder:us-east-1:123456789012:image/docker/1.0.0"
+)
+
+// testImageBuilder returns an Image Builder with two web builds in us-east-1, one gpu
+// build distributed to us-east-1 and eu-west-1, and a container image.
+func testImageBuilder() *mockImageBuilder {
+	ami := func(id, region string) ibtypes.Ami {
+		return ibtypes.Ami{Image: aws.String(id), Region: aws.String(region)}
+	}
+	build := func(arn string, amis ...ibtypes.Ami) ibtypes.ImageSummary {
+		return ibtypes.ImageSummary{Arn: aws.String(arn), OutputResources: &ibtypes.OutputResources{Amis: amis}}
+	}
+
+	return &mockImageBuilder{
+		RespListImages: map[string]imagebuilder.ListImagesOutput{
+			"": {
+				ImageVersionList: []ibtypes.ImageVersion{
+					{Arn: aws.String(testWebVersion), Type: ibtypes.ImageTypeAmi},
+					{Arn: aws.String(testDockerVersion), Type: ibtypes.ImageTypeDocker},
+				},
+				NextToken: aws.String("page2"),
+			},
+			"page2": {
+				ImageVersionList: []ibtypes.ImageVersion{
+					{Arn: aws.String(testGPUVersion), Type: ibtypes.ImageTypeAmi},
+				},
+			},
+		},
+		RespListImageBuildVersions: map[string]imagebuilder.ListImageBuildVersionsOutput{
+			testWebVersion: {ImageSummaryList: []ibtypes.ImageSummary{
+				build(testWebBuild1, ami("ami-111", "us-east-1")),
+				build(testWebBuild2, ami("ami-222", "us-east-1")),
+				{Arn: aws.String(testWebVersion + "/3")},
+			}},
+			testGPUVersion: {ImageSummaryList: []ibtypes.ImageSummary{
+				build(testGPUBuild, ami("ami-333", "us-east-1"), ami("ami-444", "eu-west-1")),
+			}},
+		},
+	}
+}
+
+func TestImageBuilderDetector(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		give    *mockImageBuilder
+		want    []Usage
+		wantErr error
+	}{
+		{
+			name: "images",
+			give: testImageBuilder(),
+			want: []Usage{
+				{ImageID: "ami-111", Reason: "produced by Image Builder image " + testWebBuild1},
+				{ImageID: "ami-222", Reason: "produced by Image Builder image " + testWebBuild2},
+				{ImageID: "ami-333", Reason: "produced by Image Builder image " + testGPUBuild},
+				{ImageID: "ami-444", Reason: "produced by Image Builder image " + testGPUBuild},
+			},
+		},
+		{
+			name:    "list images error",
+			give:    &mockImageBuilder{RespListImagesErr: fmt.Errorf("FAIL")},
+			wantErr: ErrListImageBuilderImages,
+		},
+		{
+			name: "list build versions error",
+			give: func() *mockImageBuilder {
+				m := testImageBuilder()
+				m.RespListImageBuildVersionsErr = fmt.Errorf("FAIL")
+				return m
+			}(),
+			wantErr: ErrListImageBuilderImages,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := &ImageBuilderDetector{ib: tt.give}
+			got, err := d.Detect(context.Background())
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+		})
+	}
+}
+
+func TestDeleteAMIsImageBuilder(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{ImageId: aws.String("ami-111")},
+		{ImageId: aws.String("ami-222")},
+		{ImageId: aws.String("ami-333")},
+	}
+
+	tests := []struct {
+		name        string
+		giveMode    ImageBuilderMode
+		giveAction  Action
+		giveDryRun  bool
+		giveEC2     *mockEC2
+		giveIB      func(*mockImageBuilder)
+		wantOutput  []string
+		wantImages  []string
+		wantDeleted []string
+		wantErr     error
+	}{
+		{
+			name:        "off",
+			giveMode:    ImageBuilderOff,
+			giveAction:  ActionDeregister,
+			giveEC2:     &mockEC2{},
+			wantOutput:  []string{"ami-111", "ami-222", "ami-333"},
+			wantDeleted: nil,
+		},
+		{
+			name:       "delete",
+			giveMode:   ImageBuilderDelete,
+			giveAction: ActionDeregister,
+			giveEC2:    &mockEC2{},
+			// the gpu image still has an AMI in eu-west-1 so it is kept
+			wantOutput:  []string{"ami-111", "ami-222", "ami-333"},
+			wantImages:  []string{testWebBuild1, testWebBuild2},
+			wantDeleted: []string{testWebBuild1, testWebBuild2},
+		},
+		{
+			name:        "dryrun",
+			giveMode:    ImageBuilderDelete,
+			giveAction:  ActionDeregister,
+			giveDryRun:  true,
+			giveEC2:     &mockEC2{RespDeregisterImageErr: mockErr{ErrCode: "DryRunOperation"}},
+			wantOutput:  []string{"ami-111", "ami-222", "ami-333"},
+			wantImages:  nil,
+			wantDeleted: nil,
+		},
+		{
+			name:        "deregister failed",
+			giveMode:    ImageBuilderDelete,
+			giveAction:  ActionDeregister,
+			giveEC2:     &mockEC2{RespDeregisterImageErr: fmt.Errorf("FAIL")},
+			wantOutput:  nil,
+			wantDeleted: nil,
+			wantErr:     &ErrDeleteAMIs{},
+		},
+		{
+			name:        "not deregistered",
+			giveMode:    ImageBuilderDelete,
+			giveAction:  ActionDeprecate,
+			giveEC2:     &mockEC2{},
+			wantOutput:  []string{"ami-111", "ami-222", "ami-333"},
+			wantDeleted: nil,
+		},
+		{
+			name:        "delete image error",
+			giveMode:    ImageBuilderDelete,
+			giveAction:  ActionDeregister,
+			giveEC2:     &mockEC2{},
+			giveIB:      func(m *mockImageBuilder) { m.RespDeleteImageErr = fmt.Errorf("FAIL") },
+			wantOutput:  []string{"ami-111", "ami-222", "ami-333"},
+			wantDeleted: []string{testWebBuild1, testWebBuild2},
+			wantErr:     &ErrDeleteAMIs{},
+		},
+		{
+			name:        "list error",
+			giveMode:    ImageBuilderDelete,
+			giveAction:  ActionDeregister,
+			giveEC2:     &mockEC2{},
+			giveIB:      func(m *mockImageBuilder) { m.RespListImagesErr = fmt.Errorf("FAIL") },
+			wantOutput:  nil,
+			wantDeleted: nil,
+			wantErr:     ErrListImageBuilderImages,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			ib := testImageBuilder()
+			if tt.giveIB != nil {
+				tt.giveIB(ib)
+			}
+			a := AWS{
+				cfg:          &Config{ImageBuilder: tt.giveMode, Action: tt.giveAction, DryRun: tt.giveDryRun},
+				ec2:          tt.giveEC2,
+				imagebuilder: ib,
+				region:       "us-east-1",
+			}
+
+			output, err := a.deleteAMIs(amis)
+
+			var eda *ErrDeleteAMIs
+			switch {
+			case tt.wantErr == nil:
+				assert.Nil(t, err)
+			case errors.As(tt.wantErr, &eda):
+				assert.True(t, errors.As(err, &eda), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			default:
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantOutput, output.IDs)
+			assert.Equal(t, tt.wantImages, output.ImageBuilderImages)
+			assert.Equal(t, tt.wantDeleted, ib.Deleted)
+		})
+	}
+}
+
+func TestApplyImageBuilder(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics()
+	a := AWS{
+		cfg:          &Config{ImageBuilder: ImageBuilderDelete, Metrics: m},
+		ec2:          &mockEC2{},
+		imagebuilder: testImageBuilder(),
+		region:       "us-east-1",
+	}
+
+	result, err := a.Apply(&Plan{Candidates: []types.Image{{ImageId: aws.String("ami-111")}}})
+	assert.Nil(t, err)
+	assert.Equal(t, []string{"ami-111"}, result.IDs)
+	assert.Equal(t, []string{testWebBuild1}, result.ImageBuilderImages)
+	assert.Equal(t, []string{testWebBuild1}, NewOutcome(result, err).ImageBuilderImages)
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.deleted.WithLabelValues(string(ActionDeregister))))
+}
+
+func TestImageBuildsOfAPICalls(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics()
+	a := AWS{
+		cfg:          &Config{ImageBuilder: ImageBuilderDelete, Metrics: m},
+		imagebuilder: testImageBuilder(),
+	}
+
+	builds, err := a.imageBuildsOf(ActionDeregister, []types.Image{{ImageId: aws.String("ami-111")}})
+	assert.Nil(t, err)
+	assert.Len(t, builds, 1)
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.apiCalls.WithLabelValues("ListImages")))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.apiCalls.WithLabelValues("ListImageBuildVersions")))
+}
+
+func TestConfigImageBuilder(t *testing.T) {
+	t.Parallel()
+
+	var c *Config
+	assert.Equal(t, ImageBuilderOff, c.imageBuilder())
+	assert.Equal(t, ImageBuilderOff, (&Config{}).imageBuilder())
+
+	ds := (&Config{ImageBuilder: ImageBuilderProtect}).detectors(aws.Config{})
+	assert.Len(t, ds, 1)
+	assert.IsType(t, &ImageBuilderDetector{}, ds[0])
+
+	assert.Empty(t, (&Config{ImageBuilder: ImageBuilderDelete}).detectors(aws.Config{}))
+}
@@ -0,0 +1,125 @@
+package cami
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// launchConcurrency is how many image attributes we describe at once.
+const launchConcurrency = 10
+
+// filterLaunched returns the AMIs that have not been launched since the cutoff.
+func (a *AWS) filterLaunched(amis []types.Image, cutoff time.Time) ([]types.Image, error) {
+	var output []types.Image
+
+	launched, err := a.LastLaunched(amis)
+	if err != nil {
+		return output, err
+	}
+
+	for _, ami := range amis {
+		t, ok := launched[*ami.ImageId]
+		if ok && t.After(cutoff) {
+			continue
+		}
+		output = append(output, ami)
+	}
+
+	return output, nil
+}
+
+// LastLaunched returns the last time each of the provided AMIs was used to launch an
+// instance. AMIs that have never been launched are not included in the result.
+// Lookups are made concurrently and cached until the next DeleteUnusedAMIs run.
+func (a *AWS) LastLaunched(amis []types.Image) (map[string]time.Time, error) {
+	output := make(map[string]time.Time)
+
+	var mu sync.Mutex
+	var wg sync.WaitGroup
+	var firstErr error
+
+	sem := make(chan struct{}, launchConcurrency)
+	for _, ami := range amis {
+		id := *ami.ImageId
+
+		wg.Add(1)
+		sem <- struct{}{}
+		go func() {
+			defer wg.Done()
+			defer func() { <-sem }()
+
+			t, ok, err := a.lastLaunchedTime(id)
+
+			mu.Lock()
+			defer mu.Unlock()
+			if err != nil {
+				if firstErr == nil {
+					firstErr = err
+				}
+				return
+			}
+			if ok {
+				output[id] = t
+			}
+		}()
+	}
+	wg.Wait()
+
+	if firstErr != nil {
+		return output, firstErr
+	}
+
+	return output, nil
+}
+
+// lastLaunchedTime returns the last launched time of a single AMI, using the cache if
+// possible. The bool is false if the AMI has never been launched.
+func (a *AWS) lastLaunchedTime(id string) (time.Time, bool, error) {
+	a.lastLaunchedMu.Lock()
+	t, ok := a.lastLaunched[id]
+	a.lastLaunchedMu.Unlock()
+	if ok {
+		return t, !t.IsZero(), nil
+	}
+
+	attrI := &ec2.DescribeImageAttributeInput{
+		ImageId:   &id,
+		Attribute: types.ImageAttributeNameLastLaunchedTime,
+	}
+	var attrO *ec2.DescribeImageAttributeOutput
+	err := a.call("DescribeImageAttribute", func() error {
+		var err error
+		attrO, err = a.ec2.DescribeImageAttribute(a.ctx(), attrI)
+		return err
+	}, "resource_id", id)
+	if err != nil {
+		return t, false, fmt.Errorf("%w", ErrDescribeImageAttribute)
+	}
+
+	if attrO.LastLaunchedTime != nil && attrO.LastLaunchedTime.Value != nil {
+		t, err = time.Parse(time.RFC3339, *attrO.LastLaunchedTime.Value)
+		if err != nil {
+			return t, false, fmt.Errorf("%w", ErrDescribeImageAttribute)
+		}
+	}
+
+	a.lastLaunchedMu.Lock()
+	if a.lastLaunched == nil {
+		a.lastLaunched = make(map[string]time.Time)
+	}
+	a.lastLaunched[id] = t
+	a.lastLaunchedMu.Unlock()
+
+	return t, !t.IsZero(), nil
+}
+
+// resetLastLaunched clears the last launched cache at the start of a run.
+func (a *AWS) resetLastLaunched() {
+	a.lastLaunchedMu.Lock()
+	a.lastLaunched = nil
+	a.lastLaunchedMu.Unlock()
+}
@@ -0,0 +1,120 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestLastLaunched(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		giveAMIs   []types.Image
+		giveOutput map[string]ec2.DescribeImageAttributeOutput
+		giveErr    error
+		wantTimes  map[string]time.Time
+		wantErr    error
+	}{
+		{
+			name:      "empty",
+			giveAMIs:  nil,
+			wantTimes: map[string]time.Time{},
+			wantErr:   nil,
+		},
+		{
+			name: "error",
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+			},
+			giveErr:   fmt.Errorf("FAIL"),
+			wantTimes: map[string]time.Time{},
+			wantErr:   ErrDescribeImageAttribute,
+		},
+		{
+			name: "bad time",
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+			},
+			giveOutput: map[string]ec2.DescribeImageAttributeOutput{
+				"ami-123": {LastLaunchedTime: &types.AttributeValue{Value: aws.String("yesterday")}},
+			},
+			wantTimes: map[string]time.Time{},
+			wantErr:   ErrDescribeImageAttribute,
+		},
+		{
+			name: "launched and never launched",
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+				{ImageId: aws.String("ami-456")},
+			},
+			giveOutput: map[string]ec2.DescribeImageAttributeOutput{
+				"ami-123": {LastLaunchedTime: &types.AttributeValue{Value: aws.String("2021-01-09T12:00:00.000Z")}},
+			},
+			wantTimes: map[string]time.Time{
+				"ami-123": time.Date(2021, 1, 9, 12, 0, 0, 0, time.UTC),
+			},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{
+				ec2: &mockEC2{
+					RespDescImageAttribute:    tt.giveOutput,
+					RespDescImageAttributeErr: tt.giveErr,
+				},
+			}
+
+			times, err := aws.LastLaunched(tt.giveAMIs)
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantTimes, times)
+		})
+	}
+}
+
+func TestLastLaunchedCache(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{ImageId: aws.String("ami-123")},
+		{ImageId: aws.String("ami-456")},
+	}
+
+	var calls int32
+	a := AWS{
+		ec2: &mockEC2{
+			RespDescImageAttribute: map[string]ec2.DescribeImageAttributeOutput{
+				"ami-123": {LastLaunchedTime: &types.AttributeValue{Value: aws.String("2021-01-09T12:00:00Z")}},
+			},
+			DescImageAttributeCalls: &calls,
+		},
+	}
+	_, err := a.LastLaunched(amis)
+	assert.Nil(t, err)
+	_, err = a.LastLaunched(amis)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(2), calls)
+
+	a.resetLastLaunched()
+	_, err = a.LastLaunched(amis)
+	assert.Nil(t, err)
+	assert.Equal(t, int32(4), calls)
+}
@@ -0,0 +1,38 @@
+package cami
+
+import (
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// percent is used to turn fractions into percentages.
+const percent = 100
+
+// CheckLimits returns an ErrSafetyLimit if acting on the candidates in the plan would
+// exceed the configured MaxImages or MaxPercent, unless OverrideLimits is set.
+func (a *AWS) CheckLimits(plan *Plan) error {
+	return a.checkLimits(plan.Candidates, plan.Images)
+}
+
+// checkLimits returns an ErrSafetyLimit if acting on amis would exceed the configured
+// limits, given all the images owned by the account.
+func (a *AWS) checkLimits(amis, owned []types.Image) error {
+	if a.cfg == nil || a.cfg.OverrideLimits || len(amis) == 0 {
+		return nil
+	}
+
+	err := &ErrSafetyLimit{
+		Count:      len(amis),
+		Owned:      len(owned),
+		MaxImages:  a.cfg.MaxImages,
+		MaxPercent: a.cfg.MaxPercent,
+	}
+
+	if a.cfg.MaxImages > 0 && len(amis) > a.cfg.MaxImages {
+		return err
+	}
+	if a.cfg.MaxPercent > 0 && len(owned) > 0 && err.Percent() > a.cfg.MaxPercent {
+		return err
+	}
+
+	return nil
+}
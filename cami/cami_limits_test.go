@@ -0,0 +1,98 @@
+package cami
+
+import (
+	"errors"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCheckLimits(t *testing.T) {
+	t.Parallel()
+
+	owned := []types.Image{
+		{ImageId: aws.String("ami-1")},
+		{ImageId: aws.String("ami-2")},
+		{ImageId: aws.String("ami-3")},
+		{ImageId: aws.String("ami-4")},
+	}
+
+	tests := []struct {
+		name     string
+		giveCfg  *Config
+		givePlan *Plan
+		wantErr  error
+	}{
+		{
+			name:     "nil config",
+			giveCfg:  nil,
+			givePlan: &Plan{Images: owned, Candidates: owned},
+			wantErr:  nil,
+		},
+		{
+			name:     "no limits",
+			giveCfg:  &Config{},
+			givePlan: &Plan{Images: owned, Candidates: owned},
+			wantErr:  nil,
+		},
+		{
+			name:     "within limits",
+			giveCfg:  &Config{MaxImages: 2, MaxPercent: 50},
+			givePlan: &Plan{Images: owned, Candidates: owned[:2]},
+			wantErr:  nil,
+		},
+		{
+			name:     "max images",
+			giveCfg:  &Config{MaxImages: 2},
+			givePlan: &Plan{Images: owned, Candidates: owned[:3]},
+			wantErr:  &ErrSafetyLimit{Count: 3, Owned: 4, MaxImages: 2},
+		},
+		{
+			name:     "max percent",
+			giveCfg:  &Config{MaxPercent: 50},
+			givePlan: &Plan{Images: owned, Candidates: owned[:3]},
+			wantErr:  &ErrSafetyLimit{Count: 3, Owned: 4, MaxPercent: 50},
+		},
+		{
+			name:     "override",
+			giveCfg:  &Config{MaxImages: 1, MaxPercent: 1, OverrideLimits: true},
+			givePlan: &Plan{Images: owned, Candidates: owned},
+			wantErr:  nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{cfg: tt.giveCfg}
+
+			err := aws.CheckLimits(tt.givePlan)
+
+			assert.Equal(t, tt.wantErr, err)
+		})
+	}
+}
+
+func TestApplyLimits(t *testing.T) {
+	t.Parallel()
+
+	a := AWS{
+		cfg: &Config{MaxImages: 1},
+		ec2: &mockEC2{},
+	}
+	plan := &Plan{
+		Images:     []types.Image{{ImageId: aws.String("ami-1")}, {ImageId: aws.String("ami-2")}},
+		Candidates: []types.Image{{ImageId: aws.String("ami-1")}, {ImageId: aws.String("ami-2")}},
+	}
+
+	result, err := a.Apply(plan)
+
+	var esl *ErrSafetyLimit
+	assert.True(t, errors.As(err, &esl))
+	assert.Equal(t, "safety limit: run would act on 2 of 2 AMIs (100.0%), limits are 1 AMIs and 0.0%", err.Error())
+	assert.Nil(t, result.IDs)
+}
@@ -0,0 +1,31 @@
+package cami
+
+import (
+	"log/slog"
+	"time"
+)
+
+// logger returns the configured logger or one that discards everything.
+func (c *Config) logger() *slog.Logger {
+	if c == nil || c.Logger == nil {
+		return slog.New(slog.DiscardHandler)
+	}
+	return c.Logger
+}
+
+// call runs fn, a single AWS API call, logs the operation, how long it took and any
+// error at debug level, and counts it in the configured metrics. args are added to the
+// log event.
+func (a *AWS) call(operation string, fn func() error, args ...any) error {
+	start := time.Now()
+	err := fn()
+
+	args = append(args, slog.String("operation", operation), slog.Duration("duration", time.Since(start)))
+	if err != nil {
+		args = append(args, slog.Any("error", err))
+	}
+	a.cfg.logger().Debug("aws call", args...)
+	a.cfg.metrics().apiCall(operation, err)
+
+	return err
+}
@@ -0,0 +1,83 @@
+package cami
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"log/slog"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestCallLogs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		giveEC2  *mockEC2
+		wantOps  []string
+		wantErrs []bool
+	}{
+		{
+			name:     "success",
+			giveEC2:  &mockEC2{},
+			wantOps:  []string{"DeregisterImage", "DeleteSnapshot"},
+			wantErrs: []bool{false, false},
+		},
+		{
+			name:     "error",
+			giveEC2:  &mockEC2{RespDeleteSnapshotErr: fmt.Errorf("FAIL")},
+			wantOps:  []string{"DeregisterImage", "DeleteSnapshot"},
+			wantErrs: []bool{false, true},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var buf bytes.Buffer
+			logger := slog.New(slog.NewJSONHandler(&buf, &slog.HandlerOptions{Level: slog.LevelDebug}))
+			a := AWS{cfg: &Config{Logger: logger}, ec2: tt.giveEC2}
+
+			_, _ = a.DeleteAMIs([]types.Image{
+				{
+					ImageId: aws.String("ami-123"),
+					BlockDeviceMappings: []types.BlockDeviceMapping{
+						{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+					},
+				},
+			})
+
+			var ops []string
+			var errs []bool
+			for _, line := range strings.Split(strings.TrimSpace(buf.String()), "\n") {
+				var event map[string]any
+				assert.Nil(t, json.Unmarshal([]byte(line), &event))
+				assert.Equal(t, "DEBUG", event["level"])
+				assert.Contains(t, event, "duration")
+				assert.Contains(t, event, "resource_id")
+
+				ops = append(ops, event["operation"].(string))
+				_, hasErr := event["error"]
+				errs = append(errs, hasErr)
+			}
+
+			assert.Equal(t, tt.wantOps, ops)
+			assert.Equal(t, tt.wantErrs, errs)
+		})
+	}
+}
+
+func TestLoggerDefault(t *testing.T) {
+	t.Parallel()
+
+	var c *Config
+	assert.NotNil(t, c.logger())
+	assert.NotNil(t, (&Config{}).logger())
+}
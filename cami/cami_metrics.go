@@ -0,0 +1,179 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/prometheus/client_golang/prometheus"
+	"github.com/prometheus/client_golang/prometheus/promhttp"
+)
+
+// metricsNamespace prefixes the name of every metric.
+const metricsNamespace = "cami"
+
+// Metrics holds Prometheus metrics describing cami runs. A nil *Metrics is valid and
+// records nothing.
+type Metrics struct {
+	reg *prometheus.Registry
+
+	imagesScanned  prometheus.Gauge
+	candidates     prometheus.Gauge
+	deleted        *prometheus.CounterVec
+	failed         *prometheus.CounterVec
+	reclaimedBytes prometheus.Counter
+	apiCalls       *prometheus.CounterVec
+	apiErrors      *prometheus.CounterVec
+	runDuration    prometheus.Gauge
+	lastRun        prometheus.Gauge
+	lastRunSuccess prometheus.Gauge
+}
+
+// NewMetrics returns Metrics registered with their own registry.
+func NewMetrics() *Metrics {
+	m := &Metrics{
+		reg: prometheus.NewRegistry(),
+
+		imagesScanned: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "images_scanned",
+			Help:      "Number of AMIs owned by the account in the last plan.",
+		}),
+		candidates: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "candidates",
+			Help:      "Number of unused AMIs in the last plan.",
+		}),
+		deleted: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "deleted_total",
+			Help:      "AMIs and snapshots successfully acted on, by action. Dry runs are not counted.",
+		}, []string{"action"}),
+		failed: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "failed_total",
+			Help:      "AMIs and snapshots that could not be acted on, by action.",
+		}, []string{"action"}),
+		reclaimedBytes: prometheus.NewCounter(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "reclaimed_bytes_total",
+			Help:      "Snapshot storage reclaimed from standard tier storage. Dry runs are not counted.",
+		}),
+		apiCalls: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_calls_total",
+			Help:      "AWS API calls made, by operation.",
+		}, []string{"operation"}),
+		apiErrors: prometheus.NewCounterVec(prometheus.CounterOpts{
+			Namespace: metricsNamespace,
+			Name:      "api_errors_total",
+			Help:      "AWS API calls that failed, by operation. Dry runs are not errors.",
+		}, []string{"operation"}),
+		runDuration: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "run_duration_seconds",
+			Help:      "How long the last run took.",
+		}),
+		lastRun: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_run_timestamp_seconds",
+			Help:      "Unix time the last run finished.",
+		}),
+		lastRunSuccess: prometheus.NewGauge(prometheus.GaugeOpts{
+			Namespace: metricsNamespace,
+			Name:      "last_run_success",
+			Help:      "1 if the last run succeeded, 0 otherwise.",
+		}),
+	}
+
+	m.reg.MustRegister(
+		m.imagesScanned, m.candidates, m.deleted, m.failed, m.reclaimedBytes,
+		m.apiCalls, m.apiErrors, m.runDuration, m.lastRun, m.lastRunSuccess,
+	)
+
+	return m
+}
+
+// Handler returns an http.Handler that serves the metrics in Prometheus text format.
+func (m *Metrics) Handler() http.Handler {
+	return promhttp.HandlerFor(m.reg, promhttp.HandlerOpts{})
+}
+
+// WriteFile writes the metrics in Prometheus text format to path, for use with the
+// node_exporter textfile collector. The file is replaced atomically.
+func (m *Metrics) WriteFile(path string) error {
+	err := prometheus.WriteToTextfile(path, m.reg)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrWriteMetrics, err)
+	}
+	return nil
+}
+
+// ObserveRun records how long a run took, when it finished and whether it succeeded.
+func (m *Metrics) ObserveRun(d time.Duration, err error) {
+	if m == nil {
+		return
+	}
+
+	m.runDuration.Set(d.Seconds())
+	m.lastRun.SetToCurrentTime()
+	if err != nil {
+		m.lastRunSuccess.Set(0)
+	} else {
+		m.lastRunSuccess.Set(1)
+	}
+}
+
+// metrics returns the configured metrics or nil.
+func (c *Config) metrics() *Metrics {
+	if c == nil {
+		return nil
+	}
+	return c.Metrics
+}
+
+// apiCall records a single AWS API call and whether it failed.
+func (m *Metrics) apiCall(operation string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.apiCalls.WithLabelValues(operation).Inc()
+	if err != nil && !isDryRun(err) {
+		m.apiErrors.WithLabelValues(operation).Inc()
+	}
+}
+
+// planned records the size of a plan.
+func (m *Metrics) planned(plan *Plan) {
+	if m == nil {
+		return
+	}
+
+	m.imagesScanned.Set(float64(len(plan.Images)))
+	m.candidates.Set(float64(len(plan.Candidates)))
+}
+
+// acted records the IDs that were acted on and the error returned by DeleteAMIs.
+func (m *Metrics) acted(action Action, ids []string, err error) {
+	if m == nil {
+		return
+	}
+
+	m.deleted.WithLabelValues(string(action)).Add(float64(len(ids)))
+
+	var eda *ErrDeleteAMIs
+	if errors.As(err, &eda) {
+		m.failed.WithLabelValues(string(action)).Add(float64(len(eda.IDs)))
+	}
+}
+
+// reclaimed records the snapshot storage reclaimed by a run.
+func (m *Metrics) reclaimed(storage *StorageReport) {
+	if m == nil || storage == nil {
+		return
+	}
+
+	m.reclaimedBytes.Add(float64(storage.Bytes))
+}
@@ -0,0 +1,172 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMetrics(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name          string
+		giveDryRun    bool
+		giveEC2       *mockEC2
+		wantDeleted   float64
+		wantFailed    float64
+		wantAPIErrors float64
+		wantSuccess   float64
+	}{
+		{
+			name:        "success",
+			giveEC2:     &mockEC2{},
+			wantDeleted: 2,
+			wantSuccess: 1,
+		},
+		{
+			name:       "dryrun",
+			giveDryRun: true,
+			giveEC2: &mockEC2{
+				RespDeregisterImageErr: mockErr{ErrCode: "DryRunOperation"},
+				RespDeleteSnapshotErr:  mockErr{ErrCode: "DryRunOperation"},
+			},
+			wantDeleted: 0,
+			wantSuccess: 1,
+		},
+		{
+			name:          "error",
+			giveEC2:       &mockEC2{RespDeleteSnapshotErr: fmt.Errorf("FAIL")},
+			wantDeleted:   1,
+			wantFailed:    1,
+			wantAPIErrors: 1,
+			wantSuccess:   0,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			tt.giveEC2.RespDescImages = ec2.DescribeImagesOutput{
+				Images: []types.Image{
+					{
+						ImageId: aws.String("ami-123"),
+						BlockDeviceMappings: []types.BlockDeviceMapping{
+							{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+						},
+					},
+					{ImageId: aws.String("ami-456")},
+				},
+			}
+			tt.giveEC2.RespDescInstances = ec2.DescribeInstancesOutput{
+				Reservations: []types.Reservation{
+					{Instances: []types.Instance{{ImageId: aws.String("ami-456")}}},
+				},
+			}
+			tt.giveEC2.RespDescSnapshots = ec2.DescribeSnapshotsOutput{
+				Snapshots: []types.Snapshot{
+					{SnapshotId: aws.String("snap-123"), VolumeSize: aws.Int32(1)},
+				},
+			}
+
+			m := NewMetrics()
+			a := AWS{cfg: &Config{DryRun: tt.giveDryRun, Metrics: m}, ec2: tt.giveEC2}
+
+			_, _ = a.DeleteUnusedAMIs()
+
+			action := string(ActionDeregister)
+			assert.Equal(t, 2.0, testutil.ToFloat64(m.imagesScanned))
+			assert.Equal(t, 1.0, testutil.ToFloat64(m.candidates))
+			assert.Equal(t, tt.wantDeleted, testutil.ToFloat64(m.deleted.WithLabelValues(action)))
+			assert.Equal(t, tt.wantFailed, testutil.ToFloat64(m.failed.WithLabelValues(action)))
+			assert.Equal(t, 1.0, testutil.ToFloat64(m.apiCalls.WithLabelValues("DeleteSnapshot")))
+			assert.Equal(t, tt.wantAPIErrors, testutil.ToFloat64(m.apiErrors.WithLabelValues("DeleteSnapshot")))
+			assert.Equal(t, tt.wantSuccess, testutil.ToFloat64(m.lastRunSuccess))
+			assert.NotZero(t, testutil.ToFloat64(m.lastRun))
+		})
+	}
+}
+
+func TestMetricsReclaimed(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		giveDryRun bool
+		want       float64
+	}{
+		{name: "success", want: float64(gib)},
+		{name: "dryrun", giveDryRun: true, want: 0},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			m := NewMetrics()
+			a := AWS{cfg: &Config{DryRun: tt.giveDryRun, Metrics: m}, ec2: &mockEC2{}}
+
+			plan := &Plan{
+				Candidates: []types.Image{{ImageId: aws.String("ami-123")}},
+				Storage: &StorageReport{
+					Images: []ImageStorage{
+						{
+							ImageID:   "ami-123",
+							Snapshots: []SnapshotStorage{{SnapshotID: "snap-123", Bytes: gib}},
+						},
+					},
+				},
+			}
+			plan.Candidates[0].BlockDeviceMappings = []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+			}
+
+			_, err := a.Apply(plan)
+			assert.Nil(t, err)
+			assert.Equal(t, tt.want, testutil.ToFloat64(m.reclaimedBytes))
+		})
+	}
+}
+
+func TestMetricsWriteFile(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	m := NewMetrics()
+	m.ObserveRun(time.Second, nil)
+
+	path := filepath.Join(dir, "cami.prom")
+	assert.Nil(t, m.WriteFile(path))
+
+	b, err := os.ReadFile(path)
+	assert.Nil(t, err)
+	assert.True(t, strings.Contains(string(b), "cami_run_duration_seconds 1\n"))
+	assert.True(t, strings.Contains(string(b), "cami_last_run_success 1\n"))
+
+	err = m.WriteFile(filepath.Join(dir, "missing", "cami.prom"))
+	assert.True(t, errors.Is(err, ErrWriteMetrics), fmt.Sprintf("expected: %s\ngot: %s", ErrWriteMetrics, err))
+}
+
+func TestMetricsNil(t *testing.T) {
+	t.Parallel()
+
+	var m *Metrics
+	m.ObserveRun(time.Second, nil)
+	m.apiCall("DescribeImages", nil)
+	m.planned(&Plan{})
+	m.acted(ActionDeregister, nil, nil)
+	m.reclaimed(&StorageReport{})
+}
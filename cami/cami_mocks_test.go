@@ -2,12 +2,39 @@ package cami
 
 import (
 	"context"
+	"sync"
+	"sync/atomic"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/cloudformation"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/eks"
+	"github.com/aws/aws-sdk-go-v2/service/eventbridge"
+	"github.com/aws/aws-sdk-go-v2/service/imagebuilder"
+	"github.com/aws/aws-sdk-go-v2/service/rbin"
+	"github.com/aws/aws-sdk-go-v2/service/sns"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/aws/smithy-go"
 )
 
-var _ ec2If = (*mockEC2)(nil)
+var (
+	_ ec2If  = (*mockEC2)(nil)
+	_ rbinIf = (*mockRbin)(nil)
+	_ stsIf  = (*mockSTS)(nil)
+
+	_ snsIf         = (*mockSNS)(nil)
+	_ eventBridgeIf = (*mockEventBridge)(nil)
+	_ Publisher     = (*mockPublisher)(nil)
+	_ Detector      = (*mockDetector)(nil)
+	_ ssmIf         = (*mockSSM)(nil)
+
+	_ cloudFormationIf  = (*mockCloudFormation)(nil)
+	_ imageBuilderIf    = (*mockImageBuilder)(nil)
+	_ eksIf             = (*mockEKS)(nil)
+	_ launchTemplatesIf = (*mockLaunchTemplates)(nil)
+)
 
 type mockEC2 struct {
 	RespDescImages    ec2.DescribeImagesOutput
@@ -16,11 +43,45 @@ type mockEC2 struct {
 	RespDescInstances    ec2.DescribeInstancesOutput
 	RespDescInstancesErr error
 
+	RespDescSnapshots    ec2.DescribeSnapshotsOutput
+	RespDescSnapshotsErr error
+
+	RespDescImageAttribute    map[string]ec2.DescribeImageAttributeOutput
+	RespDescImageAttributeErr error
+	DescImageAttributeCalls   *int32
+
 	RespDeregisterImage    ec2.DeregisterImageOutput
 	RespDeregisterImageErr error
 
 	RespDeleteSnapshot    ec2.DeleteSnapshotOutput
 	RespDeleteSnapshotErr error
+
+	RespEnableImageDeprecation    ec2.EnableImageDeprecationOutput
+	RespEnableImageDeprecationErr error
+
+	RespDisableImage    ec2.DisableImageOutput
+	RespDisableImageErr error
+
+	RespCreateTags    ec2.CreateTagsOutput
+	RespCreateTagsErr error
+
+	RespDeleteTags    ec2.DeleteTagsOutput
+	RespDeleteTagsErr error
+
+	RespRestoreImage    ec2.RestoreImageFromRecycleBinOutput
+	RespRestoreImageErr error
+
+	RespRestoreSnapshot    ec2.RestoreSnapshotFromRecycleBinOutput
+	RespRestoreSnapshotErr error
+
+	RespListSnapshotsInRecycleBin    ec2.ListSnapshotsInRecycleBinOutput
+	RespListSnapshotsInRecycleBinErr error
+
+	RespRegisterImage    ec2.RegisterImageOutput
+	RespRegisterImageErr error
+
+	RespModifySnapshotTier    ec2.ModifySnapshotTierOutput
+	RespModifySnapshotTierErr error
 }
 
 func (m mockEC2) DescribeImages(ctx context.Context, in *ec2.DescribeImagesInput, opts ...func(*ec2.Options)) (*ec2.DescribeImagesOutput, error) {
@@ -32,6 +93,20 @@ func (m mockEC2) DescribeInstances(context.Context, *ec2.DescribeInstancesInput,
 	return &m.RespDescInstances, m.RespDescInstancesErr
 }
 
+//nolint:lll
+func (m mockEC2) DescribeSnapshots(context.Context, *ec2.DescribeSnapshotsInput, ...func(*ec2.Options)) (*ec2.DescribeSnapshotsOutput, error) {
+	return &m.RespDescSnapshots, m.RespDescSnapshotsErr
+}
+
+//nolint:lll
+func (m mockEC2) DescribeImageAttribute(ctx context.Context, in *ec2.DescribeImageAttributeInput, opts ...func(*ec2.Options)) (*ec2.DescribeImageAttributeOutput, error) {
+	if m.DescImageAttributeCalls != nil {
+		atomic.AddInt32(m.DescImageAttributeCalls, 1)
+	}
+	out := m.RespDescImageAttribute[*in.ImageId]
+	return &out, m.RespDescImageAttributeErr
+}
+
 func (m mockEC2) DeregisterImage(context.Context, *ec2.DeregisterImageInput, ...func(*ec2.Options)) (*ec2.DeregisterImageOutput, error) {
 	return &m.RespDeregisterImage, m.RespDeregisterImageErr
 }
@@ -40,6 +115,75 @@ func (m mockEC2) DeleteSnapshot(context.Context, *ec2.DeleteSnapshotInput, ...fu
 	return &m.RespDeleteSnapshot, m.RespDeleteSnapshotErr
 }
 
+//nolint:lll
+func (m mockEC2) EnableImageDeprecation(context.Context, *ec2.EnableImageDeprecationInput, ...func(*ec2.Options)) (*ec2.EnableImageDeprecationOutput, error) {
+	return &m.RespEnableImageDeprecation, m.RespEnableImageDeprecationErr
+}
+
+func (m mockEC2) DisableImage(context.Context, *ec2.DisableImageInput, ...func(*ec2.Options)) (*ec2.DisableImageOutput, error) {
+	return &m.RespDisableImage, m.RespDisableImageErr
+}
+
+func (m mockEC2) CreateTags(context.Context, *ec2.CreateTagsInput, ...func(*ec2.Options)) (*ec2.CreateTagsOutput, error) {
+	return &m.RespCreateTags, m.RespCreateTagsErr
+}
+
+func (m mockEC2) DeleteTags(context.Context, *ec2.DeleteTagsInput, ...func(*ec2.Options)) (*ec2.DeleteTagsOutput, error) {
+	return &m.RespDeleteTags, m.RespDeleteTagsErr
+}
+
+//nolint:lll
+func (m mockEC2) RestoreImageFromRecycleBin(context.Context, *ec2.RestoreImageFromRecycleBinInput, ...func(*ec2.Options)) (*ec2.RestoreImageFromRecycleBinOutput, error) {
+	return &m.RespRestoreImage, m.RespRestoreImageErr
+}
+
+//nolint:lll
+func (m mockEC2) RestoreSnapshotFromRecycleBin(context.Context, *ec2.RestoreSnapshotFromRecycleBinInput, ...func(*ec2.Options)) (*ec2.RestoreSnapshotFromRecycleBinOutput, error) {
+	return &m.RespRestoreSnapshot, m.RespRestoreSnapshotErr
+}
+
+func (m mockEC2) ListSnapshotsInRecycleBin(context.Context, *ec2.ListSnapshotsInRecycleBinInput, ...func(*ec2.Options)) (*ec2.ListSnapshotsInRecycleBinOutput, error) {
+	return &m.RespListSnapshotsInRecycleBin, m.RespListSnapshotsInRecycleBinErr
+}
+
+func (m mockEC2) RegisterImage(context.Context, *ec2.RegisterImageInput, ...func(*ec2.Options)) (*ec2.RegisterImageOutput, error) {
+	return &m.RespRegisterImage, m.RespRegisterImageErr
+}
+
+//nolint:lll
+func (m mockEC2) ModifySnapshotTier(context.Context, *ec2.ModifySnapshotTierInput, ...func(*ec2.Options)) (*ec2.ModifySnapshotTierOutput, error) {
+	return &m.RespModifySnapshotTier, m.RespModifySnapshotTierErr
+}
+
+type mockRbin struct {
+	RespListRules    map[string]rbin.ListRulesOutput
+	RespListRulesErr error
+
+	RespGetRule    map[string]rbin.GetRuleOutput
+	RespGetRuleErr error
+
+	RespCreateRule    rbin.CreateRuleOutput
+	RespCreateRuleErr error
+	CreatedRules      *[]string
+}
+
+func (m mockRbin) ListRules(ctx context.Context, in *rbin.ListRulesInput, opts ...func(*rbin.Options)) (*rbin.ListRulesOutput, error) {
+	out := m.RespListRules[string(in.ResourceType)]
+	return &out, m.RespListRulesErr
+}
+
+func (m mockRbin) GetRule(ctx context.Context, in *rbin.GetRuleInput, opts ...func(*rbin.Options)) (*rbin.GetRuleOutput, error) {
+	out := m.RespGetRule[*in.Identifier]
+	return &out, m.RespGetRuleErr
+}
+
+func (m mockRbin) CreateRule(ctx context.Context, in *rbin.CreateRuleInput, opts ...func(*rbin.Options)) (*rbin.CreateRuleOutput, error) {
+	if m.CreatedRules != nil && m.RespCreateRuleErr == nil {
+		*m.CreatedRules = append(*m.CreatedRules, string(in.ResourceType))
+	}
+	return &m.RespCreateRule, m.RespCreateRuleErr
+}
+
 type mockErr struct {
 	error
 
@@ -61,3 +205,203 @@ func (m mockErr) ErrorMessage() string {
 func (m mockErr) ErrorFault() smithy.ErrorFault {
 	return 0
 }
+
+type mockSTS struct {
+	RespGetCallerIdentity    sts.GetCallerIdentityOutput
+	RespGetCallerIdentityErr error
+}
+
+func (m mockSTS) GetCallerIdentity(ctx context.Context, in *sts.GetCallerIdentityInput, opts ...func(*sts.Options)) (*sts.GetCallerIdentityOutput, error) {
+	return &m.RespGetCallerIdentity, m.RespGetCallerIdentityErr
+}
+
+type mockSNS struct {
+	RespPublish    sns.PublishOutput
+	RespPublishErr error
+
+	// Input is the last input Publish was called with
+	Input *sns.PublishInput
+}
+
+func (m *mockSNS) Publish(ctx context.Context, in *sns.PublishInput, opts ...func(*sns.Options)) (*sns.PublishOutput, error) {
+	m.Input = in
+	return &m.RespPublish, m.RespPublishErr
+}
+
+type mockEventBridge struct {
+	RespPutEvents    eventbridge.PutEventsOutput
+	RespPutEventsErr error
+
+	// Input is the last input PutEvents was called with
+	Input *eventbridge.PutEventsInput
+}
+
+func (m *mockEventBridge) PutEvents(ctx context.Context, in *eventbridge.PutEventsInput, opts ...func(*eventbridge.Options)) (*eventbridge.PutEventsOutput, error) {
+	m.Input = in
+	return &m.RespPutEvents, m.RespPutEventsErr
+}
+
+type mockPublisher struct {
+	RespPublishErr error
+
+	mu     sync.Mutex
+	Events []ImageEvent
+}
+
+func (m *mockPublisher) Publish(ctx context.Context, event ImageEvent) error {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	m.Events = append(m.Events, event)
+	return m.RespPublishErr
+}
+
+type mockDetector struct {
+	RespDetect    []Usage
+	RespDetectErr error
+}
+
+func (m *mockDetector) Name() string {
+	return "mock"
+}
+
+func (m *mockDetector) Detect(ctx context.Context) ([]Usage, error) {
+	return m.RespDetect, m.RespDetectErr
+}
+
+type mockSSM struct {
+	// RespGetParametersByPath is the response for each path, keyed by path and then by
+	// NextToken, with "" for the first page
+	RespGetParametersByPath    map[string]map[string]ssm.GetParametersByPathOutput
+	RespGetParametersByPathErr error
+
+	// Inputs is every input GetParametersByPath was called with
+	Inputs []*ssm.GetParametersByPathInput
+}
+
+func (m *mockSSM) GetParametersByPath(ctx context.Context, in *ssm.GetParametersByPathInput, opts ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error) {
+	m.Inputs = append(m.Inputs, in)
+	out := m.RespGetParametersByPath[aws.ToString(in.Path)][aws.ToString(in.NextToken)]
+	return &out, m.RespGetParametersByPathErr
+}
+
+type mockCloudFormation struct {
+	// RespDescribeStacks is the response for each page, keyed by NextToken with "" for
+	// the first page
+	RespDescribeStacks    map[string]cloudformation.DescribeStacksOutput
+	RespDescribeStacksErr error
+
+	// RespGetTemplate is the template body of each stack, keyed by stack ID
+	RespGetTemplate    map[string]string
+	RespGetTemplateErr error
+
+	// GetTemplateInputs is every input GetTemplate was called with
+	GetTemplateInputs []*cloudformation.GetTemplateInput
+}
+
+func (m *mockCloudFormation) DescribeStacks(ctx context.Context, in *cloudformation.DescribeStacksInput, opts ...func(*cloudformation.Options)) (*cloudformation.DescribeStacksOutput, error) {
+	out := m.RespDescribeStacks[aws.ToString(in.NextToken)]
+	return &out, m.RespDescribeStacksErr
+}
+
+func (m *mockCloudFormation) GetTemplate(ctx context.Context, in *cloudformation.GetTemplateInput, opts ...func(*cloudformation.Options)) (*cloudformation.GetTemplateOutput, error) {
+	m.GetTemplateInputs = append(m.GetTemplateInputs, in)
+	return &cloudformation.GetTemplateOutput{TemplateBody: aws.String(m.RespGetTemplate[aws.ToString(in.StackName)])}, m.RespGetTemplateErr
+}
+
+type mockImageBuilder struct {
+	// RespListImages is the response for each page, keyed by NextToken with "" for the
+	// first page
+	RespListImages    map[string]imagebuilder.ListImagesOutput
+	RespListImagesErr error
+
+	// RespListImageBuildVersions is the response for each image version, keyed by
+	// image version ARN
+	RespListImageBuildVersions    map[string]imagebuilder.ListImageBuildVersionsOutput
+	RespListImageBuildVersionsErr error
+
+	RespDeleteImageErr error
+
+	// Deleted is the ARN of every image DeleteImage was called with
+	Deleted []string
+}
+
+func (m *mockImageBuilder) ListImages(ctx context.Context, in *imagebuilder.ListImagesInput, opts ...func(*imagebuilder.Options)) (*imagebuilder.ListImagesOutput, error) {
+	out := m.RespListImages[aws.ToString(in.NextToken)]
+	return &out, m.RespListImagesErr
+}
+
+func (m *mockImageBuilder) ListImageBuildVersions(ctx context.Context, in *imagebuilder.ListImageBuildVersionsInput, opts ...func(*imagebuilder.Options)) (*imagebuilder.ListImageBuildVersionsOutput, error) {
+	out := m.RespListImageBuildVersions[aws.ToString(in.ImageVersionArn)]
+	return &out, m.RespListImageBuildVersionsErr
+}
+
+func (m *mockImageBuilder) DeleteImage(ctx context.Context, in *imagebuilder.DeleteImageInput, opts ...func(*imagebuilder.Options)) (*imagebuilder.DeleteImageOutput, error) {
+	m.Deleted = append(m.Deleted, aws.ToString(in.ImageBuildVersionArn))
+	return &imagebuilder.DeleteImageOutput{}, m.RespDeleteImageErr
+}
+
+type mockEKS struct {
+	// RespListClusters is the response for each page, keyed by NextToken with "" for
+	// the first page
+	RespListClusters    map[string]eks.ListClustersOutput
+	RespListClustersErr error
+
+	// RespListNodegroups is the response for each cluster, keyed by cluster name
+	RespListNodegroups    map[string]eks.ListNodegroupsOutput
+	RespListNodegroupsErr error
+
+	// RespDescribeNodegroup is the response for each node group, keyed by
+	// cluster/nodegroup
+	RespDescribeNodegroup    map[string]eks.DescribeNodegroupOutput
+	RespDescribeNodegroupErr error
+}
+
+func (m *mockEKS) ListClusters(ctx context.Context, in *eks.ListClustersInput, opts ...func(*eks.Options)) (*eks.ListClustersOutput, error) {
+	out := m.RespListClusters[aws.ToString(in.NextToken)]
+	return &out, m.RespListClustersErr
+}
+
+func (m *mockEKS) ListNodegroups(ctx context.Context, in *eks.ListNodegroupsInput, opts ...func(*eks.Options)) (*eks.ListNodegroupsOutput, error) {
+	out := m.RespListNodegroups[aws.ToString(in.ClusterName)]
+	return &out, m.RespListNodegroupsErr
+}
+
+func (m *mockEKS) DescribeNodegroup(ctx context.Context, in *eks.DescribeNodegroupInput, opts ...func(*eks.Options)) (*eks.DescribeNodegroupOutput, error) {
+	out := m.RespDescribeNodegroup[aws.ToString(in.ClusterName)+"/"+aws.ToString(in.NodegroupName)]
+	return &out, m.RespDescribeNodegroupErr
+}
+
+type mockLaunchTemplates struct {
+	RespDescribeLaunchTemplates    ec2.DescribeLaunchTemplatesOutput
+	RespDescribeLaunchTemplatesErr error
+
+	// RespDescribeLaunchTemplateVersions is the image of each launch template version,
+	// keyed by ID or name and then by version
+	RespDescribeLaunchTemplateVersions    map[string]map[string]string
+	RespDescribeLaunchTemplateVersionsErr error
+
+	// VersionInputs is every input DescribeLaunchTemplateVersions was called with
+	VersionInputs []*ec2.DescribeLaunchTemplateVersionsInput
+}
+
+func (m *mockLaunchTemplates) DescribeLaunchTemplates(ctx context.Context, in *ec2.DescribeLaunchTemplatesInput, opts ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplatesOutput, error) {
+	return &m.RespDescribeLaunchTemplates, m.RespDescribeLaunchTemplatesErr
+}
+
+func (m *mockLaunchTemplates) DescribeLaunchTemplateVersions(ctx context.Context, in *ec2.DescribeLaunchTemplateVersionsInput, opts ...func(*ec2.Options)) (*ec2.DescribeLaunchTemplateVersionsOutput, error) {
+	m.VersionInputs = append(m.VersionInputs, in)
+
+	lt := aws.ToString(in.LaunchTemplateId) + aws.ToString(in.LaunchTemplateName)
+	out := &ec2.DescribeLaunchTemplateVersionsOutput{}
+	for _, v := range in.Versions {
+		image, ok := m.RespDescribeLaunchTemplateVersions[lt][v]
+		if !ok {
+			continue
+		}
+		out.LaunchTemplateVersions = append(out.LaunchTemplateVersions, types.LaunchTemplateVersion{
+			LaunchTemplateData: &types.ResponseLaunchTemplateData{ImageId: aws.String(image)},
+		})
+	}
+	return out, m.RespDescribeLaunchTemplateVersionsErr
+}
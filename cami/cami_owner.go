@@ -0,0 +1,130 @@
+package cami
+
+import (
+	"sort"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// NoOwner is the owner of AMIs that have none of the Config.OwnerTagKeys.
+const NoOwner = "unowned"
+
+// OwnerGroup is the candidates of a plan that belong to a single owner.
+type OwnerGroup struct {
+	// Owner is the owner of the candidates, or NoOwner
+	Owner string
+	// Candidates is the AMIs that belong to the owner
+	Candidates []types.Image
+	// Storage is the snapshot storage used by the candidates, or nil if the plan has none
+	Storage *StorageReport
+}
+
+// ownerTagKeys returns the configured owner tag keys.
+func (c *Config) ownerTagKeys() []string {
+	if c == nil {
+		return nil
+	}
+	return c.OwnerTagKeys
+}
+
+// owner returns the value of the first owner tag key the AMI has a non-empty tag for,
+// or NoOwner.
+func (c *Config) owner(ami types.Image) string {
+	for _, key := range c.ownerTagKeys() {
+		for _, tag := range ami.Tags {
+			if aws.ToString(tag.Key) != key {
+				continue
+			}
+			if value := strings.TrimSpace(aws.ToString(tag.Value)); value != "" {
+				return value
+			}
+		}
+	}
+	return NoOwner
+}
+
+// ByOwner splits the candidates of the plan by owner. Groups are sorted by owner, with
+// NoOwner last.
+func (p *Plan) ByOwner() []OwnerGroup {
+	groups := make(map[string]*OwnerGroup)
+	for _, ami := range p.Candidates {
+		owner := p.Owners[*ami.ImageId]
+		if owner == "" {
+			owner = NoOwner
+		}
+
+		g, ok := groups[owner]
+		if !ok {
+			g = &OwnerGroup{Owner: owner}
+			groups[owner] = g
+		}
+		g.Candidates = append(g.Candidates, ami)
+	}
+
+	output := make([]OwnerGroup, 0, len(groups))
+	for _, g := range groups {
+		if p.Storage != nil {
+			g.Storage = g.storage(p.Storage)
+		}
+		output = append(output, *g)
+	}
+	sort.Slice(output, func(i, j int) bool {
+		if (output[i].Owner == NoOwner) != (output[j].Owner == NoOwner) {
+			return output[j].Owner == NoOwner
+		}
+		return output[i].Owner < output[j].Owner
+	})
+
+	return output
+}
+
+// Owns returns true if id is one of the group's candidates or a snapshot backing one.
+func (g OwnerGroup) Owns(id string) bool {
+	for _, ami := range g.Candidates {
+		if aws.ToString(ami.ImageId) == id {
+			return true
+		}
+		for _, bdm := range ami.BlockDeviceMappings {
+			if bdm.Ebs != nil && aws.ToString(bdm.Ebs.SnapshotId) == id {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// Filter returns the IDs that the group owns.
+func (g OwnerGroup) Filter(ids []string) []string {
+	var output []string
+	for _, id := range ids {
+		if g.Owns(id) {
+			output = append(output, id)
+		}
+	}
+	return output
+}
+
+// storage returns the part of report used by the group's candidates.
+func (g OwnerGroup) storage(report *StorageReport) *StorageReport {
+	output := &StorageReport{}
+
+	counted := make(map[string]bool)
+	for _, is := range report.Images {
+		if !g.Owns(is.ImageID) {
+			continue
+		}
+
+		output.Images = append(output.Images, is)
+		for _, ss := range is.Snapshots {
+			if !counted[ss.SnapshotID] {
+				counted[ss.SnapshotID] = true
+				output.Bytes += ss.Bytes
+				output.MonthlyCost += ss.MonthlyCost
+			}
+		}
+	}
+
+	return output
+}
@@ -0,0 +1,198 @@
+package cami
+
+import (
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestOwner(t *testing.T) {
+	t.Parallel()
+
+	tag := func(key, value string) types.Tag {
+		return types.Tag{Key: aws.String(key), Value: aws.String(value)}
+	}
+
+	tests := []struct {
+		name     string
+		giveKeys []string
+		giveTags []types.Tag
+		want     string
+	}{
+		{
+			name:     "no keys",
+			giveKeys: nil,
+			giveTags: []types.Tag{tag("owner", "web")},
+			want:     NoOwner,
+		},
+		{
+			name:     "first key",
+			giveKeys: []string{"owner", "team"},
+			giveTags: []types.Tag{tag("team", "infra"), tag("owner", "web")},
+			want:     "web",
+		},
+		{
+			name:     "fallback key",
+			giveKeys: []string{"owner", "team"},
+			giveTags: []types.Tag{tag("team", "infra")},
+			want:     "infra",
+		},
+		{
+			name:     "empty value",
+			giveKeys: []string{"owner", "team"},
+			giveTags: []types.Tag{tag("owner", " "), tag("team", "infra")},
+			want:     "infra",
+		},
+		{
+			name:     "untagged",
+			giveKeys: []string{"owner"},
+			giveTags: []types.Tag{tag("Name", "web")},
+			want:     NoOwner,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			c := &Config{OwnerTagKeys: tt.giveKeys}
+			assert.Equal(t, tt.want, c.owner(types.Image{Tags: tt.giveTags}))
+		})
+	}
+}
+
+// ownerPlan returns a plan with two AMIs owned by web, sharing a snapshot, one owned
+// by infra and one without an owner.
+func ownerPlan() *Plan {
+	ami := func(id string, snaps ...string) types.Image {
+		img := types.Image{ImageId: aws.String(id)}
+		for _, snap := range snaps {
+			img.BlockDeviceMappings = append(img.BlockDeviceMappings,
+				types.BlockDeviceMapping{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String(snap)}})
+		}
+		return img
+	}
+	storage := func(id string, snaps ...string) ImageStorage {
+		is := ImageStorage{ImageID: id}
+		for _, snap := range snaps {
+			is.Snapshots = append(is.Snapshots, SnapshotStorage{SnapshotID: snap, Tier: "standard", Bytes: gib, MonthlyCost: 0.05})
+			is.Bytes += gib
+			is.MonthlyCost += 0.05
+		}
+		return is
+	}
+
+	return &Plan{
+		Candidates: []types.Image{
+			ami("ami-1", "snap-1"),
+			ami("ami-2", "snap-2"),
+			ami("ami-3", "snap-1", "snap-3"),
+			ami("ami-4"),
+		},
+		Owners: map[string]string{"ami-1": "web", "ami-2": NoOwner, "ami-3": "web", "ami-4": "infra"},
+		Storage: &StorageReport{
+			Images: []ImageStorage{
+				storage("ami-1", "snap-1"),
+				storage("ami-2", "snap-2"),
+				storage("ami-3", "snap-1", "snap-3"),
+				storage("ami-4"),
+			},
+			Bytes:       3 * gib,
+			MonthlyCost: 0.15,
+		},
+	}
+}
+
+func TestByOwner(t *testing.T) {
+	t.Parallel()
+
+	plan := ownerPlan()
+	groups := plan.ByOwner()
+
+	var owners []string
+	for _, g := range groups {
+		owners = append(owners, g.Owner)
+	}
+	assert.Equal(t, []string{"infra", "web", NoOwner}, owners)
+
+	web := groups[1]
+	assert.Equal(t, []types.Image{plan.Candidates[0], plan.Candidates[2]}, web.Candidates)
+	assert.Equal(t, int64(2*gib), web.Storage.Bytes)
+	assert.InDelta(t, 0.1, web.Storage.MonthlyCost, 0.0001)
+	assert.Len(t, web.Storage.Images, 2)
+
+	assert.True(t, web.Owns("ami-3"))
+	assert.True(t, web.Owns("snap-3"))
+	assert.False(t, web.Owns("ami-2"))
+	assert.Equal(t, []string{"ami-1", "snap-1"}, web.Filter([]string{"ami-1", "snap-1", "ami-2", "snap-2"}))
+
+	unowned := groups[2]
+	assert.Equal(t, []types.Image{plan.Candidates[1]}, unowned.Candidates)
+	assert.Equal(t, int64(gib), unowned.Storage.Bytes)
+}
+
+func TestByOwnerNoStorage(t *testing.T) {
+	t.Parallel()
+
+	plan := &Plan{Candidates: []types.Image{{ImageId: aws.String("ami-1")}}}
+	groups := plan.ByOwner()
+
+	assert.Len(t, groups, 1)
+	assert.Equal(t, NoOwner, groups[0].Owner)
+	assert.Nil(t, groups[0].Storage)
+}
+
+func TestApplyOwners(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		giveKeys   []string
+		giveEC2    *mockEC2
+		wantOwners map[string][]string
+	}{
+		{
+			name:       "no keys",
+			giveKeys:   nil,
+			giveEC2:    &mockEC2{},
+			wantOwners: nil,
+		},
+		{
+			name:     "keys",
+			giveKeys: []string{"owner"},
+			giveEC2:  &mockEC2{},
+			wantOwners: map[string][]string{
+				"web":   {"ami-1", "snap-1", "ami-3", "snap-1", "snap-3"},
+				"infra": {"ami-4"},
+				NoOwner: {"ami-2", "snap-2"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := AWS{cfg: &Config{OwnerTagKeys: tt.giveKeys}, ec2: tt.giveEC2}
+			result, err := a.Apply(ownerPlan())
+			assert.Nil(t, err)
+
+			if tt.wantOwners == nil {
+				assert.Nil(t, result.Owners)
+				return
+			}
+
+			got := make(map[string][]string)
+			for owner, r := range result.Owners {
+				got[owner] = r.IDs
+			}
+			assert.Equal(t, tt.wantOwners, got)
+			assert.Equal(t, int64(2*gib), result.Owners["web"].Reclaimed.Bytes)
+			assert.Equal(t, int64(gib), result.Owners[NoOwner].Reclaimed.Bytes)
+		})
+	}
+}
@@ -0,0 +1,281 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// Plan is the list of AMIs owned by the account and the subset of them that are unused
+// and would be acted on by Apply.
+type Plan struct {
+	// Images is every AMI owned by the account
+	Images []types.Image
+	// Candidates is the AMIs that are not in use
+	Candidates []types.Image
+	// Reasons is why each candidate is considered unused, keyed by AMI ID
+	Reasons map[string]string
+	// Protected is why AMIs that are not used by any instance are kept anyway, keyed by
+	// AMI ID. See Config.Detectors.
+	Protected map[string]string
+	// Owners is the owner of each candidate, keyed by AMI ID. See Config.OwnerTagKeys.
+	Owners map[string]string
+	// Storage is the snapshot storage used by the candidates
+	Storage *StorageReport
+}
+
+// Result is the outcome of applying a Plan.
+type Result struct {
+	// RunID identifies the run, and is the RunID of every ImageEvent it published
+	RunID string
+	// IDs is the list of AMI and snapshot IDs that were successfully acted on
+	IDs []string
+	// Reclaimed is the storage of the acted on AMIs whose snapshots were deleted or
+	// moved to the archive tier
+	Reclaimed *StorageReport
+	// MonthlySavings is the estimated reduction in monthly storage cost in USD
+	MonthlySavings float64
+	// ImageBuilderImages is the ARNs of the Image Builder images that were deleted. See
+	// ImageBuilderDelete.
+	ImageBuilderImages []string
+	// Owners is the result for the candidates of each owner, keyed by owner. It is only
+	// set when Config.OwnerTagKeys is.
+	Owners map[string]*Result
+}
+
+// Outcome is a Result and the error it was returned with, in a form that can be
+// serialized and reported.
+type Outcome struct {
+	// RunID identifies the run
+	RunID string `json:"run_id,omitempty"`
+	// IDs is the AMI and snapshot IDs that were acted on
+	IDs []string `json:"ids"`
+	// Failed is the AMI and snapshot IDs that could not be acted on
+	Failed []string `json:"failed,omitempty"`
+	// ReclaimedBytes is the snapshot storage that was reclaimed
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// MonthlySavings is the estimated reduction in monthly storage cost in USD
+	MonthlySavings float64 `json:"monthly_savings"`
+	// ImageBuilderImages is the ARNs of the Image Builder images that were deleted
+	ImageBuilderImages []string `json:"image_builder_images,omitempty"`
+	// Error is why the run failed, if it did
+	Error string `json:"error,omitempty"`
+}
+
+// NewOutcome returns the outcome of a run from the Result and error returned by Apply,
+// DeleteAMIs or MarkAndSweep. Either may be nil.
+func NewOutcome(result *Result, err error) Outcome {
+	var output Outcome
+
+	if result != nil {
+		output.RunID = result.RunID
+		output.IDs = result.IDs
+		output.ImageBuilderImages = result.ImageBuilderImages
+		output.MonthlySavings = result.MonthlySavings
+		if result.Reclaimed != nil {
+			output.ReclaimedBytes = result.Reclaimed.Bytes
+		}
+	}
+	if err != nil {
+		output.Error = err.Error()
+
+		var eda *ErrDeleteAMIs
+		if errors.As(err, &eda) {
+			output.Failed = eda.IDs
+		}
+	}
+
+	return output
+}
+
+// Plan finds all AMIs that are not being used by any current EC2 instance in the same
+// account, along with the snapshot storage they use. Storage is nil if the snapshots
+// could not be described.
+func (a *AWS) Plan() (*Plan, error) {
+	output := &Plan{}
+
+	err := a.cfg.Validate()
+	if err != nil {
+		return output, err
+	}
+
+	a.resetLastLaunched()
+
+	output.Images, err = a.AMIs()
+	if err != nil {
+		return output, err
+	}
+
+	ec2s, err := a.EC2s(output.Images)
+	if err != nil {
+		return output, err
+	}
+
+	output.Candidates, err = a.FilterAMIs(output.Images, ec2s)
+	if err != nil {
+		return output, err
+	}
+	output.Protected = a.protected
+
+	ignored := make(map[string][]types.Instance)
+	for _, ec2 := range ec2s {
+		if ec2.ImageId != nil && !a.inUse(ec2) {
+			ignored[*ec2.ImageId] = append(ignored[*ec2.ImageId], ec2)
+		}
+	}
+
+	output.Reasons = make(map[string]string, len(output.Candidates))
+	output.Owners = make(map[string]string, len(output.Candidates))
+	for _, ami := range output.Candidates {
+		output.Reasons[*ami.ImageId] = a.reason(*ami.ImageId, ignored[*ami.ImageId])
+		output.Owners[*ami.ImageId] = a.cfg.owner(ami)
+	}
+
+	// The storage report is only an estimate, so failing to build it must not fail the run
+	output.Storage, err = a.Storage(output.Candidates)
+	if err != nil {
+		a.cfg.logger().Warn("failed to estimate snapshot storage", "error", err)
+		output.Storage = nil
+	}
+
+	a.cfg.logger().Info("planned", "images", len(output.Images), "candidates", len(output.Candidates))
+	a.cfg.metrics().planned(output)
+
+	return output, nil
+}
+
+// reason returns why the candidate with the provided ID is considered unused, given
+// the instances using it that do not count.
+func (a *AWS) reason(id string, ignored []types.Instance) string {
+	reason := "not used by any instance"
+	if len(ignored) > 0 {
+		instances := make([]string, 0, len(ignored))
+		for _, ec2 := range ignored {
+			instances = append(instances, fmt.Sprintf("%s (%s)", aws.ToString(ec2.InstanceId), a.ignoredReason(ec2)))
+		}
+		reason = "only used by " + strings.Join(instances, ", ")
+	}
+
+	within := a.cfg.launchedWithin()
+	if within <= 0 {
+		return reason
+	}
+
+	a.lastLaunchedMu.Lock()
+	t, ok := a.lastLaunched[id]
+	a.lastLaunchedMu.Unlock()
+	switch {
+	case !ok:
+		return reason + fmt.Sprintf(" or launched in the last %s", within)
+	case t.IsZero():
+		return reason + ", never launched"
+	default:
+		return reason + fmt.Sprintf(", last launched %s, more than %s ago", t.UTC().Format(time.RFC3339), within)
+	}
+}
+
+// ignoredReason returns why an instance does not count as using its AMI.
+func (a *AWS) ignoredReason(ec2 types.Instance) string {
+	if !slices.Contains(a.cfg.instanceStates(), ec2.State.Name) {
+		return string(ec2.State.Name)
+	}
+
+	stopped, _ := stoppedAt(ec2)
+	return fmt.Sprintf("stopped since %s, more than %s ago", stopped.UTC().Format(time.RFC3339), a.cfg.stoppedMaxAge())
+}
+
+// Apply runs the configured Action on every candidate in the plan. Returns the IDs that
+// were acted on and the storage that was reclaimed.
+func (a *AWS) Apply(plan *Plan) (*Result, error) {
+	var err error
+	output := &Result{Reclaimed: &StorageReport{}}
+
+	err = a.CheckLimits(plan)
+	if err != nil {
+		return output, err
+	}
+
+	if len(plan.Candidates) > 0 {
+		err = a.recycleBinPreflight()
+		if err != nil {
+			return output, err
+		}
+	}
+
+	deleted, err := a.deleteAMIs(plan.Candidates)
+	output.RunID, output.IDs, output.ImageBuilderImages = deleted.RunID, deleted.IDs, deleted.ImageBuilderImages
+	a.cfg.logger().Info("applied", "action", string(a.cfg.action()), "ids", len(output.IDs))
+	if plan.Storage != nil {
+		output.Reclaimed, output.MonthlySavings = a.reclaimed(plan.Storage, output.IDs)
+		if !a.cfg.dryRun() {
+			a.cfg.metrics().reclaimed(output.Reclaimed)
+		}
+	}
+
+	if len(a.cfg.ownerTagKeys()) > 0 {
+		output.Owners = make(map[string]*Result)
+		for _, g := range plan.ByOwner() {
+			owned := &Result{IDs: g.Filter(output.IDs), Reclaimed: &StorageReport{}}
+			if g.Storage != nil {
+				owned.Reclaimed, owned.MonthlySavings = a.reclaimed(g.Storage, owned.IDs)
+			}
+			output.Owners[g.Owner] = owned
+		}
+	}
+
+	return output, err
+}
+
+// reclaimed returns the part of the storage report that was reclaimed by acting on ids
+// and the estimated monthly savings.
+func (a *AWS) reclaimed(storage *StorageReport, ids []string) (*StorageReport, float64) {
+	output := &StorageReport{}
+	var savings float64
+
+	action := a.cfg.action()
+	if !action.Deregisters() {
+		return output, savings
+	}
+
+	done := make(map[string]bool)
+	for _, id := range ids {
+		done[id] = true
+	}
+
+	counted := make(map[string]bool)
+	for _, is := range storage.Images {
+		if !done[is.ImageID] {
+			continue
+		}
+
+		ris := ImageStorage{ImageID: is.ImageID, Name: is.Name}
+		for _, ss := range is.Snapshots {
+			if !done[ss.SnapshotID] {
+				continue
+			}
+
+			ris.Snapshots = append(ris.Snapshots, ss)
+			ris.Bytes += ss.Bytes
+			ris.MonthlyCost += ss.MonthlyCost
+
+			if !counted[ss.SnapshotID] {
+				counted[ss.SnapshotID] = true
+				output.Bytes += ss.Bytes
+				output.MonthlyCost += ss.MonthlyCost
+
+				savings += ss.MonthlyCost
+				if action == ActionArchive {
+					savings -= a.cost(ss.Bytes, string(types.StorageTierArchive))
+				}
+			}
+		}
+		output.Images = append(output.Images, ris)
+	}
+
+	return output, savings
+}
@@ -0,0 +1,353 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPlan(t *testing.T) {
+	t.Parallel()
+
+	images := []types.Image{
+		{
+			ImageId: aws.String("ami-123"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+			},
+		},
+		{ImageId: aws.String("ami-456")},
+	}
+
+	tests := []struct {
+		name     string
+		giveEC2  *mockEC2
+		wantPlan *Plan
+		wantErr  error
+	}{
+		{
+			name:     "error describe images",
+			giveEC2:  &mockEC2{RespDescImagesErr: fmt.Errorf("FAIL")},
+			wantPlan: &Plan{},
+			wantErr:  ErrDesribeImages,
+		},
+		{
+			name: "error describe snapshots",
+			giveEC2: &mockEC2{
+				RespDescImages:       ec2.DescribeImagesOutput{Images: images},
+				RespDescSnapshotsErr: fmt.Errorf("FAIL"),
+			},
+			wantPlan: &Plan{
+				Images:     images,
+				Candidates: images,
+				Reasons: map[string]string{
+					"ami-123": "not used by any instance",
+					"ami-456": "not used by any instance",
+				},
+				Owners: map[string]string{"ami-123": NoOwner, "ami-456": NoOwner},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "reason per image",
+			giveEC2: &mockEC2{
+				RespDescImages: ec2.DescribeImagesOutput{Images: images},
+				RespDescInstances: ec2.DescribeInstancesOutput{
+					Reservations: []types.Reservation{{Instances: []types.Instance{
+						{
+							ImageId:    aws.String("ami-123"),
+							InstanceId: aws.String("i-123"),
+							State:      &types.InstanceState{Name: types.InstanceStateNameTerminated},
+						},
+						{ImageId: aws.String("ami-456")},
+					}}},
+				},
+			},
+			wantPlan: &Plan{
+				Images:     images,
+				Candidates: images[:1],
+				Reasons:    map[string]string{"ami-123": "only used by i-123 (terminated)"},
+				Owners:     map[string]string{"ami-123": NoOwner},
+				Storage: &StorageReport{
+					Images: []ImageStorage{{ImageID: "ami-123", Snapshots: []SnapshotStorage{{SnapshotID: "snap-123", Tier: "standard"}}}},
+				},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "plan",
+			giveEC2: &mockEC2{
+				RespDescImages: ec2.DescribeImagesOutput{Images: images},
+				RespDescInstances: ec2.DescribeInstancesOutput{
+					Reservations: []types.Reservation{{Instances: []types.Instance{
+						{ImageId: aws.String("ami-456")},
+					}}},
+				},
+				RespDescSnapshots: ec2.DescribeSnapshotsOutput{
+					Snapshots: []types.Snapshot{
+						{SnapshotId: aws.String("snap-123"), VolumeSize: aws.Int32(8)},
+					},
+				},
+			},
+			wantPlan: &Plan{
+				Images:     images,
+				Candidates: images[:1],
+				Reasons:    map[string]string{"ami-123": "not used by any instance"},
+				Owners:     map[string]string{"ami-123": NoOwner},
+				Storage: &StorageReport{
+					Images: []ImageStorage{
+						{
+							ImageID: "ami-123",
+							Snapshots: []SnapshotStorage{
+								{SnapshotID: "snap-123", Tier: "standard", Bytes: 8 * gib, MonthlyCost: 0.4},
+							},
+							Bytes:       8 * gib,
+							MonthlyCost: 0.4,
+						},
+					},
+					Bytes:       8 * gib,
+					MonthlyCost: 0.4,
+				},
+			},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{ec2: tt.giveEC2}
+
+			plan, err := aws.Plan()
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantPlan, plan)
+		})
+	}
+}
+
+func TestApply(t *testing.T) {
+	t.Parallel()
+
+	plan := &Plan{
+		Candidates: []types.Image{
+			{
+				ImageId: aws.String("ami-123"),
+				BlockDeviceMappings: []types.BlockDeviceMapping{
+					{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+				},
+			},
+		},
+		Storage: &StorageReport{
+			Images: []ImageStorage{
+				{
+					ImageID: "ami-123",
+					Snapshots: []SnapshotStorage{
+						{SnapshotID: "snap-123", Tier: "standard", Bytes: 8 * gib, MonthlyCost: 0.4},
+					},
+					Bytes:       8 * gib,
+					MonthlyCost: 0.4,
+				},
+			},
+			Bytes:       8 * gib,
+			MonthlyCost: 0.4,
+		},
+	}
+
+	tests := []struct {
+		name        string
+		giveCfg     *Config
+		giveEC2     *mockEC2
+		wantIDs     []string
+		wantBytes   int64
+		wantSavings float64
+		wantErr     error
+	}{
+		{
+			name:        "deregister",
+			giveCfg:     &Config{},
+			giveEC2:     &mockEC2{},
+			wantIDs:     []string{"ami-123", "snap-123"},
+			wantBytes:   8 * gib,
+			wantSavings: 0.4,
+		},
+		{
+			name:        "archive",
+			giveCfg:     &Config{Action: ActionArchive},
+			giveEC2:     &mockEC2{},
+			wantIDs:     []string{"ami-123", "snap-123"},
+			wantBytes:   8 * gib,
+			wantSavings: 0.3,
+		},
+		{
+			name:        "tag",
+			giveCfg:     &Config{Action: ActionTag},
+			giveEC2:     &mockEC2{},
+			wantIDs:     []string{"ami-123"},
+			wantBytes:   0,
+			wantSavings: 0,
+		},
+		{
+			name:        "snapshot error",
+			giveCfg:     &Config{},
+			giveEC2:     &mockEC2{RespDeleteSnapshotErr: fmt.Errorf("FAIL")},
+			wantIDs:     []string{"ami-123"},
+			wantBytes:   0,
+			wantSavings: 0,
+			wantErr:     &ErrDeleteAMIs{IDs: []string{"snap-123"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{cfg: tt.giveCfg, ec2: tt.giveEC2}
+
+			result, err := aws.Apply(plan)
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.Equal(t, tt.wantErr, err)
+			}
+
+			assert.Equal(t, tt.wantIDs, result.IDs)
+			assert.Equal(t, tt.wantBytes, result.Reclaimed.Bytes)
+			assert.InDelta(t, tt.wantSavings, result.MonthlySavings, 0.0001)
+		})
+	}
+}
+
+func TestReason(t *testing.T) {
+	t.Parallel()
+
+	launched := time.Date(2021, 1, 1, 12, 0, 0, 0, time.UTC)
+
+	tests := []struct {
+		name             string
+		giveCfg          *Config
+		giveIgnored      []types.Instance
+		giveLastLaunched map[string]time.Time
+		want             string
+	}{
+		{
+			name: "no instances",
+			want: "not used by any instance",
+		},
+		{
+			name: "state not counted",
+			giveIgnored: []types.Instance{{
+				InstanceId: aws.String("i-123"),
+				State:      &types.InstanceState{Name: types.InstanceStateNameTerminated},
+			}},
+			want: "only used by i-123 (terminated)",
+		},
+		{
+			name:    "stopped too long",
+			giveCfg: &Config{StoppedMaxAge: 72 * time.Hour},
+			giveIgnored: []types.Instance{
+				{
+					InstanceId:            aws.String("i-123"),
+					State:                 &types.InstanceState{Name: types.InstanceStateNameStopped},
+					StateTransitionReason: aws.String("User initiated (2021-01-01 12:00:00 GMT)"),
+				},
+				{
+					InstanceId: aws.String("i-456"),
+					State:      &types.InstanceState{Name: types.InstanceStateNameShuttingDown},
+				},
+			},
+			want: "only used by i-123 (stopped since 2021-01-01T12:00:00Z, more than 72h0m0s ago), i-456 (shutting-down)",
+		},
+		{
+			name:             "launched before cutoff",
+			giveCfg:          &Config{LaunchedWithin: 72 * time.Hour},
+			giveLastLaunched: map[string]time.Time{"ami-123": launched},
+			want:             "not used by any instance, last launched 2021-01-01T12:00:00Z, more than 72h0m0s ago",
+		},
+		{
+			name:             "never launched",
+			giveCfg:          &Config{LaunchedWithin: 72 * time.Hour},
+			giveLastLaunched: map[string]time.Time{"ami-123": {}},
+			want:             "not used by any instance, never launched",
+		},
+		{
+			name:    "launch time unknown",
+			giveCfg: &Config{LaunchedWithin: 72 * time.Hour},
+			want:    "not used by any instance or launched in the last 72h0m0s",
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := &AWS{cfg: tt.giveCfg, lastLaunched: tt.giveLastLaunched}
+
+			assert.Equal(t, tt.want, aws.reason("ami-123", tt.giveIgnored))
+		})
+	}
+}
+
+func TestNewOutcome(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		giveResult *Result
+		giveErr    error
+		want       Outcome
+	}{
+		{
+			name:       "nil",
+			giveResult: nil,
+			giveErr:    nil,
+			want:       Outcome{},
+		},
+		{
+			name: "result",
+			giveResult: &Result{
+				RunID:          "run",
+				IDs:            []string{"ami-123", "snap-123"},
+				Reclaimed:      &StorageReport{Bytes: 1024},
+				MonthlySavings: 0.05,
+			},
+			want: Outcome{RunID: "run", IDs: []string{"ami-123", "snap-123"}, ReclaimedBytes: 1024, MonthlySavings: 0.05},
+		},
+		{
+			name:       "failed",
+			giveResult: &Result{IDs: []string{"ami-123"}},
+			giveErr:    &ErrDeleteAMIs{IDs: []string{"snap-123"}},
+			want:       Outcome{IDs: []string{"ami-123"}, Failed: []string{"snap-123"}, Error: "delete AMIs"},
+		},
+		{
+			name:    "error",
+			giveErr: fmt.Errorf("%w: FAIL", ErrDesribeImages),
+			want:    Outcome{Error: "describe images: FAIL"},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, NewOutcome(tt.giveResult, tt.giveErr))
+		})
+	}
+}
@@ -0,0 +1,206 @@
+package cami
+
+import (
+	"context"
+	"fmt"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/rbin"
+	rbintypes "github.com/aws/aws-sdk-go-v2/service/rbin/types"
+)
+
+type rbinIf interface {
+	ListRules(context.Context, *rbin.ListRulesInput, ...func(*rbin.Options)) (*rbin.ListRulesOutput, error)
+	GetRule(context.Context, *rbin.GetRuleInput, ...func(*rbin.Options)) (*rbin.GetRuleOutput, error)
+	CreateRule(context.Context, *rbin.CreateRuleInput, ...func(*rbin.Options)) (*rbin.CreateRuleOutput, error)
+}
+
+// RecycleBinMode controls what happens when no Recycle Bin retention rule covers the
+// AMIs and snapshots that cami deletes.
+type RecycleBinMode string
+
+const (
+	// RecycleBinOff skips the Recycle Bin preflight. This is the default.
+	RecycleBinOff RecycleBinMode = "off"
+	// RecycleBinWarn runs the preflight but still deletes when rules are missing.
+	RecycleBinWarn RecycleBinMode = "warn"
+	// RecycleBinRequire refuses to delete when rules are missing.
+	RecycleBinRequire RecycleBinMode = "require"
+)
+
+const (
+	// ManagedTagKey is the tag key set on resources that cami creates and manages.
+	ManagedTagKey = "cami:managed"
+
+	// DefaultRecycleBinRetentionDays is how many days a cami managed Recycle Bin rule
+	// retains resources when Config.RecycleBinRetentionDays is not set.
+	DefaultRecycleBinRetentionDays = 7
+)
+
+// recycleBinResourceTypes are the resource types cami deletes.
+func recycleBinResourceTypes() []rbintypes.ResourceType {
+	return []rbintypes.ResourceType{
+		rbintypes.ResourceTypeEc2Image,
+		rbintypes.ResourceTypeEbsSnapshot,
+	}
+}
+
+// recycleBin returns the configured recycle bin mode or the default.
+func (c *Config) recycleBin() RecycleBinMode {
+	if c == nil || c.RecycleBin == "" {
+		return RecycleBinOff
+	}
+	return c.RecycleBin
+}
+
+// valid returns true if the mode is supported.
+func (mode RecycleBinMode) valid() bool {
+	switch mode {
+	case RecycleBinOff, RecycleBinWarn, RecycleBinRequire:
+		return true
+	}
+	return false
+}
+
+// recycleBinRetentionDays returns the configured retention or the default.
+func (c *Config) recycleBinRetentionDays() int32 {
+	if c == nil || c.RecycleBinRetentionDays <= 0 {
+		return DefaultRecycleBinRetentionDays
+	}
+	return c.RecycleBinRetentionDays
+}
+
+// RecycleBinPreflight checks that region level Recycle Bin retention rules cover both
+// AMIs and EBS snapshots, so that anything cami deletes can be recovered. If
+// CreateRecycleBinRule is set, a cami managed rule is created for each resource type
+// that is not covered (unless DryRun is set). Returns the resource types that are not
+// covered. In RecycleBinRequire mode an error is returned if any are missing. Runs that
+// delete AMIs do not repeat the preflight once it has passed.
+func (a *AWS) RecycleBinPreflight() ([]string, error) {
+	var output []string
+
+	if mode := a.cfg.recycleBin(); !mode.valid() {
+		return output, fmt.Errorf("%w: %s", ErrInvalidRecycleBinMode, mode)
+	}
+
+	for _, rt := range recycleBinResourceTypes() {
+		covered, err := a.recycleBinCovered(rt)
+		if err != nil {
+			return output, err
+		}
+
+		if !covered && a.cfg.CreateRecycleBinRule && !a.cfg.DryRun {
+			err = a.createRecycleBinRule(rt)
+			if err != nil {
+				return output, err
+			}
+			covered = true
+		}
+
+		if !covered {
+			output = append(output, string(rt))
+		}
+	}
+
+	if len(output) > 0 && a.cfg.recycleBin() == RecycleBinRequire {
+		return output, fmt.Errorf("%w: %s", ErrNoRecycleBinRule, strings.Join(output, ", "))
+	}
+
+	a.rbinChecked = true
+	return output, nil
+}
+
+// recycleBinPreflight runs RecycleBinPreflight once before a run that deletes AMIs, if
+// the preflight is enabled. In RecycleBinWarn mode missing rules are logged as a
+// warning.
+func (a *AWS) recycleBinPreflight() error {
+	if mode := a.cfg.recycleBin(); !mode.valid() {
+		return fmt.Errorf("%w: %s", ErrInvalidRecycleBinMode, mode)
+	}
+	if a.rbinChecked || !a.cfg.action().Deregisters() {
+		return nil
+	}
+	if a.cfg.recycleBin() == RecycleBinOff && !a.cfg.CreateRecycleBinRule {
+		return nil
+	}
+
+	missing, err := a.RecycleBinPreflight()
+	if err != nil {
+		return err
+	}
+	if len(missing) > 0 && a.cfg.recycleBin() == RecycleBinWarn {
+		a.cfg.logger().Warn("no Recycle Bin rule retains deleted resources, they cannot be recovered",
+			"resources", strings.Join(missing, ", "))
+	}
+
+	return nil
+}
+
+// recycleBinCovered returns true if an available region level retention rule retains
+// all resources of the provided type.
+func (a *AWS) recycleBinCovered(rt rbintypes.ResourceType) (bool, error) {
+	var nextToken *string
+	for {
+		listI := &rbin.ListRulesInput{
+			ResourceType: rt,
+			NextToken:    nextToken,
+		}
+		var listO *rbin.ListRulesOutput
+		err := a.call("ListRules", func() error {
+			var err error
+			listO, err = a.rbin.ListRules(a.ctx(), listI)
+			return err
+		})
+		if err != nil {
+			return false, fmt.Errorf("%w: %w", ErrListRecycleBinRules, err)
+		}
+
+		for _, rule := range listO.Rules {
+			getI := &rbin.GetRuleInput{Identifier: rule.Identifier}
+			var getO *rbin.GetRuleOutput
+			err := a.call("GetRule", func() error {
+				var err error
+				getO, err = a.rbin.GetRule(a.ctx(), getI)
+				return err
+			}, "resource_id", aws.ToString(rule.Identifier))
+			if err != nil {
+				return false, fmt.Errorf("%w: %w", ErrListRecycleBinRules, err)
+			}
+
+			if getO.Status == rbintypes.RuleStatusAvailable && len(getO.ResourceTags) == 0 {
+				return true, nil
+			}
+		}
+
+		if listO.NextToken == nil {
+			return false, nil
+		}
+		nextToken = listO.NextToken
+	}
+}
+
+// createRecycleBinRule creates a cami managed region level retention rule for the
+// provided resource type.
+func (a *AWS) createRecycleBinRule(rt rbintypes.ResourceType) error {
+	ruleI := &rbin.CreateRuleInput{
+		ResourceType: rt,
+		Description:  aws.String("Retains resources deleted by cami"),
+		RetentionPeriod: &rbintypes.RetentionPeriod{
+			RetentionPeriodUnit:  rbintypes.RetentionPeriodUnitDays,
+			RetentionPeriodValue: aws.Int32(a.cfg.recycleBinRetentionDays()),
+		},
+		Tags: []rbintypes.Tag{
+			{Key: aws.String(ManagedTagKey), Value: aws.String("true")},
+		},
+	}
+	err := a.audited("CreateRule", string(rt), func() error {
+		_, err := a.rbin.CreateRule(a.mutationCtx(), ruleI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrCreateRecycleBinRule, err)
+	}
+
+	return nil
+}
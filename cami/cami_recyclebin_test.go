@@ -0,0 +1,192 @@
+package cami
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log/slog"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/rbin"
+	rbintypes "github.com/aws/aws-sdk-go-v2/service/rbin/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRecycleBinPreflight(t *testing.T) {
+	t.Parallel()
+
+	rules := map[string]rbin.ListRulesOutput{
+		"EC2_IMAGE": {Rules: []rbintypes.RuleSummary{
+			{Identifier: aws.String("tagged")},
+			{Identifier: aws.String("region")},
+		}},
+		"EBS_SNAPSHOT": {Rules: []rbintypes.RuleSummary{
+			{Identifier: aws.String("pending")},
+		}},
+	}
+	details := map[string]rbin.GetRuleOutput{
+		"tagged": {
+			Status:       rbintypes.RuleStatusAvailable,
+			ResourceTags: []rbintypes.ResourceTag{{ResourceTagKey: aws.String("keep")}},
+		},
+		"region":  {Status: rbintypes.RuleStatusAvailable},
+		"pending": {Status: rbintypes.RuleStatusPending},
+	}
+
+	tests := []struct {
+		name        string
+		giveCfg     *Config
+		giveRbin    mockRbin
+		wantMissing []string
+		wantCreated []string
+		wantErr     error
+	}{
+		{
+			name:        "no rules warn",
+			giveCfg:     &Config{RecycleBin: RecycleBinWarn},
+			giveRbin:    mockRbin{},
+			wantMissing: []string{"EC2_IMAGE", "EBS_SNAPSHOT"},
+			wantErr:     nil,
+		},
+		{
+			name:        "no rules require",
+			giveCfg:     &Config{RecycleBin: RecycleBinRequire},
+			giveRbin:    mockRbin{},
+			wantMissing: []string{"EC2_IMAGE", "EBS_SNAPSHOT"},
+			wantErr:     ErrNoRecycleBinRule,
+		},
+		{
+			name:        "list error",
+			giveCfg:     &Config{RecycleBin: RecycleBinWarn},
+			giveRbin:    mockRbin{RespListRulesErr: fmt.Errorf("FAIL")},
+			wantMissing: nil,
+			wantErr:     ErrListRecycleBinRules,
+		},
+		{
+			name:        "get error",
+			giveCfg:     &Config{RecycleBin: RecycleBinWarn},
+			giveRbin:    mockRbin{RespListRules: rules, RespGetRuleErr: fmt.Errorf("FAIL")},
+			wantMissing: nil,
+			wantErr:     ErrListRecycleBinRules,
+		},
+		{
+			name:        "partially covered",
+			giveCfg:     &Config{RecycleBin: RecycleBinRequire},
+			giveRbin:    mockRbin{RespListRules: rules, RespGetRule: details},
+			wantMissing: []string{"EBS_SNAPSHOT"},
+			wantErr:     ErrNoRecycleBinRule,
+		},
+		{
+			name:        "create",
+			giveCfg:     &Config{RecycleBin: RecycleBinRequire, CreateRecycleBinRule: true},
+			giveRbin:    mockRbin{RespListRules: rules, RespGetRule: details},
+			wantMissing: nil,
+			wantCreated: []string{"EBS_SNAPSHOT"},
+			wantErr:     nil,
+		},
+		{
+			name:        "create dry run",
+			giveCfg:     &Config{RecycleBin: RecycleBinWarn, CreateRecycleBinRule: true, DryRun: true},
+			giveRbin:    mockRbin{RespListRules: rules, RespGetRule: details},
+			wantMissing: []string{"EBS_SNAPSHOT"},
+			wantErr:     nil,
+		},
+		{
+			name:        "unknown mode",
+			giveCfg:     &Config{RecycleBin: "requre"},
+			giveRbin:    mockRbin{},
+			wantMissing: nil,
+			wantErr:     ErrInvalidRecycleBinMode,
+		},
+		{
+			name:    "create error",
+			giveCfg: &Config{RecycleBin: RecycleBinWarn, CreateRecycleBinRule: true},
+			giveRbin: mockRbin{
+				RespListRules:     rules,
+				RespGetRule:       details,
+				RespCreateRuleErr: fmt.Errorf("FAIL"),
+			},
+			wantMissing: nil,
+			wantErr:     ErrCreateRecycleBinRule,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var created []string
+			tt.giveRbin.CreatedRules = &created
+
+			aws := AWS{
+				cfg:  tt.giveCfg,
+				rbin: tt.giveRbin,
+			}
+
+			missing, err := aws.RecycleBinPreflight()
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantMissing, missing)
+			assert.Equal(t, tt.wantCreated, created)
+		})
+	}
+}
+
+func TestDeleteUnusedAMIsRecycleBin(t *testing.T) {
+	t.Parallel()
+
+	aws := AWS{
+		cfg: &Config{RecycleBin: RecycleBinRequire},
+		ec2: &mockEC2{
+			RespDescImages: ec2.DescribeImagesOutput{
+				Images: []types.Image{{ImageId: aws.String("ami-123")}},
+			},
+		},
+		rbin: mockRbin{},
+	}
+
+	ids, err := aws.DeleteUnusedAMIs()
+
+	assert.True(t, errors.Is(err, ErrNoRecycleBinRule), fmt.Sprintf("expected: %s\ngot: %s", ErrNoRecycleBinRule, err))
+	assert.Nil(t, ids)
+}
+
+func TestApplyRecycleBinUnknownMode(t *testing.T) {
+	t.Parallel()
+
+	a := AWS{cfg: &Config{RecycleBin: "requre"}, ec2: &mockEC2{}, rbin: mockRbin{}}
+
+	result, err := a.Apply(&Plan{Candidates: []types.Image{{ImageId: aws.String("ami-123")}}})
+
+	assert.True(t, errors.Is(err, ErrInvalidRecycleBinMode), fmt.Sprintf("expected: %s\ngot: %s", ErrInvalidRecycleBinMode, err))
+	assert.Nil(t, result.IDs)
+}
+
+func TestRecycleBinPreflightOnce(t *testing.T) {
+	t.Parallel()
+
+	var buf bytes.Buffer
+	a := AWS{
+		cfg:  &Config{RecycleBin: RecycleBinWarn, Logger: slog.New(slog.NewJSONHandler(&buf, nil))},
+		rbin: mockRbin{},
+	}
+
+	err := a.recycleBinPreflight()
+	assert.Nil(t, err)
+	assert.Contains(t, buf.String(), `"level":"WARN"`)
+	assert.Contains(t, buf.String(), `"resources":"EC2_IMAGE, EBS_SNAPSHOT"`)
+
+	// the preflight passed, so a later run does not list the rules again
+	a.rbin = mockRbin{RespListRulesErr: fmt.Errorf("FAIL")}
+	err = a.recycleBinPreflight()
+	assert.Nil(t, err)
+}
@@ -0,0 +1,135 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"slices"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+)
+
+const (
+	// amiPrefix is the prefix of every AMI ID.
+	amiPrefix = "ami-"
+	// snapPrefix is the prefix of every snapshot ID.
+	snapPrefix = "snap-"
+)
+
+// RestoreAMIs restores the AMIs and snapshots with the provided IDs from the Recycle
+// Bin. Snapshots are restored before AMIs because an AMI cannot be restored while its
+// snapshots are still in the Recycle Bin. The snapshots of each AMI are found by the
+// AMI ID in their description, which CreateImage sets, and are restored even when
+// only the AMI ID is provided. Returns the IDs that were successfully restored. If
+// DryRun == true does not actually restore.
+func (a *AWS) RestoreAMIs(ids []string) ([]string, error) {
+	var output []string
+	er := &ErrDeleteAMIs{Op: OpRestore}
+
+	var snapIDs, amiIDs []string
+	for _, id := range ids {
+		switch {
+		case strings.HasPrefix(id, snapPrefix):
+			snapIDs = append(snapIDs, id)
+		case strings.HasPrefix(id, amiPrefix):
+			amiIDs = append(amiIDs, id)
+		default:
+			er.Append(id)
+		}
+	}
+
+	if len(amiIDs) > 0 {
+		found, err := a.recycleBinSnapshots(amiIDs)
+		if err != nil {
+			return nil, err
+		}
+		for _, id := range found {
+			if !slices.Contains(snapIDs, id) {
+				snapIDs = append(snapIDs, id)
+			}
+		}
+	}
+
+	for _, id := range snapIDs {
+		a.mutate(id, &output, er, func() error { return a.restoreSnapshot(id) })
+	}
+	for _, id := range amiIDs {
+		a.mutate(id, &output, er, func() error { return a.restoreImage(id) })
+	}
+
+	err := a.auditWriteErr()
+	if err != nil {
+		return output, errors.Join(err, er.ErrorOrNil())
+	}
+	return output, er.ErrorOrNil()
+}
+
+// recycleBinSnapshots returns the IDs of the snapshots in the Recycle Bin whose
+// description references one of amiIDs.
+func (a *AWS) recycleBinSnapshots(amiIDs []string) ([]string, error) {
+	var output []string
+	var nextToken *string
+
+	for {
+		snapI := &ec2.ListSnapshotsInRecycleBinInput{NextToken: nextToken}
+
+		var out *ec2.ListSnapshotsInRecycleBinOutput
+		err := a.call("ListSnapshotsInRecycleBin", func() error {
+			var err error
+			out, err = a.ec2.ListSnapshotsInRecycleBin(a.ctx(), snapI)
+			return err
+		})
+		if err != nil {
+			return output, fmt.Errorf("%w: %w", ErrListRecycleBinSnapshots, err)
+		}
+		for _, snap := range out.Snapshots {
+			for _, id := range findAMIIDs(aws.ToString(snap.Description)) {
+				if slices.Contains(amiIDs, id) {
+					output = append(output, aws.ToString(snap.SnapshotId))
+					break
+				}
+			}
+		}
+		if out.NextToken == nil {
+			break
+		}
+		nextToken = out.NextToken
+	}
+
+	return output, nil
+}
+
+// restoreImage restores a single AMI from the Recycle Bin.
+func (a *AWS) restoreImage(id string) error {
+	amiI := &ec2.RestoreImageFromRecycleBinInput{
+		ImageId: aws.String(id),
+		DryRun:  aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("RestoreImageFromRecycleBin", id, func() error {
+		_, err := a.ec2.RestoreImageFromRecycleBin(a.mutationCtx(), amiI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRestoreImage, err)
+	}
+
+	return nil
+}
+
+// restoreSnapshot restores a single snapshot from the Recycle Bin.
+func (a *AWS) restoreSnapshot(id string) error {
+	snapI := &ec2.RestoreSnapshotFromRecycleBinInput{
+		SnapshotId: aws.String(id),
+		DryRun:     aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("RestoreSnapshotFromRecycleBin", id, func() error {
+		_, err := a.ec2.RestoreSnapshotFromRecycleBin(a.mutationCtx(), snapI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrRestoreSnapshot, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,123 @@
+package cami
+
+import (
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestRestoreAMIs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		giveIDs []string
+		giveEC2 *mockEC2
+		wantIDs []string
+		wantErr error
+	}{
+		{
+			name:    "empty",
+			giveIDs: nil,
+			giveEC2: &mockEC2{},
+			wantIDs: nil,
+			wantErr: nil,
+		},
+		{
+			name:    "snapshots first",
+			giveIDs: []string{"ami-123", "snap-123", "snap-456"},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"snap-123", "snap-456", "ami-123"},
+			wantErr: nil,
+		},
+		{
+			name:    "AMI only",
+			giveIDs: []string{"ami-0123abcd"},
+			giveEC2: &mockEC2{RespListSnapshotsInRecycleBin: ec2.ListSnapshotsInRecycleBinOutput{
+				Snapshots: []types.SnapshotRecycleBinInfo{
+					{SnapshotId: aws.String("snap-123"), Description: aws.String("Created by CreateImage(i-123) for ami-0123abcd")},
+					{SnapshotId: aws.String("snap-456"), Description: aws.String("Created by CreateImage(i-456) for ami-0456abcd")},
+					{SnapshotId: aws.String("snap-789")},
+				},
+			}},
+			wantIDs: []string{"snap-123", "ami-0123abcd"},
+			wantErr: nil,
+		},
+		{
+			name:    "AMI and its snapshot",
+			giveIDs: []string{"ami-0123abcd", "snap-123"},
+			giveEC2: &mockEC2{RespListSnapshotsInRecycleBin: ec2.ListSnapshotsInRecycleBinOutput{
+				Snapshots: []types.SnapshotRecycleBinInfo{
+					{SnapshotId: aws.String("snap-123"), Description: aws.String("Created by CreateImage(i-123) for ami-0123abcd")},
+				},
+			}},
+			wantIDs: []string{"snap-123", "ami-0123abcd"},
+			wantErr: nil,
+		},
+		{
+			name:    "dry run",
+			giveIDs: []string{"ami-123", "snap-123"},
+			giveEC2: &mockEC2{
+				RespRestoreImageErr:    mockErr{ErrCode: "DryRunOperation"},
+				RespRestoreSnapshotErr: mockErr{ErrCode: "DryRunOperation"},
+			},
+			wantIDs: []string{"snap-123", "ami-123"},
+			wantErr: nil,
+		},
+		{
+			name:    "unknown ID",
+			giveIDs: []string{"i-123", "ami-123"},
+			giveEC2: &mockEC2{},
+			wantIDs: []string{"ami-123"},
+			wantErr: &ErrDeleteAMIs{Op: OpRestore, IDs: []string{"i-123"}},
+		},
+		{
+			name:    "image error",
+			giveIDs: []string{"ami-123", "snap-123"},
+			giveEC2: &mockEC2{RespRestoreImageErr: fmt.Errorf("FAIL")},
+			wantIDs: []string{"snap-123"},
+			wantErr: &ErrDeleteAMIs{Op: OpRestore, IDs: []string{"ami-123"}},
+		},
+		{
+			name:    "list snapshots error",
+			giveIDs: []string{"ami-123", "snap-123"},
+			giveEC2: &mockEC2{RespListSnapshotsInRecycleBinErr: fmt.Errorf("FAIL")},
+			wantIDs: nil,
+			wantErr: fmt.Errorf("%w: %w", ErrListRecycleBinSnapshots, fmt.Errorf("FAIL")),
+		},
+		{
+			name:    "snapshot error",
+			giveIDs: []string{"ami-123", "snap-123"},
+			giveEC2: &mockEC2{RespRestoreSnapshotErr: fmt.Errorf("FAIL")},
+			wantIDs: []string{"ami-123"},
+			wantErr: &ErrDeleteAMIs{Op: OpRestore, IDs: []string{"snap-123"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{
+				cfg: &Config{},
+				ec2: tt.giveEC2,
+			}
+
+			ids, err := aws.RestoreAMIs(tt.giveIDs)
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.Equal(t, tt.wantErr, err)
+				assert.Equal(t, tt.wantErr.Error(), err.Error())
+			}
+
+			assert.Equal(t, tt.wantIDs, ids)
+		})
+	}
+}
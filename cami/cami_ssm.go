@@ -0,0 +1,73 @@
+package cami
+
+import (
+	"context"
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+)
+
+type ssmIf interface {
+	GetParametersByPath(context.Context, *ssm.GetParametersByPathInput, ...func(*ssm.Options)) (*ssm.GetParametersByPathOutput, error)
+}
+
+// SSMParameterDetector finds AMIs referenced by SSM parameters, such as the current
+// golden AMI published as /ami/base/latest.
+type SSMParameterDetector struct {
+	// Paths are the parameter hierarchies to scan recursively, such as /ami
+	Paths []string
+
+	ssm ssmIf
+}
+
+// NewSSMParameterDetector returns a detector that scans the parameters under paths.
+func NewSSMParameterDetector(cfg aws.Config, paths ...string) *SSMParameterDetector {
+	return &SSMParameterDetector{Paths: paths, ssm: ssm.NewFromConfig(cfg)}
+}
+
+// Name returns "ssm".
+func (d *SSMParameterDetector) Name() string {
+	return "ssm"
+}
+
+// Detect returns every AMI ID in the value of a parameter under the paths. Values are
+// not decrypted, so SecureString parameters are skipped.
+func (d *SSMParameterDetector) Detect(ctx context.Context) ([]Usage, error) {
+	var output []Usage
+
+	for _, path := range d.Paths {
+		var nextToken *string
+		for {
+			out, err := d.ssm.GetParametersByPath(ctx, &ssm.GetParametersByPathInput{
+				Path:           aws.String(path),
+				Recursive:      aws.Bool(true),
+				WithDecryption: aws.Bool(false),
+				NextToken:      nextToken,
+			})
+			if err != nil {
+				return output, fmt.Errorf("%w: %w", ErrGetParameters, err)
+			}
+
+			for _, p := range out.Parameters {
+				if p.Type == ssmtypes.ParameterTypeSecureString {
+					continue
+				}
+				for _, id := range findAMIIDs(aws.ToString(p.Value)) {
+					output = append(output, Usage{
+						ImageID: id,
+						Reason:  fmt.Sprintf("referenced by SSM parameter %s", aws.ToString(p.Name)),
+					})
+				}
+			}
+
+			if out.NextToken == nil {
+				break
+			}
+			nextToken = out.NextToken
+		}
+	}
+
+	return output, nil
+}
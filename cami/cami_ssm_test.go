@@ -0,0 +1,103 @@
+package cami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ssm"
+	ssmtypes "github.com/aws/aws-sdk-go-v2/service/ssm/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestSSMParameterDetector(t *testing.T) {
+	t.Parallel()
+
+	param := func(name, value string, typ ssmtypes.ParameterType) ssmtypes.Parameter {
+		return ssmtypes.Parameter{Name: aws.String(name), Value: aws.String(value), Type: typ}
+	}
+
+	tests := []struct {
+		name      string
+		givePaths []string
+		giveSSM   *mockSSM
+		want      []Usage
+		wantCalls int
+		wantErr   error
+	}{
+		{
+			name:      "paginated",
+			givePaths: []string{"/ami", "/golden"},
+			giveSSM: &mockSSM{RespGetParametersByPath: map[string]map[string]ssm.GetParametersByPathOutput{
+				"/ami": {
+					"": {
+						Parameters: []ssmtypes.Parameter{
+							param("/ami/base/latest", "ami-0123456789abcdef0", ssmtypes.ParameterTypeString),
+							param("/ami/base/owner", "infra", ssmtypes.ParameterTypeString),
+						},
+						NextToken: aws.String("page2"),
+					},
+					"page2": {
+						Parameters: []ssmtypes.Parameter{
+							param("/ami/gpu/all", "ami-12345678,ami-87654321", ssmtypes.ParameterTypeStringList),
+							param("/ami/secret", "AQICAHh...ami-11111111", ssmtypes.ParameterTypeSecureString),
+						},
+					},
+				},
+				"/golden": {
+					"": {
+						Parameters: []ssmtypes.Parameter{
+							param("/golden/web", `{"image_id": "ami-22222222"}`, ssmtypes.ParameterTypeString),
+						},
+					},
+				},
+			}},
+			want: []Usage{
+				{ImageID: "ami-0123456789abcdef0", Reason: "referenced by SSM parameter /ami/base/latest"},
+				{ImageID: "ami-12345678", Reason: "referenced by SSM parameter /ami/gpu/all"},
+				{ImageID: "ami-87654321", Reason: "referenced by SSM parameter /ami/gpu/all"},
+				{ImageID: "ami-22222222", Reason: "referenced by SSM parameter /golden/web"},
+			},
+			wantCalls: 3,
+		},
+		{
+			name:      "empty",
+			givePaths: []string{"/ami"},
+			giveSSM:   &mockSSM{},
+			want:      nil,
+			wantCalls: 1,
+		},
+		{
+			name:      "error",
+			givePaths: []string{"/ami"},
+			giveSSM:   &mockSSM{RespGetParametersByPathErr: fmt.Errorf("FAIL")},
+			wantCalls: 1,
+			wantErr:   ErrGetParameters,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := &SSMParameterDetector{Paths: tt.givePaths, ssm: tt.giveSSM}
+			got, err := d.Detect(context.Background())
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Len(t, tt.giveSSM.Inputs, tt.wantCalls)
+			for _, in := range tt.giveSSM.Inputs {
+				assert.True(t, aws.ToBool(in.Recursive))
+				assert.False(t, aws.ToBool(in.WithDecryption))
+			}
+		})
+	}
+}
@@ -0,0 +1,211 @@
+package cami
+
+import (
+	"fmt"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+const (
+	// gib is the number of bytes in a GiB, the unit EBS is billed in.
+	gib = 1 << 30
+
+	// describeSnapshotsBatch is how many snapshot IDs we describe at once.
+	describeSnapshotsBatch = 200
+
+	// AllRegions is the PriceTable key for prices that apply to every region.
+	AllRegions = "*"
+)
+
+// PriceTable holds the price in USD per GB-month of snapshot storage, keyed by region
+// (or AllRegions) and then by storage tier ("standard" or "archive").
+type PriceTable map[string]map[string]float64
+
+// DefaultPrices returns the default price table, based on us-east-1 pricing.
+func DefaultPrices() PriceTable {
+	return PriceTable{
+		AllRegions: {
+			string(types.StorageTierStandard): 0.05,   //nolint:mnd
+			string(types.StorageTierArchive):  0.0125, //nolint:mnd
+		},
+	}
+}
+
+// Price returns the price per GB-month of the tier in the region. Prices for the region
+// take precedence over prices for AllRegions, which take precedence over DefaultPrices.
+func (p PriceTable) Price(region, tier string) float64 {
+	for _, table := range []PriceTable{p, DefaultPrices()} {
+		if price, ok := table[region][tier]; ok {
+			return price
+		}
+		if price, ok := table[AllRegions][tier]; ok {
+			return price
+		}
+	}
+	return 0
+}
+
+// SnapshotStorage is the storage used by a single snapshot.
+type SnapshotStorage struct {
+	// SnapshotID is the ID of the snapshot
+	SnapshotID string
+	// Tier is the storage tier of the snapshot
+	Tier string
+	// Bytes is the full size of the snapshot, or the size of its volume if unknown
+	Bytes int64
+	// MonthlyCost is the estimated monthly cost of storing the snapshot in USD
+	MonthlyCost float64
+}
+
+// ImageStorage is the storage used by the snapshots backing a single AMI.
+type ImageStorage struct {
+	// ImageID is the ID of the AMI
+	ImageID string
+	// Name is the name of the AMI
+	Name string
+	// Snapshots is the storage used by each snapshot backing the AMI
+	Snapshots []SnapshotStorage
+	// Bytes is the total size of the snapshots
+	Bytes int64
+	// MonthlyCost is the estimated monthly cost of the snapshots in USD
+	MonthlyCost float64
+}
+
+// StorageReport is the snapshot storage used by a list of AMIs.
+type StorageReport struct {
+	// Images is the storage used by each AMI
+	Images []ImageStorage
+	// Bytes is the total size of all snapshots, counting shared snapshots once
+	Bytes int64
+	// MonthlyCost is the estimated monthly cost of all snapshots in USD
+	MonthlyCost float64
+}
+
+// prices returns the configured price table or the defaults.
+func (c *Config) prices() PriceTable {
+	if c == nil || c.Prices == nil {
+		return DefaultPrices()
+	}
+	return c.Prices
+}
+
+// Storage returns the snapshot storage used by the provided AMIs and its estimated
+// monthly cost. Snapshot sizes come from DescribeSnapshots, using the full snapshot
+// size when AWS reports it and the volume size otherwise.
+func (a *AWS) Storage(amis []types.Image) (*StorageReport, error) {
+	output := &StorageReport{}
+
+	var snapIDs []string
+	for _, ami := range amis {
+		for _, bdm := range ami.BlockDeviceMappings {
+			if bdm.Ebs != nil && bdm.Ebs.SnapshotId != nil {
+				snapIDs = append(snapIDs, *bdm.Ebs.SnapshotId)
+			}
+		}
+	}
+
+	snaps, err := a.snapshots(snapIDs)
+	if err != nil {
+		return output, err
+	}
+
+	counted := make(map[string]bool)
+	for _, ami := range amis {
+		is := ImageStorage{ImageID: *ami.ImageId}
+		if ami.Name != nil {
+			is.Name = *ami.Name
+		}
+
+		for _, bdm := range ami.BlockDeviceMappings {
+			if bdm.Ebs == nil || bdm.Ebs.SnapshotId == nil {
+				continue
+			}
+
+			ss := a.snapshotStorage(*bdm.Ebs.SnapshotId, snaps)
+			is.Snapshots = append(is.Snapshots, ss)
+			is.Bytes += ss.Bytes
+			is.MonthlyCost += ss.MonthlyCost
+
+			if !counted[ss.SnapshotID] {
+				counted[ss.SnapshotID] = true
+				output.Bytes += ss.Bytes
+				output.MonthlyCost += ss.MonthlyCost
+			}
+		}
+
+		output.Images = append(output.Images, is)
+	}
+
+	return output, nil
+}
+
+// snapshotStorage returns the storage used by the snapshot with the provided ID.
+// Snapshots that could not be described are reported with zero size.
+func (a *AWS) snapshotStorage(id string, snaps map[string]types.Snapshot) SnapshotStorage {
+	ss := SnapshotStorage{SnapshotID: id, Tier: string(types.StorageTierStandard)}
+
+	snap, ok := snaps[id]
+	if !ok {
+		return ss
+	}
+
+	if snap.StorageTier != "" {
+		ss.Tier = string(snap.StorageTier)
+	}
+	switch {
+	case snap.FullSnapshotSizeInBytes != nil:
+		ss.Bytes = *snap.FullSnapshotSizeInBytes
+	case snap.VolumeSize != nil:
+		ss.Bytes = int64(*snap.VolumeSize) * gib
+	}
+	ss.MonthlyCost = a.cost(ss.Bytes, ss.Tier)
+
+	return ss
+}
+
+// cost returns the estimated monthly cost of storing bytes in the tier.
+func (a *AWS) cost(bytes int64, tier string) float64 {
+	return float64(bytes) / gib * a.cfg.prices().Price(a.region, tier)
+}
+
+// snapshots describes the snapshots with the provided IDs, returning them by ID.
+func (a *AWS) snapshots(ids []string) (map[string]types.Snapshot, error) {
+	output := make(map[string]types.Snapshot)
+
+	for start := 0; start < len(ids); start += describeSnapshotsBatch {
+		end := start + describeSnapshotsBatch
+		if end > len(ids) {
+			end = len(ids)
+		}
+
+		var nextToken *string
+		for {
+			snapI := &ec2.DescribeSnapshotsInput{
+				OwnerIds:    []string{"self"},
+				SnapshotIds: ids[start:end],
+				NextToken:   nextToken,
+			}
+			var snapO *ec2.DescribeSnapshotsOutput
+			err := a.call("DescribeSnapshots", func() error {
+				var err error
+				snapO, err = a.ec2.DescribeSnapshots(a.ctx(), snapI)
+				return err
+			})
+			if err != nil {
+				return output, fmt.Errorf("%w", ErrDescribeSnapshots)
+			}
+
+			for _, snap := range snapO.Snapshots {
+				output[*snap.SnapshotId] = snap
+			}
+
+			if snapO.NextToken == nil {
+				break
+			}
+			nextToken = snapO.NextToken
+		}
+	}
+
+	return output, nil
+}
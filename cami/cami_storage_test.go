@@ -0,0 +1,133 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestPrice(t *testing.T) {
+	t.Parallel()
+
+	prices := PriceTable{
+		"eu-west-1": {"standard": 0.06},
+		AllRegions:  {"archive": 0.01},
+	}
+
+	assert.Equal(t, 0.06, prices.Price("eu-west-1", "standard"))
+	assert.Equal(t, 0.01, prices.Price("eu-west-1", "archive"))
+	assert.Equal(t, 0.05, prices.Price("us-east-1", "standard"))
+	assert.Equal(t, 0.0, prices.Price("us-east-1", "glacier"))
+}
+
+func TestStorage(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{
+			ImageId: aws.String("ami-123"),
+			Name:    aws.String("one"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-123")}},
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-456")}},
+				{VirtualName: aws.String("ephemeral0")},
+			},
+		},
+		{
+			ImageId: aws.String("ami-456"),
+			BlockDeviceMappings: []types.BlockDeviceMapping{
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-456")}},
+				{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-missing")}},
+			},
+		},
+	}
+	snaps := ec2.DescribeSnapshotsOutput{
+		Snapshots: []types.Snapshot{
+			{
+				SnapshotId:              aws.String("snap-123"),
+				VolumeSize:              aws.Int32(100),
+				FullSnapshotSizeInBytes: aws.Int64(10 * gib),
+				StorageTier:             types.StorageTierStandard,
+			},
+			{
+				SnapshotId:  aws.String("snap-456"),
+				VolumeSize:  aws.Int32(20),
+				StorageTier: types.StorageTierArchive,
+			},
+		},
+	}
+
+	tests := []struct {
+		name       string
+		giveOutput ec2.DescribeSnapshotsOutput
+		giveErr    error
+		wantReport *StorageReport
+		wantErr    error
+	}{
+		{
+			name:       "error",
+			giveErr:    fmt.Errorf("FAIL"),
+			wantReport: &StorageReport{},
+			wantErr:    ErrDescribeSnapshots,
+		},
+		{
+			name:       "storage",
+			giveOutput: snaps,
+			wantReport: &StorageReport{
+				Images: []ImageStorage{
+					{
+						ImageID: "ami-123",
+						Name:    "one",
+						Snapshots: []SnapshotStorage{
+							{SnapshotID: "snap-123", Tier: "standard", Bytes: 10 * gib, MonthlyCost: 0.5},
+							{SnapshotID: "snap-456", Tier: "archive", Bytes: 20 * gib, MonthlyCost: 0.25},
+						},
+						Bytes:       30 * gib,
+						MonthlyCost: 0.75,
+					},
+					{
+						ImageID: "ami-456",
+						Snapshots: []SnapshotStorage{
+							{SnapshotID: "snap-456", Tier: "archive", Bytes: 20 * gib, MonthlyCost: 0.25},
+							{SnapshotID: "snap-missing", Tier: "standard"},
+						},
+						Bytes:       20 * gib,
+						MonthlyCost: 0.25,
+					},
+				},
+				Bytes:       30 * gib,
+				MonthlyCost: 0.75,
+			},
+			wantErr: nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{
+				ec2: &mockEC2{
+					RespDescSnapshots:    tt.giveOutput,
+					RespDescSnapshotsErr: tt.giveErr,
+				},
+			}
+
+			report, err := aws.Storage(amis)
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantReport, report)
+		})
+	}
+}
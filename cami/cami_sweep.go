@@ -0,0 +1,169 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// DefaultMarkTagKey is the tag key MarkAndSweep uses when Config.MarkTagKey is empty.
+const DefaultMarkTagKey = "cami:marked-at"
+
+// SweepResult is the outcome of a MarkAndSweep run.
+type SweepResult struct {
+	// RunID identifies the run, and is the RunID of every ImageEvent it published
+	RunID string
+	// Marked is the list of AMI IDs that were newly marked as unused
+	Marked []string
+	// Unmarked is the list of AMI IDs whose mark was cleared because they are used again
+	Unmarked []string
+	// Swept is the list of AMI and snapshot IDs that were acted on after the grace period
+	Swept []string
+	// ImageBuilderImages is the ARNs of the Image Builder images that were deleted. See
+	// ImageBuilderDelete.
+	ImageBuilderImages []string
+}
+
+// markTagKey returns the configured mark tag key or the default.
+func (c *Config) markTagKey() string {
+	if c == nil || c.MarkTagKey == "" {
+		return DefaultMarkTagKey
+	}
+	return c.MarkTagKey
+}
+
+// gracePeriod returns the configured grace period or zero.
+func (c *Config) gracePeriod() time.Duration {
+	if c == nil {
+		return 0
+	}
+	return c.GracePeriod
+}
+
+// MarkAndSweep is a two phase alternative to DeleteUnusedAMIs that uses tags to keep
+// state between runs. Unused AMIs are first tagged with the current time. On later
+// runs, marked AMIs that are still unused after the grace period are deleted (or
+// handled according to the configured Action) and marked AMIs that are used again
+// have their mark removed.
+func (a *AWS) MarkAndSweep() (*SweepResult, error) {
+	start := time.Now()
+	output, err := a.markAndSweep()
+	a.cfg.metrics().ObserveRun(time.Since(start), err)
+
+	return output, err
+}
+
+// markAndSweep does the work of MarkAndSweep.
+func (a *AWS) markAndSweep() (*SweepResult, error) {
+	var err error
+	output := &SweepResult{}
+	eda := &ErrDeleteAMIs{}
+
+	plan, err := a.Plan()
+	if err != nil {
+		return output, err
+	}
+
+	isUnused := make(map[string]bool)
+	for _, ami := range plan.Candidates {
+		isUnused[*ami.ImageId] = true
+	}
+
+	var sweep []types.Image
+	for _, ami := range plan.Images {
+		markedAt, marked := a.markedAt(ami)
+
+		switch {
+		case isUnused[*ami.ImageId] && !marked:
+			a.mutate(*ami.ImageId, &output.Marked, eda, func() error { return a.markImage(ami) })
+		case isUnused[*ami.ImageId] && a.now().Sub(markedAt) >= a.cfg.gracePeriod():
+			sweep = append(sweep, ami)
+		case !isUnused[*ami.ImageId] && marked:
+			a.mutate(*ami.ImageId, &output.Unmarked, eda, func() error { return a.unmarkImage(ami) })
+		}
+	}
+
+	err = a.checkLimits(sweep, plan.Images)
+	if err != nil {
+		return output, err
+	}
+
+	if len(sweep) > 0 && a.cfg != nil && a.cfg.ConfirmSweep != nil {
+		confirm := *plan
+		confirm.Candidates = sweep
+		sweep = a.cfg.ConfirmSweep(&confirm)
+	}
+
+	if len(sweep) > 0 {
+		err = a.recycleBinPreflight()
+		if err != nil {
+			return output, err
+		}
+	}
+
+	swept, err := a.deleteAMIs(sweep)
+	output.RunID, output.Swept, output.ImageBuilderImages = swept.RunID, swept.IDs, swept.ImageBuilderImages
+	if err != nil {
+		var sweepErr *ErrDeleteAMIs
+		if !errors.As(err, &sweepErr) {
+			return output, err
+		}
+		eda.Append(sweepErr.IDs...)
+	}
+
+	err = a.stopped()
+	if err != nil {
+		return output, errors.Join(err, eda.ErrorOrNil())
+	}
+	return output, eda.ErrorOrNil()
+}
+
+// markedAt returns the time an AMI was marked as unused. The bool is false if the AMI
+// is not marked or the mark cannot be parsed.
+func (a *AWS) markedAt(ami types.Image) (time.Time, bool) {
+	for _, tag := range ami.Tags {
+		if tag.Key == nil || tag.Value == nil || *tag.Key != a.cfg.markTagKey() {
+			continue
+		}
+
+		t, err := time.Parse(time.RFC3339, *tag.Value)
+		if err != nil {
+			return time.Time{}, false
+		}
+		return t, true
+	}
+
+	return time.Time{}, false
+}
+
+// markImage tags a single AMI with the mark tag key and the current time.
+func (a *AWS) markImage(ami types.Image) error {
+	return a.createTags([]string{*ami.ImageId}, types.Tag{
+		Key:   aws.String(a.cfg.markTagKey()),
+		Value: aws.String(a.now().UTC().Format(time.RFC3339)),
+	})
+}
+
+// unmarkImage removes the mark tag from a single AMI.
+func (a *AWS) unmarkImage(ami types.Image) error {
+	tagI := &ec2.DeleteTagsInput{
+		Resources: []string{*ami.ImageId},
+		Tags: []types.Tag{
+			{Key: aws.String(a.cfg.markTagKey())},
+		},
+		DryRun: aws.Bool(a.cfg.DryRun),
+	}
+	err := a.audited("DeleteTags", *ami.ImageId, func() error {
+		_, err := a.ec2.DeleteTags(a.mutationCtx(), tagI)
+		return err
+	})
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrDeleteTags, err)
+	}
+
+	return nil
+}
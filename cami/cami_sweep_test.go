@@ -0,0 +1,170 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestMarkAndSweep(t *testing.T) {
+	t.Parallel()
+
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+	marked := func(at string) []types.Tag {
+		return []types.Tag{{Key: aws.String(DefaultMarkTagKey), Value: aws.String(at)}}
+	}
+
+	images := ec2.DescribeImagesOutput{
+		Images: []types.Image{
+			{ImageId: aws.String("ami-new")},
+			{
+				ImageId: aws.String("ami-old"),
+				Tags:    marked("2021-01-01T00:00:00Z"),
+				BlockDeviceMappings: []types.BlockDeviceMapping{
+					{Ebs: &types.EbsBlockDevice{SnapshotId: aws.String("snap-old")}},
+				},
+			},
+			{ImageId: aws.String("ami-recent"), Tags: marked("2021-01-09T00:00:00Z")},
+			{ImageId: aws.String("ami-bad"), Tags: marked("yesterday")},
+			{ImageId: aws.String("ami-used"), Tags: marked("2021-01-01T00:00:00Z")},
+			{ImageId: aws.String("ami-used2")},
+		},
+	}
+	instances := ec2.DescribeInstancesOutput{
+		Reservations: []types.Reservation{{Instances: []types.Instance{
+			{ImageId: aws.String("ami-used")},
+			{ImageId: aws.String("ami-used2")},
+		}}},
+	}
+
+	tests := []struct {
+		name        string
+		giveEC2     *mockEC2
+		giveConfirm func(*Plan) []types.Image
+		wantResult  *SweepResult
+		wantErr     error
+		wantType    error
+	}{
+		{
+			name:       "error describe images",
+			giveEC2:    &mockEC2{RespDescImagesErr: fmt.Errorf("FAIL")},
+			wantResult: &SweepResult{},
+			wantErr:    ErrDesribeImages,
+		},
+		{
+			name:       "error describe instances",
+			giveEC2:    &mockEC2{RespDescImages: images, RespDescInstancesErr: fmt.Errorf("FAIL")},
+			wantResult: &SweepResult{},
+			wantErr:    ErrDesribeInstances,
+		},
+		{
+			name: "mark unmark and sweep",
+			giveEC2: &mockEC2{
+				RespDescImages:    images,
+				RespDescInstances: instances,
+			},
+			wantResult: &SweepResult{
+				Marked:   []string{"ami-new", "ami-bad"},
+				Unmarked: []string{"ami-used"},
+				Swept:    []string{"ami-old", "snap-old"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "sweep confirmed",
+			giveEC2: &mockEC2{
+				RespDescImages:    images,
+				RespDescInstances: instances,
+			},
+			giveConfirm: func(p *Plan) []types.Image {
+				if len(p.Candidates) != 1 || *p.Candidates[0].ImageId != "ami-old" {
+					return nil
+				}
+				return p.Candidates
+			},
+			wantResult: &SweepResult{
+				Marked:   []string{"ami-new", "ami-bad"},
+				Unmarked: []string{"ami-used"},
+				Swept:    []string{"ami-old", "snap-old"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "sweep declined",
+			giveEC2: &mockEC2{
+				RespDescImages:    images,
+				RespDescInstances: instances,
+			},
+			giveConfirm: func(*Plan) []types.Image { return nil },
+			wantResult: &SweepResult{
+				Marked:   []string{"ami-new", "ami-bad"},
+				Unmarked: []string{"ami-used"},
+			},
+			wantErr: nil,
+		},
+		{
+			name: "tag errors",
+			giveEC2: &mockEC2{
+				RespDescImages:    images,
+				RespDescInstances: instances,
+				RespCreateTagsErr: fmt.Errorf("FAIL"),
+				RespDeleteTagsErr: fmt.Errorf("FAIL"),
+			},
+			wantResult: &SweepResult{
+				Swept: []string{"ami-old", "snap-old"},
+			},
+			wantType: &ErrDeleteAMIs{IDs: []string{"ami-new", "ami-bad", "ami-used"}},
+		},
+		{
+			name: "sweep errors",
+			giveEC2: &mockEC2{
+				RespDescImages:         images,
+				RespDescInstances:      instances,
+				RespDeregisterImageErr: fmt.Errorf("FAIL"),
+			},
+			wantResult: &SweepResult{
+				Marked:   []string{"ami-new", "ami-bad"},
+				Unmarked: []string{"ami-used"},
+				Swept:    []string{"snap-old"},
+			},
+			wantType: &ErrDeleteAMIs{IDs: []string{"ami-old"}},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			aws := AWS{
+				cfg:   &Config{GracePeriod: 7 * 24 * time.Hour, ConfirmSweep: tt.giveConfirm},
+				ec2:   tt.giveEC2,
+				nowFn: func() time.Time { return now },
+			}
+
+			result, err := aws.MarkAndSweep()
+
+			switch {
+			case tt.wantType != nil:
+				assert.Equal(t, tt.wantType, err)
+			case tt.wantErr == nil:
+				assert.Nil(t, err)
+			default:
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			// the run ID is random, so only check that one was set
+			if tt.wantResult != nil && result != nil && result.RunID != "" {
+				assert.Len(t, result.RunID, 32)
+				result.RunID = ""
+			}
+			assert.Equal(t, tt.wantResult, result)
+		})
+	}
+}
@@ -0,0 +1,155 @@
+package cami
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// terraformStateVersion is the only Terraform state format version we can read.
+const terraformStateVersion = 4
+
+// terraformState is the part of a version 4 Terraform state file we read.
+type terraformState struct {
+	Version   int                 `json:"version"`
+	Resources []terraformResource `json:"resources"`
+}
+
+type terraformResource struct {
+	Module    string              `json:"module"`
+	Mode      string              `json:"mode"`
+	Type      string              `json:"type"`
+	Name      string              `json:"name"`
+	Instances []terraformInstance `json:"instances"`
+}
+
+type terraformInstance struct {
+	IndexKey   any            `json:"index_key"`
+	Attributes map[string]any `json:"attributes"`
+}
+
+// TerraformStateDetector finds AMIs referenced by any attribute of any resource or data
+// source in local Terraform state files, such as aws_instance.ami or
+// aws_launch_template.image_id, so that AMIs pinned in Terraform are kept even while
+// nothing is running from them.
+type TerraformStateDetector struct {
+	// Paths are the state files to read, in the version 4 JSON format
+	Paths []string
+}
+
+// Name returns "terraform".
+func (d *TerraformStateDetector) Name() string {
+	return "terraform"
+}
+
+// Detect returns every AMI ID referenced in the state files.
+func (d *TerraformStateDetector) Detect(_ context.Context) ([]Usage, error) {
+	var output []Usage
+
+	for _, path := range d.Paths {
+		usages, err := terraformUsages(path)
+		if err != nil {
+			return output, err
+		}
+		output = append(output, usages...)
+	}
+
+	return output, nil
+}
+
+// terraformUsages returns every AMI ID referenced in the state file at path.
+func terraformUsages(path string) ([]Usage, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %w", ErrTerraformState, err)
+	}
+
+	var state terraformState
+	err = json.Unmarshal(b, &state)
+	if err != nil {
+		return nil, fmt.Errorf("%w: %s: %w", ErrTerraformState, path, err)
+	}
+	if state.Version != terraformStateVersion {
+		return nil, fmt.Errorf("%w: %s: unsupported version %d", ErrTerraformState, path, state.Version)
+	}
+
+	var output []Usage
+	seen := make(map[Usage]bool)
+	for _, res := range state.Resources {
+		for _, inst := range res.Instances {
+			address := res.address(inst.IndexKey)
+
+			names := make([]string, 0, len(inst.Attributes))
+			for name := range inst.Attributes {
+				names = append(names, name)
+			}
+			sort.Strings(names)
+
+			for _, name := range names {
+				for _, id := range amiIDsIn(inst.Attributes[name]) {
+					u := Usage{
+						ImageID: id,
+						Reason:  fmt.Sprintf("referenced by %s.%s in terraform state %s", address, name, path),
+					}
+					if !seen[u] {
+						seen[u] = true
+						output = append(output, u)
+					}
+				}
+			}
+		}
+	}
+
+	return output, nil
+}
+
+// address returns the Terraform address of an instance of the resource, such as
+// module.web.aws_instance.this[0].
+func (r terraformResource) address(key any) string {
+	var parts []string
+	if r.Module != "" {
+		parts = append(parts, r.Module)
+	}
+	if r.Mode == "data" {
+		parts = append(parts, "data")
+	}
+	parts = append(parts, r.Type, r.Name)
+	address := strings.Join(parts, ".")
+
+	switch k := key.(type) {
+	case string:
+		address += fmt.Sprintf("[%q]", k)
+	case float64:
+		address += fmt.Sprintf("[%d]", int(k))
+	}
+
+	return address
+}
+
+// amiIDsIn returns every AMI ID in a decoded JSON value.
+func amiIDsIn(v any) []string {
+	var output []string
+
+	switch v := v.(type) {
+	case string:
+		output = append(output, findAMIIDs(v)...)
+	case []any:
+		for _, e := range v {
+			output = append(output, amiIDsIn(e)...)
+		}
+	case map[string]any:
+		keys := make([]string, 0, len(v))
+		for k := range v {
+			keys = append(keys, k)
+		}
+		sort.Strings(keys)
+		for _, k := range keys {
+			output = append(output, amiIDsIn(v[k])...)
+		}
+	}
+
+	return output
+}
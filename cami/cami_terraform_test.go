@@ -0,0 +1,129 @@
+package cami
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+const testTerraformState = `{
+  "version": 4,
+  "terraform_version": "1.5.7",
+  "serial": 12,
+  "resources": [
+    {
+      "mode": "managed",
+      "type": "aws_instance",
+      "name": "web",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"index_key": 0, "schema_version": 1, "attributes": {"ami": "ami-0123456789abcdef0", "instance_type": "t3.micro"}},
+        {"index_key": 1, "schema_version": 1, "attributes": {"ami": "ami-0123456789abcdef0", "instance_type": "t3.micro"}}
+      ]
+    },
+    {
+      "module": "module.workers",
+      "mode": "managed",
+      "type": "aws_launch_template",
+      "name": "this",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"index_key": "gpu", "schema_version": 0, "attributes": {"image_id": "ami-12345678", "tags": {"Name": "workers"}}}
+      ]
+    },
+    {
+      "mode": "data",
+      "type": "aws_ami",
+      "name": "base",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"schema_version": 0, "attributes": {"id": "ami-87654321", "block_device_mappings": [{"ebs": {"snapshot_id": "snap-12345678"}}]}}
+      ]
+    },
+    {
+      "mode": "managed",
+      "type": "aws_s3_bucket",
+      "name": "logs",
+      "provider": "provider[\"registry.terraform.io/hashicorp/aws\"]",
+      "instances": [
+        {"schema_version": 0, "attributes": {"bucket": "logs"}}
+      ]
+    }
+  ]
+}`
+
+func TestTerraformStateDetector(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	write := func(name, content string) string {
+		path := filepath.Join(dir, name)
+		assert.Nil(t, os.WriteFile(path, []byte(content), 0o600))
+		return path
+	}
+
+	state := write("prod.tfstate", testTerraformState)
+	empty := write("empty.tfstate", `{"version": 4, "resources": []}`)
+	v3 := write("v3.tfstate", `{"version": 3, "modules": []}`)
+	invalid := write("invalid.tfstate", `{"version": 4,`)
+
+	tests := []struct {
+		name      string
+		givePaths []string
+		want      []Usage
+		wantErr   error
+	}{
+		{
+			name:      "state",
+			givePaths: []string{state, empty},
+			want: []Usage{
+				{ImageID: "ami-0123456789abcdef0", Reason: "referenced by aws_instance.web[0].ami in terraform state " + state},
+				{ImageID: "ami-0123456789abcdef0", Reason: "referenced by aws_instance.web[1].ami in terraform state " + state},
+				{ImageID: "ami-12345678", Reason: `referenced by module.workers.aws_launch_template.this["gpu"].image_id in terraform state ` + state},
+				{ImageID: "ami-87654321", Reason: "referenced by data.aws_ami.base.id in terraform state " + state},
+			},
+		},
+		{
+			name:      "empty",
+			givePaths: []string{empty},
+			want:      nil,
+		},
+		{
+			name:      "missing",
+			givePaths: []string{filepath.Join(dir, "missing.tfstate")},
+			wantErr:   ErrTerraformState,
+		},
+		{
+			name:      "unsupported version",
+			givePaths: []string{v3},
+			wantErr:   ErrTerraformState,
+		},
+		{
+			name:      "invalid",
+			givePaths: []string{invalid},
+			wantErr:   ErrTerraformState,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			d := &TerraformStateDetector{Paths: tt.givePaths}
+			got, err := d.Detect(context.Background())
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+		})
+	}
+}
@@ -5,11 +5,15 @@ import (
 	"errors"
 	"fmt"
 	"testing"
+	"time"
 
 	"github.com/aws/aws-sdk-go-v2/aws"
 	"github.com/aws/aws-sdk-go-v2/config"
 	"github.com/aws/aws-sdk-go-v2/service/ec2"
 	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/aws/aws-sdk-go-v2/service/imagebuilder"
+	"github.com/aws/aws-sdk-go-v2/service/rbin"
+	"github.com/aws/aws-sdk-go-v2/service/sts"
 	"github.com/stretchr/testify/assert"
 )
 
@@ -92,12 +96,17 @@ func TestAuth(t *testing.T) {
 		{
 			name: "valid",
 			give: &AWS{
-				newEC2Fn: func(aws.Config, ...func(*ec2.Options)) *ec2.Client { return &ec2.Client{} },
+				newEC2Fn:  func(aws.Config, ...func(*ec2.Options)) *ec2.Client { return &ec2.Client{} },
+				newRbinFn: func(aws.Config, ...func(*rbin.Options)) *rbin.Client { return &rbin.Client{} },
+				newSTSFn:  func(aws.Config, ...func(*sts.Options)) *sts.Client { return &sts.Client{} },
+				newImageBuilderFn: func(aws.Config, ...func(*imagebuilder.Options)) *imagebuilder.Client {
+					return &imagebuilder.Client{}
+				},
 				newConfigFn: func(context.Context, ...func(*config.LoadOptions) error) (aws.Config, error) {
 					return aws.Config{}, nil
 				},
 			},
-			wantAWS: &AWS{ec2: &ec2.Client{}},
+			wantAWS: &AWS{ec2: &ec2.Client{}, rbin: &rbin.Client{}, sts: &sts.Client{}, imagebuilder: &imagebuilder.Client{}},
 			wantErr: nil,
 		},
 	}
@@ -116,6 +125,141 @@ func TestAuth(t *testing.T) {
 			}
 
 			assert.Equal(t, tt.wantAWS.ec2, tt.give.ec2)
+			assert.Equal(t, tt.wantAWS.rbin, tt.give.rbin)
+			assert.Equal(t, tt.wantAWS.sts, tt.give.sts)
+			assert.Equal(t, tt.wantAWS.imagebuilder, tt.give.imagebuilder)
+		})
+	}
+}
+
+func TestConfigValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		give    *Config
+		wantErr error
+	}{
+		{name: "nil", give: nil},
+		{name: "empty", give: &Config{}},
+		{
+			name: "instance states",
+			give: &Config{InstanceStates: []types.InstanceStateName{types.InstanceStateNameRunning, types.InstanceStateNameStopped}},
+		},
+		{
+			name:    "invalid instance state",
+			give:    &Config{InstanceStates: []types.InstanceStateName{types.InstanceStateNameRunning, "runing"}},
+			wantErr: ErrInvalidInstanceState,
+		},
+		{
+			name: "image builder mode",
+			give: &Config{ImageBuilder: ImageBuilderDelete},
+		},
+		{
+			name:    "invalid image builder mode",
+			give:    &Config{ImageBuilder: "protected"},
+			wantErr: ErrInvalidImageBuilderMode,
+		},
+		{
+			name:    "invalid recycle bin mode",
+			give:    &Config{RecycleBin: "requre"},
+			wantErr: ErrInvalidRecycleBinMode,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			// no AWS API is called with an invalid configuration
+			a := &AWS{
+				cfg: tt.give,
+				ec2: &mockEC2{RespDescImagesErr: fmt.Errorf("FAIL")},
+				newConfigFn: func(context.Context, ...func(*config.LoadOptions) error) (aws.Config, error) {
+					return aws.Config{}, fmt.Errorf("FAIL")
+				},
+			}
+			_, planErr := a.Plan()
+			authErr := a.Auth()
+
+			if tt.wantErr == nil {
+				assert.Nil(t, tt.give.Validate())
+				assert.True(t, errors.Is(planErr, ErrDesribeImages))
+				assert.True(t, errors.Is(authErr, ErrCreateSession))
+			} else {
+				assert.True(t, errors.Is(tt.give.Validate(), tt.wantErr))
+				assert.True(t, errors.Is(planErr, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, planErr))
+				assert.True(t, errors.Is(authErr, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, authErr))
+			}
+		})
+	}
+}
+
+func TestAuthRegion(t *testing.T) {
+	t.Parallel()
+
+	var region string
+	a := &AWS{
+		cfg:       &Config{Region: "eu-west-1"},
+		newEC2Fn:  func(aws.Config, ...func(*ec2.Options)) *ec2.Client { return &ec2.Client{} },
+		newRbinFn: func(aws.Config, ...func(*rbin.Options)) *rbin.Client { return &rbin.Client{} },
+		newSTSFn:  func(aws.Config, ...func(*sts.Options)) *sts.Client { return &sts.Client{} },
+		newImageBuilderFn: func(aws.Config, ...func(*imagebuilder.Options)) *imagebuilder.Client {
+			return &imagebuilder.Client{}
+		},
+		newConfigFn: func(_ context.Context, opts ...func(*config.LoadOptions) error) (aws.Config, error) {
+			var lo config.LoadOptions
+			for _, opt := range opts {
+				assert.Nil(t, opt(&lo))
+			}
+			region = lo.Region
+			return aws.Config{Region: lo.Region}, nil
+		},
+	}
+
+	assert.Nil(t, a.Auth())
+	assert.Equal(t, "eu-west-1", region)
+	assert.Equal(t, "eu-west-1", a.region)
+}
+
+func TestImageFilters(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		give *Config
+		want []types.Filter
+	}{
+		{
+			name: "nil",
+			give: nil,
+			want: nil,
+		},
+		{
+			name: "empty",
+			give: &Config{},
+			want: nil,
+		},
+		{
+			name: "sorted",
+			give: &Config{ImageFilters: map[string][]string{
+				"tag:team": {"infra"},
+				"name":     {"build-*", "ci-*"},
+			}},
+			want: []types.Filter{
+				{Name: aws.String("name"), Values: []string{"build-*", "ci-*"}},
+				{Name: aws.String("tag:team"), Values: []string{"infra"}},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, tt.give.imageFilters())
 		})
 	}
 }
@@ -313,12 +457,16 @@ func TestEC2s(t *testing.T) {
 func TestFilterAMIs(t *testing.T) {
 	t.Parallel()
 
+	now := time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC)
+
 	tests := []struct {
-		name     string
-		giveAMIs []types.Image
-		giveEC2s []types.Instance
-		wantAMIs []types.Image
-		wantErr  error
+		name         string
+		giveCfg      *Config
+		giveAMIs     []types.Image
+		giveEC2s     []types.Instance
+		giveLaunched map[string]ec2.DescribeImageAttributeOutput
+		wantAMIs     []types.Image
+		wantErr      error
 	}{
 		{
 			name:     "nil",
@@ -349,6 +497,107 @@ func TestFilterAMIs(t *testing.T) {
 			},
 			wantErr: nil,
 		},
+		{
+			name: "default states",
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+				{ImageId: aws.String("ami-456")},
+				{ImageId: aws.String("ami-789")},
+			},
+			giveEC2s: []types.Instance{
+				{
+					ImageId: aws.String("ami-123"),
+					State:   &types.InstanceState{Name: types.InstanceStateNameTerminated},
+				},
+				{
+					ImageId: aws.String("ami-456"),
+					State:   &types.InstanceState{Name: types.InstanceStateNameShuttingDown},
+				},
+				{
+					ImageId: aws.String("ami-789"),
+					State:   &types.InstanceState{Name: types.InstanceStateNameStopped},
+				},
+			},
+			wantAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+				{ImageId: aws.String("ami-456")},
+			},
+			wantErr: nil,
+		},
+		{
+			name:    "configured states",
+			giveCfg: &Config{InstanceStates: []types.InstanceStateName{types.InstanceStateNameRunning}},
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+				{ImageId: aws.String("ami-456")},
+			},
+			giveEC2s: []types.Instance{
+				{
+					ImageId: aws.String("ami-123"),
+					State:   &types.InstanceState{Name: types.InstanceStateNameRunning},
+				},
+				{
+					ImageId: aws.String("ami-456"),
+					State:   &types.InstanceState{Name: types.InstanceStateNameStopped},
+				},
+			},
+			wantAMIs: []types.Image{
+				{ImageId: aws.String("ami-456")},
+			},
+			wantErr: nil,
+		},
+		{
+			name:    "stopped max age",
+			giveCfg: &Config{StoppedMaxAge: 72 * time.Hour},
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+				{ImageId: aws.String("ami-456")},
+				{ImageId: aws.String("ami-789")},
+			},
+			giveEC2s: []types.Instance{
+				{
+					ImageId:               aws.String("ami-123"),
+					State:                 &types.InstanceState{Name: types.InstanceStateNameStopped},
+					StateTransitionReason: aws.String("User initiated (2021-01-09 12:00:00 GMT)"),
+				},
+				{
+					ImageId:               aws.String("ami-456"),
+					State:                 &types.InstanceState{Name: types.InstanceStateNameStopped},
+					StateTransitionReason: aws.String("User initiated (2021-01-01 12:00:00 GMT)"),
+				},
+				{
+					ImageId:               aws.String("ami-789"),
+					State:                 &types.InstanceState{Name: types.InstanceStateNameStopped},
+					StateTransitionReason: aws.String(""),
+				},
+			},
+			wantAMIs: []types.Image{
+				{ImageId: aws.String("ami-456")},
+			},
+			wantErr: nil,
+		},
+		{
+			name:    "launched within",
+			giveCfg: &Config{LaunchedWithin: 72 * time.Hour},
+			giveAMIs: []types.Image{
+				{ImageId: aws.String("ami-123")},
+				{ImageId: aws.String("ami-456")},
+				{ImageId: aws.String("ami-789")},
+				{ImageId: aws.String("ami-000")},
+			},
+			giveEC2s: []types.Instance{
+				{ImageId: aws.String("ami-000")},
+			},
+			giveLaunched: map[string]ec2.DescribeImageAttributeOutput{
+				"ami-123": {LastLaunchedTime: &types.AttributeValue{Value: aws.String("2021-01-09T12:00:00Z")}},
+				"ami-456": {LastLaunchedTime: &types.AttributeValue{Value: aws.String("2021-01-01T12:00:00Z")}},
+			},
+			wantAMIs: []types.Image{
+				{ImageId: aws.String("ami-456")},
+				{ImageId: aws.String("ami-789")},
+			},
+			wantErr: nil,
+		},
 	}
 
 	for _, tt := range tests {
@@ -356,7 +605,11 @@ func TestFilterAMIs(t *testing.T) {
 		t.Run(tt.name, func(t *testing.T) {
 			t.Parallel()
 
-			aws := AWS{}
+			aws := AWS{
+				cfg:   tt.giveCfg,
+				ec2:   &mockEC2{RespDescImageAttribute: tt.giveLaunched},
+				nowFn: func() time.Time { return now },
+			}
 
 			filtered, err := aws.FilterAMIs(tt.giveAMIs, tt.giveEC2s)
 
@@ -571,6 +824,20 @@ func TestDeleteAMIs(t *testing.T) {
 	}
 }
 
+func TestDeleteAMIsCanceled(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	cancel()
+
+	a := AWS{cfg: &Config{Context: ctx}, ec2: &mockEC2{}}
+	assert.Nil(t, a.mutationCtx().Err())
+
+	ids, err := a.DeleteAMIs([]types.Image{{ImageId: aws.String("ami-123")}})
+	assert.Empty(t, ids)
+	assert.True(t, errors.Is(err, context.Canceled), fmt.Sprintf("expected: %s\ngot: %s", context.Canceled, err))
+}
+
 func TestDeleteUnusedAMIs(t *testing.T) {
 	t.Parallel()
 
@@ -0,0 +1,125 @@
+package cami
+
+import (
+	"context"
+	"fmt"
+	"regexp"
+	"strings"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+)
+
+// amiIDRe matches AMI IDs in the old 8 and new 17 character formats.
+var amiIDRe = regexp.MustCompile(`\bami-(?:[0-9a-f]{17}|[0-9a-f]{8})\b`) //nolint:gochecknoglobals
+
+// Usage is a reference to an AMI from outside of EC2 that keeps it from being acted on.
+type Usage struct {
+	// ImageID is the ID of the referenced AMI
+	ImageID string
+	// Reason is what references the AMI, such as a Terraform resource or SSM parameter
+	Reason string
+}
+
+// Detector finds AMIs that are in use by something other than an EC2 instance, such as
+// an infrastructure as code tool or a service that launches instances later.
+type Detector interface {
+	// Name identifies the detector in logs and errors
+	Name() string
+	// Detect returns every reference to an AMI the detector can find. Returning an
+	// error fails the run, since AMIs it would have protected could be deleted.
+	Detect(ctx context.Context) ([]Usage, error)
+}
+
+// findAMIIDs returns every AMI ID in s.
+func findAMIIDs(s string) []string {
+	if !strings.Contains(s, "ami-") {
+		return nil
+	}
+	return amiIDRe.FindAllString(s, -1)
+}
+
+// detectors returns the configured detectors and those built from the other usage
+// source fields, using the provided AWS config.
+func (c *Config) detectors(cfg aws.Config) []Detector {
+	if c == nil {
+		return nil
+	}
+
+	ds := append([]Detector{}, c.Detectors...)
+	if len(c.TerraformStatePaths) > 0 {
+		ds = append(ds, &TerraformStateDetector{Paths: c.TerraformStatePaths})
+	}
+	if len(c.SSMParameterPaths) > 0 {
+		ds = append(ds, NewSSMParameterDetector(cfg, c.SSMParameterPaths...))
+	}
+	if c.CloudFormation || c.CloudFormationTemplates {
+		ds = append(ds, NewCloudFormationDetector(cfg, c.CloudFormationTemplates))
+	}
+	if c.EKS {
+		ds = append(ds, NewEKSDetector(cfg))
+	}
+	if c.imageBuilder() == ImageBuilderProtect {
+		ds = append(ds, NewImageBuilderDetector(cfg))
+	}
+
+	return ds
+}
+
+// detectUsage runs every detector and returns why each referenced AMI is in use, keyed
+// by AMI ID. Reasons from several references are joined with "; ".
+func (a *AWS) detectUsage() (map[string]string, error) {
+	output := make(map[string]string)
+
+	for _, d := range a.detectors {
+		var usages []Usage
+		err := a.call("Detect:"+d.Name(), func() error {
+			var err error
+			usages, err = d.Detect(a.ctx())
+			return err
+		})
+		if err != nil {
+			return output, fmt.Errorf("%w: %s: %w", ErrDetectUsage, d.Name(), err)
+		}
+		a.cfg.logger().Debug("detected usage", "detector", d.Name(), "references", len(usages))
+
+		for _, u := range usages {
+			if reason, ok := output[u.ImageID]; ok {
+				output[u.ImageID] = reason + "; " + u.Reason
+			} else {
+				output[u.ImageID] = u.Reason
+			}
+		}
+	}
+
+	return output, nil
+}
+
+// filterUsed returns the AMIs that no detector found a reference to, and records why
+// the others are kept in a.protected.
+func (a *AWS) filterUsed(amis []types.Image) ([]types.Image, error) {
+	a.protected = nil
+	if len(a.detectors) == 0 {
+		return amis, nil
+	}
+
+	used, err := a.detectUsage()
+	if err != nil {
+		return nil, err
+	}
+
+	var output []types.Image
+	a.protected = make(map[string]string)
+	for _, ami := range amis {
+		reason, ok := used[*ami.ImageId]
+		if !ok {
+			output = append(output, ami)
+			continue
+		}
+
+		a.protected[*ami.ImageId] = reason
+		a.cfg.logger().Info("protected", "image_id", *ami.ImageId, "reason", reason)
+	}
+
+	return output, nil
+}
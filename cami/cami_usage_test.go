@@ -0,0 +1,167 @@
+package cami
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/prometheus/client_golang/prometheus/testutil"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestFindAMIIDs(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name string
+		give string
+		want []string
+	}{
+		{name: "empty", give: "", want: nil},
+		{name: "short", give: "ami-12345678", want: []string{"ami-12345678"}},
+		{name: "long", give: "ami-0123456789abcdef0", want: []string{"ami-0123456789abcdef0"}},
+		{name: "embedded", give: `{"base":"ami-12345678","gpu":"ami-0123456789abcdef0"}`, want: []string{"ami-12345678", "ami-0123456789abcdef0"}},
+		{name: "too short", give: "ami-1234567", want: nil},
+		{name: "wrong length", give: "ami-0123456789", want: nil},
+		{name: "uppercase", give: "ami-ABCDEF12", want: nil},
+		{name: "other resource", give: "snap-12345678", want: nil},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, findAMIIDs(tt.give))
+		})
+	}
+}
+
+func TestFilterAMIsDetectors(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{ImageId: aws.String("ami-123")},
+		{ImageId: aws.String("ami-456")},
+		{ImageId: aws.String("ami-789")},
+	}
+
+	tests := []struct {
+		name          string
+		giveDetectors []Detector
+		wantAMIs      []types.Image
+		wantProtected map[string]string
+		wantErr       error
+	}{
+		{
+			name:          "no detectors",
+			giveDetectors: nil,
+			wantAMIs:      amis,
+			wantProtected: nil,
+		},
+		{
+			name: "protected",
+			giveDetectors: []Detector{
+				&mockDetector{RespDetect: []Usage{
+					{ImageID: "ami-123", Reason: "first"},
+					{ImageID: "ami-999", Reason: "not ours"},
+				}},
+				&mockDetector{RespDetect: []Usage{{ImageID: "ami-123", Reason: "second"}}},
+				&mockDetector{RespDetect: []Usage{{ImageID: "ami-789", Reason: "third"}}},
+			},
+			wantAMIs:      amis[1:2],
+			wantProtected: map[string]string{"ami-123": "first; second", "ami-789": "third"},
+		},
+		{
+			name: "error",
+			giveDetectors: []Detector{
+				&mockDetector{RespDetect: []Usage{{ImageID: "ami-123", Reason: "first"}}},
+				&mockDetector{RespDetectErr: fmt.Errorf("FAIL")},
+			},
+			wantAMIs: nil,
+			wantErr:  ErrDetectUsage,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			a := AWS{detectors: tt.giveDetectors}
+			got, err := a.FilterAMIs(amis, nil)
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+
+			assert.Equal(t, tt.wantAMIs, got)
+			assert.Equal(t, tt.wantProtected, a.protected)
+		})
+	}
+}
+
+func TestDetectUsageAPICalls(t *testing.T) {
+	t.Parallel()
+
+	m := NewMetrics()
+	a := AWS{
+		cfg: &Config{Metrics: m},
+		detectors: []Detector{
+			&mockDetector{RespDetect: []Usage{{ImageID: "ami-123", Reason: "first"}}},
+			&mockDetector{RespDetectErr: fmt.Errorf("FAIL")},
+		},
+	}
+
+	_, err := a.detectUsage()
+	assert.True(t, errors.Is(err, ErrDetectUsage))
+	assert.Equal(t, 2.0, testutil.ToFloat64(m.apiCalls.WithLabelValues("Detect:mock")))
+	assert.Equal(t, 1.0, testutil.ToFloat64(m.apiErrors.WithLabelValues("Detect:mock")))
+}
+
+func TestPlanProtected(t *testing.T) {
+	t.Parallel()
+
+	a := AWS{
+		ec2: &mockEC2{RespDescImages: ec2.DescribeImagesOutput{Images: []types.Image{
+			{ImageId: aws.String("ami-123")},
+			{ImageId: aws.String("ami-456")},
+		}}},
+		detectors: []Detector{&mockDetector{RespDetect: []Usage{{ImageID: "ami-123", Reason: "pinned"}}}},
+	}
+
+	plan, err := a.Plan()
+	assert.Nil(t, err)
+	assert.Equal(t, []types.Image{{ImageId: aws.String("ami-456")}}, plan.Candidates)
+	assert.Equal(t, map[string]string{"ami-123": "pinned"}, plan.Protected)
+}
+
+func TestConfigDetectors(t *testing.T) {
+	t.Parallel()
+
+	var c *Config
+	assert.Nil(t, c.detectors(aws.Config{}))
+
+	d := &mockDetector{}
+	c = &Config{
+		Detectors:           []Detector{d},
+		TerraformStatePaths: []string{"terraform.tfstate"},
+		SSMParameterPaths:   []string{"/ami"},
+		CloudFormation:      true,
+		EKS:                 true,
+	}
+	ds := c.detectors(aws.Config{})
+	assert.Len(t, ds, 5)
+	assert.Equal(t, d, ds[0])
+	assert.Equal(t, &TerraformStateDetector{Paths: []string{"terraform.tfstate"}}, ds[1])
+	assert.IsType(t, &SSMParameterDetector{}, ds[2])
+	assert.Equal(t, []string{"/ami"}, ds[2].(*SSMParameterDetector).Paths)
+	assert.IsType(t, &CloudFormationDetector{}, ds[3])
+	assert.False(t, ds[3].(*CloudFormationDetector).Templates)
+	assert.IsType(t, &EKSDetector{}, ds[4])
+}
@@ -4,67 +4,122 @@ package cmd
 import (
 	"errors"
 	"fmt"
-	"log"
+	"os"
 	"strings"
+	"time"
 
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
 	"github.com/lingrino/cami/cami"
 	"github.com/spf13/cobra"
 )
 
-const (
-	flagDryRunDesc = "Set dryrun to true to run through the deletion without deleting any AMIs."
-)
-
 // camiCmd returns our root cami command.
-func camiCmd() *cobra.Command {
-	// dryrun determines if cami should test deletion but not actually delete the AMIs
-	var dryrun bool
+func camiCmd(o *options) *cobra.Command {
+	// yes skips the confirmation prompt
+	var yes bool
 
 	cmd := &cobra.Command{
 		Use:   "cami",
 		Short: "cami is an API and CLI for removing unused AMIs from your AWS account.",
 		Args:  cobra.ExactArgs(0),
+		PersistentPreRunE: func(cmd *cobra.Command, args []string) error {
+			err := o.setupLogger()
+			if err != nil {
+				return err
+			}
+			o.notifier()
+			return nil
+		},
 		Run: func(cmd *cobra.Command, args []string) {
-			var err error
+			start := time.Now()
+			cfg := o.config()
+			aws := newAWS(cfg)
 
-			aws, err := cami.NewAWS(&cami.Config{DryRun: dryrun})
+			plan, err := aws.Plan()
 			if err != nil {
-				log.Fatalf("ERROR: %v\n", err)
+				o.finishRun(aws.Region(), start, plan, nil, err)
+				fatal("unknown error", "error", err)
+			}
+			printProtected(plan.Protected)
+			switch {
+			case plan.Storage == nil:
+				printIDs("Unused AMIs", imageIDs(plan.Candidates))
+			case len(cfg.OwnerTagKeys) > 0:
+				printOwners("Unused AMIs", plan)
+			default:
+				printStorage("Unused AMIs", plan.Storage)
 			}
 
-			err = aws.Auth()
+			err = aws.CheckLimits(plan)
 			if err != nil {
-				log.Fatalf("ERROR: %v\n", err)
+				o.finishRun(aws.Region(), start, plan, nil, err)
+				fatal("safety limit exceeded, use --override-limits to run anyway", "error", err)
+			}
+
+			if len(plan.Candidates) > 0 && !cfg.DryRun && !yes && interactive() {
+				plan.Candidates = confirm(os.Stdin, os.Stdout, plan, o.action)
+				if len(plan.Candidates) == 0 {
+					fmt.Println("aborted")
+					o.finishRun(aws.Region(), start, plan, nil, nil)
+					return
+				}
 			}
 
-			deleted, err := aws.DeleteUnusedAMIs()
-			if len(deleted) == 0 && err == nil {
+			result, err := aws.Apply(plan)
+			o.finishRun(aws.Region(), start, plan, result, err)
+			if len(result.IDs) == 0 && err == nil {
 				fmt.Println("nothing to delete")
 			}
+			printIDs("Successfully deleted", result.IDs)
+			printIDs("Deleted Image Builder images", result.ImageBuilderImages)
 
 			var eda *cami.ErrDeleteAMIs
 			if err != nil {
 				if errors.As(err, &eda) {
-					log.Fatalf("Failed to delete:\n  %s\n", strings.Join(eda.IDs, "\n  "))
+					fatal("failed to delete", "ids", eda.IDs)
 				} else {
-					log.Fatalf("UNKNOWN ERROR: %v\n", err)
+					fatal("unknown error", "error", err)
 				}
 			}
-			if len(deleted) > 0 {
-				fmt.Printf("Successfully deleted:\n  %s\n", strings.Join(deleted, "\n  "))
-			}
+			printReclaimed(result)
 		},
 	}
 
-	cmd.Flags().BoolVarP(&dryrun, "dryrun", "d", false, flagDryRunDesc)
+	o.addFlags(cmd.PersistentFlags())
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, flagYesDesc)
 
 	return cmd
 }
 
+// printIDs prints a titled list of IDs, or nothing if there are no IDs.
+func printIDs(title string, ids []string) {
+	if len(ids) == 0 {
+		return
+	}
+	fmt.Printf("%s:\n  %s\n", title, strings.Join(ids, "\n  "))
+}
+
+// imageIDs returns the ID of every image in amis.
+func imageIDs(amis []types.Image) []string {
+	output := make([]string, 0, len(amis))
+	for _, ami := range amis {
+		output = append(output, aws.ToString(ami.ImageId))
+	}
+	return output
+}
+
 // Execute calls the command returned by camiCmd and sets the version flag passed from main.go.
 func Execute(v string) error {
-	cami := camiCmd()
+	o := &options{}
+
+	cami := camiCmd(o)
 	cami.AddCommand(versionCmd(v))
+	cami.AddCommand(sweepCmd(o))
+	cami.AddCommand(restoreCmd(o))
+	cami.AddCommand(reregisterCmd(o))
+	cami.AddCommand(serveCmd(o))
+	cami.AddCommand(serverCmd(o))
 
 	err := cami.Execute()
 	if err != nil {
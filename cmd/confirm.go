@@ -0,0 +1,151 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"strconv"
+	"strings"
+	"text/tabwriter"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"golang.org/x/term"
+)
+
+const (
+	flagYesDesc = "Do not ask for confirmation before acting on unused AMIs."
+
+	// hoursPerDay is used to format image ages in days.
+	hoursPerDay = 24
+)
+
+// interactive returns true if cami can prompt the user for input.
+func interactive() bool {
+	return term.IsTerminal(int(os.Stdin.Fd())) && term.IsTerminal(int(os.Stdout.Fd()))
+}
+
+// confirm shows the candidates in the plan and asks the user which of them to act on.
+// Candidates can be toggled on and off before confirming. Returns the selected
+// candidates, or nil if the user aborts.
+func confirm(in io.Reader, out io.Writer, plan *cami.Plan, action string) []types.Image {
+	selected := make([]bool, len(plan.Candidates))
+	for i := range selected {
+		selected[i] = true
+	}
+
+	sizes := make(map[string]int64)
+	if plan.Storage != nil {
+		for _, is := range plan.Storage.Images {
+			sizes[is.ImageID] = is.Bytes
+		}
+	}
+
+	r := bufio.NewReader(in)
+	for {
+		printCandidates(out, plan, selected, sizes)
+
+		count := 0
+		for _, s := range selected {
+			if s {
+				count++
+			}
+		}
+
+		fmt.Fprintf(out, "Run %s on %d AMIs? [y]es, [n]o, [t]oggle: ", action, count)
+		answer, err := r.ReadString('\n')
+		if err != nil && answer == "" {
+			return nil
+		}
+
+		switch strings.ToLower(strings.TrimSpace(answer)) {
+		case "y", "yes":
+			var output []types.Image
+			for i, ami := range plan.Candidates {
+				if selected[i] {
+					output = append(output, ami)
+				}
+			}
+			return output
+		case "t", "toggle":
+			fmt.Fprint(out, "AMI numbers to toggle (e.g. 1,3-5): ")
+			answer, _ = r.ReadString('\n')
+			for _, i := range parseSelection(answer, len(selected)) {
+				selected[i] = !selected[i]
+			}
+		default:
+			return nil
+		}
+	}
+}
+
+// printCandidates prints a numbered table of the candidates in the plan.
+func printCandidates(out io.Writer, plan *cami.Plan, selected []bool, sizes map[string]int64) {
+	w := tabwriter.NewWriter(out, 0, 0, 2, ' ', 0) //nolint:mnd
+	fmt.Fprintln(w, "\t#\tID\tNAME\tAGE\tSIZE\tREASON")
+	for i, ami := range plan.Candidates {
+		mark := "[ ]"
+		if selected[i] {
+			mark = "[x]"
+		}
+
+		id := *ami.ImageId
+		name := ""
+		if ami.Name != nil {
+			name = *ami.Name
+		}
+
+		fmt.Fprintf(w, "%s\t%d\t%s\t%s\t%s\t%s\t%s\n",
+			mark, i+1, id, name, age(ami), formatBytes(sizes[id]), plan.Reasons[id])
+	}
+	w.Flush()
+}
+
+// age returns how long ago an AMI was created, in days.
+func age(ami types.Image) string {
+	if ami.CreationDate == nil {
+		return "-"
+	}
+
+	created, err := time.Parse(time.RFC3339, *ami.CreationDate)
+	if err != nil {
+		return "-"
+	}
+
+	return fmt.Sprintf("%dd", int(time.Since(created).Hours()/hoursPerDay))
+}
+
+// parseSelection parses a list of 1-based numbers and ranges like "1,3-5" into 0-based
+// indexes less than n, ignoring anything invalid.
+func parseSelection(s string, n int) []int {
+	var output []int
+
+	for _, part := range strings.Split(s, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+
+		lo, hi := part, part
+		if i := strings.Index(part, "-"); i >= 0 {
+			lo, hi = part[:i], part[i+1:]
+		}
+
+		start, err := strconv.Atoi(strings.TrimSpace(lo))
+		if err != nil {
+			continue
+		}
+		end, err := strconv.Atoi(strings.TrimSpace(hi))
+		if err != nil {
+			continue
+		}
+
+		for i := max(start, 1); i <= min(end, n); i++ {
+			output = append(output, i-1)
+		}
+	}
+
+	return output
+}
@@ -0,0 +1,129 @@
+package cmd
+
+import (
+	"bytes"
+	"strings"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestParseSelection(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name  string
+		giveS string
+		giveN int
+		want  []int
+	}{
+		{name: "empty", giveS: "", giveN: 5, want: nil},
+		{name: "single", giveS: "2", giveN: 5, want: []int{1}},
+		{name: "list", giveS: "1, 3,5\n", giveN: 5, want: []int{0, 2, 4}},
+		{name: "range", giveS: "2-4", giveN: 5, want: []int{1, 2, 3}},
+		{name: "range with spaces", giveS: "2 - 3", giveN: 5, want: []int{1, 2}},
+		{name: "mixed", giveS: "1,3-4", giveN: 5, want: []int{0, 2, 3}},
+		{name: "out of bounds", giveS: "0,4-7", giveN: 5, want: []int{3, 4}},
+		{name: "huge range", giveS: "1-999999999", giveN: 3, want: []int{0, 1, 2}},
+		{name: "reversed range", giveS: "4-2", giveN: 5, want: nil},
+		{name: "invalid", giveS: "a,1-b,2", giveN: 5, want: []int{1}},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			assert.Equal(t, tt.want, parseSelection(tt.giveS, tt.giveN))
+		})
+	}
+}
+
+func TestConfirm(t *testing.T) {
+	t.Parallel()
+
+	amis := []types.Image{
+		{ImageId: aws.String("ami-1"), Name: aws.String("one")},
+		{ImageId: aws.String("ami-2")},
+		{ImageId: aws.String("ami-3")},
+	}
+
+	tests := []struct {
+		name      string
+		giveInput string
+		want      []types.Image
+		wantOut   string
+	}{
+		{
+			name:      "yes",
+			giveInput: "y\n",
+			want:      amis,
+			wantOut:   "Run deregister on 3 AMIs?",
+		},
+		{
+			name:      "yes without newline",
+			giveInput: "YES",
+			want:      amis,
+		},
+		{
+			name:      "no",
+			giveInput: "n\n",
+			want:      nil,
+		},
+		{
+			name:      "anything else",
+			giveInput: "maybe\n",
+			want:      nil,
+		},
+		{
+			name:      "eof",
+			giveInput: "",
+			want:      nil,
+		},
+		{
+			name:      "toggle",
+			giveInput: "t\n1,3\ny\n",
+			want:      amis[1:2],
+			wantOut:   "Run deregister on 1 AMIs?",
+		},
+		{
+			name:      "toggle twice",
+			giveInput: "t\n1-3\nt\n3\ny\n",
+			want:      amis[2:],
+		},
+		{
+			name:      "all deselected",
+			giveInput: "toggle\n1-3\ny\n",
+			want:      nil,
+			wantOut:   "Run deregister on 0 AMIs?",
+		},
+		{
+			name:      "eof after toggle",
+			giveInput: "t\n2",
+			want:      nil,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			plan := &cami.Plan{
+				Candidates: amis,
+				Reasons:    map[string]string{"ami-1": "no instances"},
+			}
+
+			var out bytes.Buffer
+			got := confirm(strings.NewReader(tt.giveInput), &out, plan, "deregister")
+
+			assert.Equal(t, tt.want, got)
+			assert.Contains(t, out.String(), "ami-1")
+			assert.Contains(t, out.String(), "no instances")
+			assert.Contains(t, out.String(), tt.wantOut)
+		})
+	}
+}
@@ -0,0 +1,126 @@
+package cmd
+
+import (
+	"context"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/lingrino/cami/cami"
+	"github.com/lingrino/cami/notify"
+)
+
+// notifyTimeout is how long sending notifications may take, including retries.
+const notifyTimeout = time.Minute
+
+// notifier returns the notifier described by the notify flags, or nil if no webhooks
+// are configured. Exits if the flags are invalid.
+func (o *options) notifier() *notify.Notifier {
+	if len(o.notifyWebhooks)+len(o.notifySlackWebhooks)+len(o.notifyOwnerWebhooks)+len(o.notifyOwnerSlackWebhooks) == 0 {
+		return nil
+	}
+	if o.notify != nil {
+		return o.notify
+	}
+
+	var tmpl string
+	if o.notifyTemplate != "" {
+		b, err := os.ReadFile(o.notifyTemplate)
+		if err != nil {
+			fatal("read notify template", "error", err)
+		}
+		tmpl = string(b)
+	}
+
+	n := &notify.Notifier{On: notify.On(o.notifyOn)}
+	for _, url := range o.notifyWebhooks {
+		n.Webhooks = append(n.Webhooks, notify.Webhook{URL: url, Format: notify.FormatJSON})
+	}
+	for _, url := range o.notifySlackWebhooks {
+		n.Webhooks = append(n.Webhooks, notify.Webhook{URL: url, Format: notify.FormatSlack, Template: tmpl})
+	}
+	n.Routes = make(map[string][]notify.Webhook)
+	addRoutes(n.Routes, o.notifyOwnerWebhooks, notify.Webhook{Format: notify.FormatJSON})
+	addRoutes(n.Routes, o.notifyOwnerSlackWebhooks, notify.Webhook{Format: notify.FormatSlack, Template: tmpl})
+
+	err := n.Validate()
+	if err != nil {
+		fatal("invalid notification settings", "error", err)
+	}
+
+	o.notify = n
+	return n
+}
+
+// addRoutes adds a copy of wh for each owner=url pair to routes. Exits if a pair is
+// invalid.
+func addRoutes(routes map[string][]notify.Webhook, pairs []string, wh notify.Webhook) {
+	for _, pair := range pairs {
+		owner, url, ok := strings.Cut(pair, "=")
+		if !ok || owner == "" || url == "" {
+			fatal("invalid owner webhook, expected owner=url", "webhook", pair)
+		}
+		wh.URL = url
+		routes[owner] = append(routes[owner], wh)
+	}
+}
+
+// sendNotification sends s to the configured webhooks, logging any failure.
+func (o *options) sendNotification(s notify.Summary) {
+	ctx, cancel := context.WithTimeout(context.Background(), notifyTimeout)
+	defer cancel()
+
+	err := o.notifier().Notify(ctx, s)
+	if err != nil {
+		logger.Warn("failed to send notification", "error", err)
+	}
+}
+
+// runSummary returns the summary of a run in region that started at start.
+func (o *options) runSummary(region string, start time.Time, plan *cami.Plan, result *cami.Result, err error) notify.Summary {
+	s := notify.Summary{
+		Started:  start.UTC(),
+		Finished: time.Now().UTC(),
+		Region:   region,
+		DryRun:   o.dryrun,
+		Action:   o.action,
+	}
+	if plan != nil {
+		s.Candidates = len(plan.Candidates)
+	}
+	outcome := cami.NewOutcome(result, err)
+	s.RunID = outcome.RunID
+	s.IDs = outcome.IDs
+	s.Failed = outcome.Failed
+	s.ReclaimedBytes = outcome.ReclaimedBytes
+	s.MonthlySavings = outcome.MonthlySavings
+	s.Error = outcome.Error
+	if plan != nil && len(o.ownerTagKeys) > 0 {
+		s.Owners = ownerSummaries(plan, result, s.Failed)
+	}
+
+	return s
+}
+
+// ownerSummaries splits a run by the owner of each candidate in plan.
+func ownerSummaries(plan *cami.Plan, result *cami.Result, failed []string) []notify.OwnerSummary {
+	groups := plan.ByOwner()
+	output := make([]notify.OwnerSummary, 0, len(groups))
+	for _, g := range groups {
+		summary := notify.OwnerSummary{
+			Owner:      g.Owner,
+			Candidates: len(g.Candidates),
+			Failed:     g.Filter(failed),
+		}
+		if result != nil && result.Owners[g.Owner] != nil {
+			owned := result.Owners[g.Owner]
+			summary.IDs = owned.IDs
+			summary.MonthlySavings = owned.MonthlySavings
+			if owned.Reclaimed != nil {
+				summary.ReclaimedBytes = owned.Reclaimed.Bytes
+			}
+		}
+		output = append(output, summary)
+	}
+	return output
+}
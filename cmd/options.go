@@ -0,0 +1,312 @@
+package cmd
+
+import (
+	"encoding/json"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"github.com/lingrino/cami/notify"
+	"github.com/spf13/pflag"
+)
+
+const (
+	flagDryRunDesc         = "Set dryrun to true to run through the deletion without deleting any AMIs."
+	flagInstanceStatesDesc = "Instance states that count as using an AMI. Defaults to all states except shutting-down and terminated."
+	flagStoppedMaxAgeDesc  = "Only count stopped instances as using an AMI if they were stopped less than this long ago (e.g. 720h)."
+	flagLaunchedWithinDesc = "Do not delete AMIs that were used to launch an instance less than this long ago (e.g. 2160h)."
+	flagActionDesc         = "What to do with unused AMIs, one of: "
+	flagDeprecateAfterDesc = "With --action deprecate, how long from now the AMIs are deprecated (minimum 1m)."
+	flagRecycleBinDesc     = "Check for Recycle Bin rules covering AMIs and snapshots before deleting, one of: off, warn, require."
+	flagCreateRuleDesc     = "Create a cami managed Recycle Bin rule for AMIs and snapshots if none exists."
+	flagRetentionDaysDesc  = "How many days the cami managed Recycle Bin rule retains deleted AMIs and snapshots."
+	flagArchiveFileDesc    = "Append the full description of every AMI to this file before deregistering it."
+	flagAuditLogDesc       = "Append a JSON record of every AWS API call that changes a resource to this file."
+	flagTerraformStateDesc = "Keep AMIs referenced in this local Terraform state file (format version 4). Can be repeated."
+	flagSSMPathDesc        = "Keep AMIs whose IDs are in the SSM parameters under this path (e.g. /ami). Can be repeated."
+	flagCloudFormationDesc = "Keep AMIs referenced by the parameters of active CloudFormation stacks."
+	flagCFNTemplatesDesc   = "Also keep AMIs referenced in the processed templates of active CloudFormation stacks."
+	flagEKSDesc            = "Keep AMIs in the launch templates of EKS managed node groups and Karpenter EC2NodeClasses."
+	flagImageBuilderDesc   = "How to handle AMIs produced by EC2 Image Builder images, one of: off, protect, delete."
+	flagSNSTopicDesc       = "Publish a JSON event to this SNS topic ARN for every AMI that is deregistered."
+	flagEventBusDesc       = "Put an event on this EventBridge bus for every AMI that is deregistered."
+	flagMaxImagesDesc      = "Abort before acting on anything if a run would act on more than this many AMIs."
+	flagMaxPercentDesc     = "Abort before acting on anything if a run would act on more than this percentage of owned AMIs."
+	flagOverrideLimitsDesc = "Ignore --max-images and --max-percent."
+	flagMetricsFileDesc    = "Write Prometheus metrics about the run to this file, e.g. for the node_exporter textfile collector."
+	flagResultFileDesc     = "Write the IDs acted on and failed in the run to this file as JSON, e.g. for restore --from-file."
+	flagNotifyWebhookDesc  = "POST a JSON summary of each run to this URL. Can be repeated."
+	flagNotifySlackDesc    = "POST a Slack compatible message about each run to this URL. Can be repeated."
+	flagOwnerWebhookDesc   = "POST a JSON summary of the candidates of one owner to a URL, as owner=url. Can be repeated."
+	flagOwnerSlackDesc     = "POST a Slack compatible message about the candidates of one owner to a URL, as owner=url. Can be repeated."
+	flagOwnerTagKeysDesc   = "Tag keys that name the owner of an AMI, checked in order (e.g. owner,team). Candidates are reported per owner."
+	flagNotifyTmplDesc     = "File with a Go text/template for Slack compatible messages, rendered with the run summary."
+	flagNotifyOnDesc       = "When to send notifications, one of: failure, always, changes."
+	flagLogLevelDesc       = "Minimum level of logs written to stderr, one of: debug, info, warn, error."
+	flagLogFormatDesc      = "Format of logs written to stderr, one of: text, json."
+	flagPricesDesc         = "Snapshot storage prices in USD per GB-month used for cost estimates, as region/tier=price " +
+		"or tier=price for all regions (e.g. standard=0.05,eu-west-1/archive=0.0135)."
+)
+
+// options holds the flags shared by all cami commands.
+type options struct {
+	// dryrun determines if cami should test deletion but not actually delete the AMIs
+	dryrun bool
+	// instanceStates are the instance states that count as using an AMI
+	instanceStates []string
+	// ownerTagKeys are the tag keys that name the owner of an AMI
+	ownerTagKeys []string
+	// terraformStates are Terraform state files whose AMIs are kept
+	terraformStates []string
+	// ssmPaths are SSM parameter paths whose AMIs are kept
+	ssmPaths []string
+	// cloudFormation and cloudFormationTemplates keep AMIs referenced by stacks
+	cloudFormation          bool
+	cloudFormationTemplates bool
+	// eks keeps AMIs that EKS node groups and Karpenter can launch
+	eks bool
+	// imageBuilder is how AMIs produced by EC2 Image Builder are handled
+	imageBuilder string
+	// stoppedMaxAge is how long an instance can be stopped and still count as using an AMI
+	stoppedMaxAge time.Duration
+	// launchedWithin protects AMIs that were launched more recently than this
+	launchedWithin time.Duration
+	// action is what cami does with unused AMIs
+	action string
+	// deprecateAfter is how long from now deprecated AMIs are deprecated
+	deprecateAfter time.Duration
+	// recycleBin is the Recycle Bin preflight mode
+	recycleBin string
+	// createRecycleBinRule creates a Recycle Bin rule if none exists
+	createRecycleBinRule bool
+	// recycleBinRetentionDays is how long the cami managed Recycle Bin rule retains resources
+	recycleBinRetentionDays int32
+	// archiveFile is where AMI descriptions are saved before deregistering
+	archiveFile string
+	// auditLog is where a record of every mutating API call is written
+	auditLog string
+	// snsTopicARN and eventBus receive an event for every deregistered AMI
+	snsTopicARN string
+	eventBus    string
+	// prices are the snapshot storage prices used for cost estimates
+	prices map[string]string
+	// maxImages is the maximum number of AMIs a run may act on
+	maxImages int
+	// maxPercent is the maximum percentage of owned AMIs a run may act on
+	maxPercent float64
+	// overrideLimits ignores maxImages and maxPercent
+	overrideLimits bool
+	// metricsFile is where Prometheus metrics are written after a run
+	metricsFile string
+	// resultFile is where the outcome of a run is written as JSON
+	resultFile string
+	// metrics records metrics if metricsFile is set
+	metrics *cami.Metrics
+	// notifyWebhooks are URLs JSON run summaries are posted to
+	notifyWebhooks []string
+	// notifySlackWebhooks are URLs Slack compatible messages are posted to
+	notifySlackWebhooks []string
+	// notifyOwnerWebhooks and notifyOwnerSlackWebhooks are owner=url pairs that only
+	// receive the part of a run that covers the owner
+	notifyOwnerWebhooks      []string
+	notifyOwnerSlackWebhooks []string
+	// notifyTemplate is a file with the template for Slack compatible messages
+	notifyTemplate string
+	// notifyOn is when notifications are sent
+	notifyOn string
+	// notify sends notifications if any webhooks are configured
+	notify *notify.Notifier
+	// logLevel is the minimum level of logs written
+	logLevel string
+	// logFormat is the format of logs written, text or json
+	logFormat string
+}
+
+// addFlags registers the shared flags on fs.
+func (o *options) addFlags(fs *pflag.FlagSet) {
+	fs.BoolVarP(&o.dryrun, "dryrun", "d", false, flagDryRunDesc)
+	fs.StringSliceVar(&o.instanceStates, "instance-states", nil, flagInstanceStatesDesc)
+	fs.StringSliceVar(&o.ownerTagKeys, "owner-tag-keys", nil, flagOwnerTagKeysDesc)
+	fs.StringSliceVar(&o.terraformStates, "terraform-state", nil, flagTerraformStateDesc)
+	fs.StringSliceVar(&o.ssmPaths, "ssm-path", nil, flagSSMPathDesc)
+	fs.BoolVar(&o.cloudFormation, "cloudformation", false, flagCloudFormationDesc)
+	fs.BoolVar(&o.cloudFormationTemplates, "cloudformation-templates", false, flagCFNTemplatesDesc)
+	fs.BoolVar(&o.eks, "eks", false, flagEKSDesc)
+	fs.StringVar(&o.imageBuilder, "image-builder", string(cami.ImageBuilderOff), flagImageBuilderDesc)
+	fs.DurationVar(&o.stoppedMaxAge, "stopped-max-age", 0, flagStoppedMaxAgeDesc)
+	fs.DurationVar(&o.launchedWithin, "launched-within", 0, flagLaunchedWithinDesc)
+	fs.StringVar(&o.action, "action", string(cami.ActionDeregister), flagActionDesc+cami.ActionNames(", ")+".")
+	fs.DurationVar(&o.deprecateAfter, "deprecate-after", 0, flagDeprecateAfterDesc)
+	fs.StringVar(&o.recycleBin, "recycle-bin", string(cami.RecycleBinOff), flagRecycleBinDesc)
+	fs.BoolVar(&o.createRecycleBinRule, "create-recycle-bin-rule", false, flagCreateRuleDesc)
+	fs.Int32Var(&o.recycleBinRetentionDays, "recycle-bin-retention-days", cami.DefaultRecycleBinRetentionDays, flagRetentionDaysDesc)
+	fs.StringVar(&o.archiveFile, "archive-file", "", flagArchiveFileDesc)
+	fs.StringVar(&o.auditLog, "audit-log", "", flagAuditLogDesc)
+	fs.StringVar(&o.snsTopicARN, "sns-topic-arn", "", flagSNSTopicDesc)
+	fs.StringVar(&o.eventBus, "event-bus", "", flagEventBusDesc)
+	fs.StringToStringVar(&o.prices, "prices", nil, flagPricesDesc)
+	fs.IntVar(&o.maxImages, "max-images", 0, flagMaxImagesDesc)
+	fs.Float64Var(&o.maxPercent, "max-percent", 0, flagMaxPercentDesc)
+	fs.BoolVar(&o.overrideLimits, "override-limits", false, flagOverrideLimitsDesc)
+	fs.StringVar(&o.metricsFile, "metrics-file", "", flagMetricsFileDesc)
+	fs.StringVar(&o.resultFile, "result-file", "", flagResultFileDesc)
+	fs.StringSliceVar(&o.notifyWebhooks, "notify-webhook", nil, flagNotifyWebhookDesc)
+	fs.StringSliceVar(&o.notifySlackWebhooks, "notify-slack-webhook", nil, flagNotifySlackDesc)
+	fs.StringSliceVar(&o.notifyOwnerWebhooks, "notify-owner-webhook", nil, flagOwnerWebhookDesc)
+	fs.StringSliceVar(&o.notifyOwnerSlackWebhooks, "notify-owner-slack-webhook", nil, flagOwnerSlackDesc)
+	fs.StringVar(&o.notifyTemplate, "notify-template", "", flagNotifyTmplDesc)
+	fs.StringVar(&o.notifyOn, "notify-on", string(notify.OnFailure), flagNotifyOnDesc)
+	fs.StringVar(&o.logLevel, "log-level", "warn", flagLogLevelDesc)
+	fs.StringVar(&o.logFormat, "log-format", logFormatText, flagLogFormatDesc)
+}
+
+// setupLogger replaces the package logger with one described by the log flags.
+func (o *options) setupLogger() error {
+	l, err := newLogger(os.Stderr, o.logLevel, o.logFormat)
+	if err != nil {
+		return err
+	}
+
+	logger = l
+	return nil
+}
+
+// config returns the cami config described by the flags.
+func (o *options) config() *cami.Config {
+	states := make([]types.InstanceStateName, 0, len(o.instanceStates))
+	for _, state := range o.instanceStates {
+		states = append(states, types.InstanceStateName(state))
+	}
+
+	return &cami.Config{
+		DryRun:         o.dryrun,
+		InstanceStates: states,
+		StoppedMaxAge:  o.stoppedMaxAge,
+		LaunchedWithin: o.launchedWithin,
+		Action:         cami.Action(o.action),
+		DeprecateAfter: o.deprecateAfter,
+		OwnerTagKeys:   o.ownerTagKeys,
+
+		TerraformStatePaths: o.terraformStates,
+		SSMParameterPaths:   o.ssmPaths,
+
+		CloudFormation:          o.cloudFormation,
+		CloudFormationTemplates: o.cloudFormationTemplates,
+		EKS:                     o.eks,
+		ImageBuilder:            cami.ImageBuilderMode(o.imageBuilder),
+
+		RecycleBin:              cami.RecycleBinMode(o.recycleBin),
+		CreateRecycleBinRule:    o.createRecycleBinRule,
+		RecycleBinRetentionDays: o.recycleBinRetentionDays,
+
+		MaxImages:      o.maxImages,
+		MaxPercent:     o.maxPercent,
+		OverrideLimits: o.overrideLimits,
+
+		ArchivePath:  o.archiveFile,
+		AuditLogPath: o.auditLog,
+		SNSTopicARN:  o.snsTopicARN,
+		EventBusName: o.eventBus,
+		Prices:       o.priceTable(),
+		Logger:       logger,
+		Metrics:      o.runMetrics(),
+	}
+}
+
+// runMetrics returns the metrics recorded for this run, or nil if --metrics-file is not
+// set and no command created them.
+func (o *options) runMetrics() *cami.Metrics {
+	if o.metrics == nil && o.metricsFile != "" {
+		o.metrics = cami.NewMetrics()
+	}
+	return o.metrics
+}
+
+// writeMetrics writes the metrics to --metrics-file if it is set.
+func (o *options) writeMetrics() {
+	if o.metrics == nil || o.metricsFile == "" {
+		return
+	}
+
+	err := o.metrics.WriteFile(o.metricsFile)
+	if err != nil {
+		logger.Warn("failed to write metrics", "error", err)
+	}
+}
+
+// resultFileMode is the file mode used when writing the result file.
+const resultFileMode = 0o600
+
+// writeResult writes the outcome of a run to the result file, if one is set.
+func (o *options) writeResult(result *cami.Result, runErr error) {
+	if o.resultFile == "" {
+		return
+	}
+
+	b, err := json.MarshalIndent(cami.NewOutcome(result, runErr), "", "  ")
+	if err == nil {
+		err = os.WriteFile(o.resultFile, append(b, '\n'), resultFileMode)
+	}
+	if err != nil {
+		logger.Warn("failed to write result", "error", err)
+	}
+}
+
+// finishRun records a run in region that started at start, writes the metrics and
+// result and sends notifications.
+func (o *options) finishRun(region string, start time.Time, plan *cami.Plan, result *cami.Result, err error) {
+	o.metrics.ObserveRun(time.Since(start), err)
+	o.writeMetrics()
+	o.writeResult(result, err)
+	o.sendNotification(o.runSummary(region, start, plan, result, err))
+}
+
+// priceTable returns the price table described by the prices flag, exiting if it is invalid.
+func (o *options) priceTable() cami.PriceTable {
+	if len(o.prices) == 0 {
+		return nil
+	}
+
+	prices := cami.DefaultPrices()
+	for key, value := range o.prices {
+		region, tier := cami.AllRegions, key
+		if i := strings.Index(key, "/"); i >= 0 {
+			region, tier = key[:i], key[i+1:]
+		}
+
+		price, err := strconv.ParseFloat(value, 64)
+		if err != nil {
+			fatal("invalid price", "key", key, "price", value)
+		}
+
+		if prices[region] == nil {
+			prices[region] = make(map[string]float64)
+		}
+		prices[region][tier] = price
+	}
+
+	return prices
+}
+
+// newAWS returns an authenticated cami AWS client using cfg, exiting on failure.
+func newAWS(cfg *cami.Config) *cami.AWS {
+	err := cfg.Validate()
+	if err != nil {
+		fatal("invalid configuration", "error", err)
+	}
+
+	aws, err := cami.NewAWS(cfg)
+	if err != nil {
+		fatal("create client", "error", err)
+	}
+
+	err = aws.Auth()
+	if err != nil {
+		fatal("authenticate", "error", err)
+	}
+
+	return aws
+}
@@ -0,0 +1,92 @@
+package cmd
+
+import (
+	"fmt"
+	"os"
+	"sort"
+	"text/tabwriter"
+
+	"github.com/lingrino/cami/cami"
+)
+
+// gib is the number of bytes in a GiB.
+const gib = 1 << 30
+
+// printStorage prints the per image and total storage in a storage report.
+func printStorage(title string, report *cami.StorageReport) {
+	if report == nil || len(report.Images) == 0 {
+		return
+	}
+
+	fmt.Printf("%s:\n", title)
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:mnd
+	for _, is := range report.Images {
+		fmt.Fprintf(w, "  %s\t%s\t%s\t%s\n", is.ImageID, is.Name, formatBytes(is.Bytes), formatCost(is.MonthlyCost))
+	}
+	w.Flush()
+	fmt.Printf("Total: %s, estimated %s\n", formatBytes(report.Bytes), formatCost(report.MonthlyCost))
+}
+
+// printProtected prints the AMIs that are kept because something outside of EC2 uses
+// them, with the reason.
+func printProtected(protected map[string]string) {
+	if len(protected) == 0 {
+		return
+	}
+
+	ids := make([]string, 0, len(protected))
+	for id := range protected {
+		ids = append(ids, id)
+	}
+	sort.Strings(ids)
+
+	fmt.Println("In use outside of EC2:")
+	w := tabwriter.NewWriter(os.Stdout, 0, 0, 2, ' ', 0) //nolint:mnd
+	for _, id := range ids {
+		fmt.Fprintf(w, "  %s\t%s\n", id, protected[id])
+	}
+	w.Flush()
+}
+
+// printOwners prints the storage report of each owner's candidates in plan, followed by
+// the total for the whole plan.
+func printOwners(title string, plan *cami.Plan) {
+	if plan.Storage == nil || len(plan.Storage.Images) == 0 {
+		return
+	}
+
+	for _, g := range plan.ByOwner() {
+		printStorage(fmt.Sprintf("%s owned by %s", title, g.Owner), g.Storage)
+	}
+	fmt.Printf("Total for all owners: %s, estimated %s\n", formatBytes(plan.Storage.Bytes), formatCost(plan.Storage.MonthlyCost))
+}
+
+// printReclaimed prints the storage reclaimed by a run.
+func printReclaimed(result *cami.Result) {
+	if result == nil || result.Reclaimed == nil || len(result.Reclaimed.Images) == 0 {
+		return
+	}
+	fmt.Printf("Reclaimed %s, estimated savings %s\n", formatBytes(result.Reclaimed.Bytes), formatCost(result.MonthlySavings))
+
+	owners := make([]string, 0, len(result.Owners))
+	for owner, r := range result.Owners {
+		if r.Reclaimed != nil && len(r.Reclaimed.Images) > 0 {
+			owners = append(owners, owner)
+		}
+	}
+	sort.Strings(owners)
+	for _, owner := range owners {
+		r := result.Owners[owner]
+		fmt.Printf("  %s: %s, estimated savings %s\n", owner, formatBytes(r.Reclaimed.Bytes), formatCost(r.MonthlySavings))
+	}
+}
+
+// formatBytes formats bytes as GiB.
+func formatBytes(b int64) string {
+	return fmt.Sprintf("%.1f GiB", float64(b)/gib)
+}
+
+// formatCost formats a monthly cost in USD.
+func formatCost(c float64) string {
+	return fmt.Sprintf("$%.2f/month", c)
+}
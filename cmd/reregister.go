@@ -0,0 +1,71 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+
+	"github.com/lingrino/cami/cami"
+	"github.com/spf13/cobra"
+)
+
+func reregisterCmd(o *options) *cobra.Command {
+	return &cobra.Command{
+		Use:   "reregister [AMI ID...]",
+		Short: "Re-registers AMIs saved to the --archive-file before they were deregistered",
+		Long: "reregister registers new AMIs with the same configuration as AMIs saved to the --archive-file. " +
+			"The snapshots of the original AMIs must still exist. If no AMI IDs are passed every AMI in the " +
+			"archive is re-registered.",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			if o.archiveFile == "" {
+				fatal("--archive-file is required")
+			}
+
+			records, err := cami.ReadArchive(o.archiveFile)
+			if err != nil {
+				fatal("read archive", "error", err)
+			}
+
+			if len(args) > 0 {
+				want := make(map[string]bool)
+				for _, id := range args {
+					want[id] = true
+				}
+
+				var selected []cami.ArchiveRecord
+				for _, record := range records {
+					if want[*record.Image.ImageId] {
+						selected = append(selected, record)
+						delete(want, *record.Image.ImageId)
+					}
+				}
+				for id := range want {
+					fatal("not in the archive", "id", id)
+				}
+				records = selected
+			}
+
+			aws := newAWS(o.config())
+
+			ids, err := aws.ReregisterImages(records)
+
+			var registered []string
+			for _, record := range records {
+				id := *record.Image.ImageId
+				if newID, ok := ids[id]; ok {
+					registered = append(registered, fmt.Sprintf("%s -> %s", id, newID))
+				}
+			}
+			printIDs("Successfully re-registered", registered)
+
+			var er *cami.ErrDeleteAMIs
+			if err != nil {
+				if errors.As(err, &er) {
+					fatal("failed to re-register", "ids", er.IDs)
+				} else {
+					fatal("unknown error", "error", err)
+				}
+			}
+		},
+	}
+}
@@ -0,0 +1,88 @@
+package cmd
+
+import (
+	"encoding/json"
+	"errors"
+	"os"
+
+	"github.com/lingrino/cami/cami"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagFromFileDesc = "Restore the IDs acted on in a previous run, read from the file it wrote with --result-file."
+)
+
+func restoreCmd(o *options) *cobra.Command {
+	// fromFile is a file containing the IDs to restore
+	var fromFile string
+
+	cmd := &cobra.Command{
+		Use:   "restore [ID...]",
+		Short: "Restores AMIs and snapshots deleted by cami from the Recycle Bin",
+		Long: "restore recovers AMIs and snapshots from the Recycle Bin. IDs can be passed as arguments " +
+			"or read from the result file of a previous cami run. Restoring an AMI also restores its snapshots.",
+
+		Run: func(cmd *cobra.Command, args []string) {
+			ids := args
+			if fromFile != "" {
+				ids = append(ids, readResult(fromFile).IDs...)
+			}
+			ids = unique(ids)
+			if len(ids) == 0 {
+				fatal("no IDs to restore")
+			}
+
+			aws := newAWS(o.config())
+
+			restored, err := aws.RestoreAMIs(ids)
+			printIDs("Successfully restored", restored)
+
+			var er *cami.ErrDeleteAMIs
+			if err != nil {
+				if errors.As(err, &er) {
+					fatal("failed to restore", "ids", er.IDs)
+				} else {
+					fatal("unknown error", "error", err)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().StringVarP(&fromFile, "from-file", "f", "", flagFromFileDesc)
+
+	return cmd
+}
+
+// readResult reads the outcome of a run from a file written with --result-file. Exits
+// if the file cannot be read.
+func readResult(path string) cami.Outcome {
+	var output cami.Outcome
+
+	b, err := os.ReadFile(path)
+	if err != nil {
+		fatal("read result file", "error", err)
+	}
+	err = json.Unmarshal(b, &output)
+	if err != nil {
+		fatal("invalid result file", "file", path, "error", err)
+	}
+
+	return output
+}
+
+// unique returns ids with duplicates removed, keeping the first occurrence.
+func unique(ids []string) []string {
+	var output []string
+
+	seen := make(map[string]bool)
+	for _, id := range ids {
+		if seen[id] {
+			continue
+		}
+		seen[id] = true
+		output = append(output, id)
+	}
+
+	return output
+}
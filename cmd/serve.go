@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/lingrino/cami/cami"
+	"github.com/robfig/cron/v3"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagScheduleDesc = "When to run, as a standard five field cron expression in local time (e.g. \"0 3 * * *\"). " +
+		"Prefix with CRON_TZ=<zone> to use another time zone."
+	flagListenDesc = "Address to serve /healthz, /status and /metrics on."
+
+	// defaultListen is the default address the HTTP server listens on.
+	defaultListen = ":8080"
+	// shutdownTimeout is how long in flight HTTP requests have to finish on shutdown.
+	shutdownTimeout = 10 * time.Second
+	// readHeaderTimeout is how long clients have to send request headers.
+	readHeaderTimeout = 10 * time.Second
+)
+
+// runStatus is the outcome of a single scheduled run.
+type runStatus struct {
+	Started    time.Time `json:"started"`
+	Finished   time.Time `json:"finished"`
+	Candidates int       `json:"candidates"`
+	IDs        []string  `json:"ids"`
+	Error      string    `json:"error,omitempty"`
+}
+
+// scheduler runs the cleanup pipeline on a schedule, never more than one run at a time.
+type scheduler struct {
+	cfg      *cami.Config
+	o        *options
+	schedule cron.Schedule
+	// region is the region runs are in
+	region string
+
+	mu      sync.Mutex
+	running *time.Time
+	last    *runStatus
+}
+
+func serveCmd(o *options) *cobra.Command {
+	// schedule is the cron expression runs are scheduled with
+	var schedule string
+	// listen is the address the HTTP server listens on
+	var listen string
+
+	cmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Runs cami on a schedule as a long running daemon",
+		Long: "serve runs the same pipeline as cami on a cron schedule, skipping a run if the previous one is " +
+			"still in progress. Health, last run status and Prometheus metrics are served over HTTP. On SIGTERM " +
+			"the current run finishes the AMI it is working on and stops.",
+
+		Args: cobra.NoArgs,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			sched, err := cron.ParseStandard(schedule)
+			if err != nil {
+				fatal("invalid schedule", "schedule", schedule, "error", err)
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+			defer stop()
+
+			o.metrics = cami.NewMetrics()
+			cfg := o.config()
+			cfg.Context = ctx
+			aws := newAWS(cfg)
+
+			s := &scheduler{cfg: cfg, o: o, schedule: sched, region: aws.Region()}
+
+			srv := &http.Server{
+				Addr:              listen,
+				Handler:           s.handler(),
+				ReadHeaderTimeout: readHeaderTimeout,
+			}
+			go func() {
+				err := srv.ListenAndServe()
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					fatal("serve", "error", err)
+				}
+			}()
+
+			c := cron.New()
+			c.Schedule(sched, cron.FuncJob(s.run))
+			c.Start()
+			logger.Info("serving", "addr", listen, "schedule", schedule)
+
+			<-ctx.Done()
+			logger.Info("shutting down, waiting for the current run to stop")
+			<-c.Stop().Done()
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			err = srv.Shutdown(shutdownCtx)
+			if err != nil {
+				logger.Warn("failed to shut down http server", "error", err)
+			}
+		},
+	}
+
+	cmd.Flags().StringVar(&schedule, "schedule", "", flagScheduleDesc)
+	cmd.Flags().StringVar(&listen, "listen", defaultListen, flagListenDesc)
+	_ = cmd.MarkFlagRequired("schedule")
+
+	return cmd
+}
+
+// run runs the pipeline once unless a run is already in progress.
+func (s *scheduler) run() {
+	start := time.Now()
+
+	s.mu.Lock()
+	if s.running != nil {
+		since := *s.running
+		s.mu.Unlock()
+		logger.Warn("skipping run, the previous run is still in progress", "started", since)
+		return
+	}
+	s.running = &start
+	s.mu.Unlock()
+
+	logger.Info("starting run")
+	status := &runStatus{Started: start}
+
+	plan, result, err := s.apply()
+	if plan != nil {
+		status.Candidates = len(plan.Candidates)
+	}
+	if result != nil {
+		status.IDs = result.IDs
+	}
+	if err != nil {
+		status.Error = err.Error()
+		logger.Error("run failed", "error", err)
+	} else {
+		logger.Info("run finished", "candidates", status.Candidates, "ids", len(status.IDs))
+	}
+	s.o.finishRun(s.region, start, plan, result, err)
+	status.Finished = time.Now()
+
+	s.mu.Lock()
+	s.running = nil
+	s.last = status
+	s.mu.Unlock()
+}
+
+// apply plans and applies a single run with a fresh client.
+func (s *scheduler) apply() (*cami.Plan, *cami.Result, error) {
+	aws, err := cami.NewAWS(s.cfg)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	err = aws.Auth()
+	if err != nil {
+		return nil, nil, err
+	}
+
+	plan, err := aws.Plan()
+	if err != nil {
+		return plan, nil, err
+	}
+
+	result, err := aws.Apply(plan)
+	return plan, result, err
+}
+
+// handler returns the HTTP handler serving health, status and metrics.
+func (s *scheduler) handler() http.Handler {
+	mux := http.NewServeMux()
+
+	mux.HandleFunc("GET /healthz", func(w http.ResponseWriter, r *http.Request) {
+		_, _ = w.Write([]byte("ok\n"))
+	})
+
+	mux.HandleFunc("GET /status", func(w http.ResponseWriter, r *http.Request) {
+		s.mu.Lock()
+		status := struct {
+			Running      bool       `json:"running"`
+			RunningSince *time.Time `json:"running_since,omitempty"`
+			NextRun      time.Time  `json:"next_run"`
+			LastRun      *runStatus `json:"last_run"`
+		}{
+			Running:      s.running != nil,
+			RunningSince: s.running,
+			NextRun:      s.schedule.Next(time.Now()),
+			LastRun:      s.last,
+		}
+		b, err := json.Marshal(status)
+		s.mu.Unlock()
+
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		_, _ = w.Write(b)
+	})
+
+	mux.Handle("GET /metrics", s.cfg.Metrics.Handler())
+
+	return mux
+}
@@ -0,0 +1,90 @@
+package cmd
+
+import (
+	"context"
+	"errors"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/lingrino/cami/server"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagDataDirDesc      = "Directory plans and runs are stored in."
+	flagServerListenDesc = "Address to serve the API on."
+	flagPlanTTLDesc      = "How long a plan can be applied after it is created."
+
+	// defaultDataDir is the default directory plans and runs are stored in.
+	defaultDataDir = "cami-data"
+	// tokenEnv is the environment variable holding the bearer token.
+	tokenEnv = "CAMI_SERVER_TOKEN"
+)
+
+func serverCmd(o *options) *cobra.Command {
+	// dataDir is where plans and runs are stored
+	var dataDir string
+	// listen is the address the HTTP server listens on
+	var listen string
+	// planTTL is how long a plan can be applied
+	var planTTL time.Duration
+
+	cmd := &cobra.Command{
+		Use:   "server",
+		Short: "Serves an HTTP API for creating and applying plans on demand",
+		Long: "server serves a REST API with POST /plans, GET /plans/{id}, POST /plans/{id}/apply and " +
+			"GET /runs/{id}. Every request must have the header \"Authorization: Bearer <token>\" where the " +
+			"token is read from the " + tokenEnv + " environment variable. Only one apply runs at a time in " +
+			"each account and region. A plan can only be applied once, within --plan-ttl of being created, " +
+			"and AMIs in it that are no longer unused are skipped.",
+
+		Args: cobra.NoArgs,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			ctx, stop := signal.NotifyContext(context.Background(), syscall.SIGTERM, os.Interrupt)
+			defer stop()
+
+			cfg := o.config()
+			cfg.Context = ctx
+
+			s, err := server.New(*cfg, dataDir, os.Getenv(tokenEnv))
+			if err != nil {
+				fatal("create server", "error", err, "hint", "set "+tokenEnv)
+			}
+			s.PlanTTL = planTTL
+
+			srv := &http.Server{
+				Addr:              listen,
+				Handler:           s.Handler(),
+				ReadHeaderTimeout: readHeaderTimeout,
+			}
+			go func() {
+				err := srv.ListenAndServe()
+				if err != nil && !errors.Is(err, http.ErrServerClosed) {
+					fatal("serve", "error", err)
+				}
+			}()
+			logger.Info("serving", "addr", listen, "data_dir", dataDir)
+
+			<-ctx.Done()
+			logger.Info("shutting down, waiting for running applies to stop")
+
+			shutdownCtx, cancel := context.WithTimeout(context.Background(), shutdownTimeout)
+			defer cancel()
+			err = srv.Shutdown(shutdownCtx)
+			if err != nil {
+				logger.Warn("failed to shut down http server", "error", err)
+			}
+			s.Wait()
+		},
+	}
+
+	cmd.Flags().StringVar(&dataDir, "data-dir", defaultDataDir, flagDataDirDesc)
+	cmd.Flags().StringVar(&listen, "listen", defaultListen, flagServerListenDesc)
+	cmd.Flags().DurationVar(&planTTL, "plan-ttl", server.DefaultPlanTTL, flagPlanTTLDesc)
+
+	return cmd
+}
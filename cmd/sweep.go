@@ -0,0 +1,76 @@
+package cmd
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"github.com/spf13/cobra"
+)
+
+const (
+	flagGracePeriodDesc = "How long an AMI must stay marked as unused before it is deleted."
+
+	// defaultGracePeriod is one week.
+	defaultGracePeriod = 7 * 24 * time.Hour
+)
+
+func sweepCmd(o *options) *cobra.Command {
+	// gracePeriod is how long an AMI must be marked before it is swept
+	var gracePeriod time.Duration
+	// yes skips the confirmation prompt
+	var yes bool
+
+	cmd := &cobra.Command{
+		Use:   "sweep",
+		Short: "Marks unused AMIs and deletes those that are still unused after a grace period",
+		Long: "sweep tags unused AMIs with the time they were first seen unused. On later runs, " +
+			"AMIs that are still unused after the grace period are deleted (or handled according to --action) " +
+			"and AMIs that are used again have their mark removed.",
+
+		Args: cobra.NoArgs,
+
+		Run: func(cmd *cobra.Command, args []string) {
+			cfg := o.config()
+			cfg.GracePeriod = gracePeriod
+			if !cfg.DryRun && !yes && interactive() {
+				cfg.ConfirmSweep = func(plan *cami.Plan) []types.Image {
+					return confirm(os.Stdin, os.Stdout, plan, o.action)
+				}
+			}
+
+			aws := newAWS(cfg)
+
+			start := time.Now()
+			result, err := aws.MarkAndSweep()
+			o.writeMetrics()
+			swept := &cami.Result{RunID: result.RunID, IDs: result.Swept, ImageBuilderImages: result.ImageBuilderImages}
+			o.writeResult(swept, err)
+			o.sendNotification(o.runSummary(aws.Region(), start, nil, swept, err))
+			printIDs("Newly marked", result.Marked)
+			printIDs("Unmarked", result.Unmarked)
+			printIDs("Swept", result.Swept)
+			printIDs("Deleted Image Builder images", result.ImageBuilderImages)
+			if len(result.Marked)+len(result.Unmarked)+len(result.Swept) == 0 && err == nil {
+				fmt.Println("nothing to mark or sweep")
+			}
+
+			var eda *cami.ErrDeleteAMIs
+			if err != nil {
+				if errors.As(err, &eda) {
+					fatal("failed", "ids", eda.IDs)
+				} else {
+					fatal("unknown error", "error", err)
+				}
+			}
+		},
+	}
+
+	cmd.Flags().DurationVar(&gracePeriod, "grace-period", defaultGracePeriod, flagGracePeriodDesc)
+	cmd.Flags().BoolVarP(&yes, "yes", "y", false, flagYesDesc)
+
+	return cmd
+}
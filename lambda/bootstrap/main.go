@@ -0,0 +1,28 @@
+// Command bootstrap runs the cami Lambda handler. Build it for the provided.al2023
+// runtime with:
+//
+//	GOOS=linux GOARCH=arm64 go build -o bootstrap ./lambda/bootstrap
+//
+// The base configuration is read from CAMI_* environment variables, see
+// lambda.ConfigFromEnv. Runs are dry runs unless CAMI_DRYRUN is false or the event
+// sets "dryrun": false.
+package main
+
+import (
+	"fmt"
+	"os"
+
+	awslambda "github.com/aws/aws-lambda-go/lambda"
+	"github.com/lingrino/cami/lambda"
+)
+
+func main() {
+	cfg, err := lambda.ConfigFromEnv(os.Getenv)
+	if err != nil {
+		fmt.Fprintln(os.Stderr, err)
+		os.Exit(1)
+	}
+
+	h := lambda.New(cfg)
+	awslambda.Start(h.Handle)
+}
@@ -0,0 +1,144 @@
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+)
+
+// ErrInvalidEnv is when an environment variable of the function is not valid.
+var ErrInvalidEnv = errors.New("invalid environment variable")
+
+// env reads typed values from environment variables, keeping the first error.
+type env struct {
+	getenv func(string) string
+	err    error
+}
+
+// fail records that the value of key could not be parsed.
+func (e *env) fail(key string, err error) {
+	if e.err == nil {
+		e.err = fmt.Errorf("%w: %s: %w", ErrInvalidEnv, key, err)
+	}
+}
+
+// string returns the value of key.
+func (e *env) string(key string) string {
+	return strings.TrimSpace(e.getenv(key))
+}
+
+// list returns the comma separated values of key.
+func (e *env) list(key string) []string {
+	var output []string
+	for _, v := range strings.Split(e.string(key), ",") {
+		if v = strings.TrimSpace(v); v != "" {
+			output = append(output, v)
+		}
+	}
+	return output
+}
+
+// bool returns the value of key, or def if it is not set.
+func (e *env) bool(key string, def bool) bool {
+	s := e.string(key)
+	if s == "" {
+		return def
+	}
+	v, err := strconv.ParseBool(s)
+	if err != nil {
+		e.fail(key, err)
+		return def
+	}
+	return v
+}
+
+// int returns the value of key, or zero if it is not set.
+func (e *env) int(key string) int {
+	s := e.string(key)
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.Atoi(s)
+	if err != nil {
+		e.fail(key, err)
+	}
+	return v
+}
+
+// float returns the value of key, or zero if it is not set.
+func (e *env) float(key string) float64 {
+	s := e.string(key)
+	if s == "" {
+		return 0
+	}
+	v, err := strconv.ParseFloat(s, 64)
+	if err != nil {
+		e.fail(key, err)
+	}
+	return v
+}
+
+// duration returns the value of key, or zero if it is not set.
+func (e *env) duration(key string) time.Duration {
+	s := e.string(key)
+	if s == "" {
+		return 0
+	}
+	v, err := time.ParseDuration(s)
+	if err != nil {
+		e.fail(key, err)
+	}
+	return v
+}
+
+// ConfigFromEnv returns a base configuration for the handler read from environment
+// variables with getenv, usually os.Getenv. Every variable is optional and mirrors the
+// cami flag of the same name, e.g. CAMI_MAX_IMAGES for --max-images. Lists are comma
+// separated. Runs are dry runs unless CAMI_DRYRUN is false. The configuration is
+// validated with cami.Config.Validate.
+func ConfigFromEnv(getenv func(string) string) (cami.Config, error) {
+	e := &env{getenv: getenv}
+
+	var states []types.InstanceStateName
+	for _, state := range e.list("CAMI_INSTANCE_STATES") {
+		states = append(states, types.InstanceStateName(state))
+	}
+
+	cfg := cami.Config{
+		DryRun:         e.bool("CAMI_DRYRUN", true),
+		InstanceStates: states,
+		StoppedMaxAge:  e.duration("CAMI_STOPPED_MAX_AGE"),
+		LaunchedWithin: e.duration("CAMI_LAUNCHED_WITHIN"),
+		Action:         cami.Action(e.string("CAMI_ACTION")),
+		DeprecateAfter: e.duration("CAMI_DEPRECATE_AFTER"),
+		OwnerTagKeys:   e.list("CAMI_OWNER_TAG_KEYS"),
+
+		SSMParameterPaths: e.list("CAMI_SSM_PATH"),
+
+		CloudFormation:          e.bool("CAMI_CLOUDFORMATION", false),
+		CloudFormationTemplates: e.bool("CAMI_CLOUDFORMATION_TEMPLATES", false),
+		EKS:                     e.bool("CAMI_EKS", false),
+		ImageBuilder:            cami.ImageBuilderMode(e.string("CAMI_IMAGE_BUILDER")),
+
+		RecycleBin:           cami.RecycleBinMode(e.string("CAMI_RECYCLE_BIN")),
+		CreateRecycleBinRule: e.bool("CAMI_CREATE_RECYCLE_BIN_RULE", false),
+
+		MaxImages:      e.int("CAMI_MAX_IMAGES"),
+		MaxPercent:     e.float("CAMI_MAX_PERCENT"),
+		OverrideLimits: e.bool("CAMI_OVERRIDE_LIMITS", false),
+
+		AuditLogPath: e.string("CAMI_AUDIT_LOG"),
+		SNSTopicARN:  e.string("CAMI_SNS_TOPIC_ARN"),
+		EventBusName: e.string("CAMI_EVENT_BUS"),
+	}
+
+	if e.err != nil {
+		return cfg, e.err
+	}
+	return cfg, cfg.Validate()
+}
@@ -0,0 +1,127 @@
+package lambda
+
+import (
+	"errors"
+	"fmt"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"github.com/stretchr/testify/assert"
+)
+
+func TestConfigFromEnv(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		giveEnv map[string]string
+		want    cami.Config
+		wantErr error
+	}{
+		{
+			name:    "defaults",
+			giveEnv: nil,
+			want:    cami.Config{DryRun: true},
+		},
+		{
+			name: "all",
+			giveEnv: map[string]string{
+				"CAMI_DRYRUN":                   "false",
+				"CAMI_INSTANCE_STATES":          "running, stopped",
+				"CAMI_STOPPED_MAX_AGE":          "720h",
+				"CAMI_LAUNCHED_WITHIN":          "24h",
+				"CAMI_ACTION":                   "deprecate",
+				"CAMI_DEPRECATE_AFTER":          "1h",
+				"CAMI_OWNER_TAG_KEYS":           "team,,owner",
+				"CAMI_SSM_PATH":                 "/ami",
+				"CAMI_CLOUDFORMATION":           "true",
+				"CAMI_CLOUDFORMATION_TEMPLATES": "1",
+				"CAMI_EKS":                      "true",
+				"CAMI_IMAGE_BUILDER":            "protect",
+				"CAMI_RECYCLE_BIN":              "require",
+				"CAMI_CREATE_RECYCLE_BIN_RULE":  "true",
+				"CAMI_MAX_IMAGES":               "10",
+				"CAMI_MAX_PERCENT":              "12.5",
+				"CAMI_OVERRIDE_LIMITS":          "true",
+				"CAMI_AUDIT_LOG":                "/tmp/audit.log",
+				"CAMI_SNS_TOPIC_ARN":            "arn:aws:sns:us-east-1:123456789012:cami",
+				"CAMI_EVENT_BUS":                " default ",
+			},
+			want: cami.Config{
+				DryRun:                  false,
+				InstanceStates:          []types.InstanceStateName{types.InstanceStateNameRunning, types.InstanceStateNameStopped},
+				StoppedMaxAge:           720 * time.Hour,
+				LaunchedWithin:          24 * time.Hour,
+				Action:                  cami.ActionDeprecate,
+				DeprecateAfter:          time.Hour,
+				OwnerTagKeys:            []string{"team", "owner"},
+				SSMParameterPaths:       []string{"/ami"},
+				CloudFormation:          true,
+				CloudFormationTemplates: true,
+				EKS:                     true,
+				ImageBuilder:            cami.ImageBuilderProtect,
+				RecycleBin:              cami.RecycleBinRequire,
+				CreateRecycleBinRule:    true,
+				MaxImages:               10,
+				MaxPercent:              12.5,
+				OverrideLimits:          true,
+				AuditLogPath:            "/tmp/audit.log",
+				SNSTopicARN:             "arn:aws:sns:us-east-1:123456789012:cami",
+				EventBusName:            "default",
+			},
+		},
+		{
+			name:    "invalid bool",
+			giveEnv: map[string]string{"CAMI_DRYRUN": "nope"},
+			wantErr: ErrInvalidEnv,
+		},
+		{
+			name:    "invalid int",
+			giveEnv: map[string]string{"CAMI_MAX_IMAGES": "ten"},
+			wantErr: ErrInvalidEnv,
+		},
+		{
+			name:    "invalid float",
+			giveEnv: map[string]string{"CAMI_MAX_PERCENT": "half"},
+			wantErr: ErrInvalidEnv,
+		},
+		{
+			name:    "invalid instance state",
+			giveEnv: map[string]string{"CAMI_INSTANCE_STATES": "running,runing"},
+			wantErr: cami.ErrInvalidInstanceState,
+		},
+		{
+			name:    "invalid image builder mode",
+			giveEnv: map[string]string{"CAMI_IMAGE_BUILDER": "protected"},
+			wantErr: cami.ErrInvalidImageBuilderMode,
+		},
+		{
+			name:    "invalid recycle bin mode",
+			giveEnv: map[string]string{"CAMI_RECYCLE_BIN": "requre"},
+			wantErr: cami.ErrInvalidRecycleBinMode,
+		},
+		{
+			name:    "invalid duration",
+			giveEnv: map[string]string{"CAMI_STOPPED_MAX_AGE": "30"},
+			wantErr: ErrInvalidEnv,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			got, err := ConfigFromEnv(func(key string) string { return tt.giveEnv[key] })
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+				assert.Equal(t, tt.want, got)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+		})
+	}
+}
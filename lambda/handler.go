@@ -0,0 +1,152 @@
+// Package lambda provides an AWS Lambda handler that runs the cami pipeline.
+package lambda
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"strings"
+
+	"github.com/lingrino/cami/cami"
+)
+
+var (
+	// ErrInvalidEvent is when the event the handler is invoked with is not valid.
+	ErrInvalidEvent = errors.New("invalid event")
+	// ErrRegionsFailed is when the pipeline failed in at least one region.
+	ErrRegionsFailed = errors.New("regions failed")
+)
+
+// Event is the payload the handler is invoked with. Every field is optional and
+// overrides the base configuration of the handler.
+type Event struct {
+	// DryRun overrides Config.DryRun
+	DryRun *bool `json:"dryrun"`
+	// Regions to run in, one after another. Defaults to the region of the function.
+	Regions []string `json:"regions"`
+	// Selectors overrides Config.ImageFilters, e.g. {"tag:team": ["infra"]}
+	Selectors map[string][]string `json:"selectors"`
+}
+
+// Response is the result of a single invocation.
+type Response struct {
+	// DryRun is true if nothing was changed
+	DryRun bool `json:"dryrun"`
+	// Regions is the result of each region, in the order they ran
+	Regions []RegionResult `json:"regions"`
+}
+
+// RegionResult is the result of running the pipeline in a single region.
+type RegionResult struct {
+	// Region is the region the pipeline ran in, empty for the default region
+	Region string `json:"region"`
+	// Candidates is the IDs of the unused AMIs that were found
+	Candidates []string `json:"candidates"`
+	// Outcome is what was acted on and why the pipeline failed, if it did
+	cami.Outcome
+}
+
+// runner is the part of cami.AWS the handler uses.
+type runner interface {
+	Plan() (*cami.Plan, error)
+	Apply(*cami.Plan) (*cami.Result, error)
+}
+
+// Handler runs the cami pipeline for each invocation.
+type Handler struct {
+	cfg cami.Config
+
+	// Used for testing
+	newRunnerFn func(*cami.Config) (runner, error)
+}
+
+// New returns a Handler that runs with cfg, overridden by each event.
+func New(cfg cami.Config) *Handler {
+	return &Handler{cfg: cfg, newRunnerFn: newRunner}
+}
+
+// newRunner returns an authenticated cami client.
+func newRunner(cfg *cami.Config) (runner, error) {
+	a, err := cami.NewAWS(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.Auth()
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Handle runs the pipeline in every region of the event. Failures in a region are
+// reported in its RegionResult and do not stop the other regions, but fail the
+// invocation with ErrRegionsFailed once every region has run. The function's deadline
+// cancels the run between AMIs.
+func (h *Handler) Handle(ctx context.Context, e Event) (*Response, error) {
+	cfg := h.cfg
+	cfg.Context = ctx
+	if e.DryRun != nil {
+		cfg.DryRun = *e.DryRun
+	}
+	if e.Selectors != nil {
+		cfg.ImageFilters = e.Selectors
+	}
+
+	for name, values := range cfg.ImageFilters {
+		if name == "" || len(values) == 0 {
+			return nil, fmt.Errorf("%w: selector %q needs a name and at least one value", ErrInvalidEvent, name)
+		}
+	}
+
+	regions := e.Regions
+	if len(regions) == 0 {
+		regions = []string{cfg.Region}
+	}
+
+	output := &Response{DryRun: cfg.DryRun}
+	var failed []string
+	for _, region := range regions {
+		rcfg := cfg
+		rcfg.Region = region
+		result := h.run(&rcfg)
+		output.Regions = append(output.Regions, result)
+
+		if result.Error != "" {
+			if region == "" {
+				region = "default region"
+			}
+			failed = append(failed, region+": "+result.Error)
+		}
+	}
+
+	if len(failed) > 0 {
+		return output, fmt.Errorf("%w: %s", ErrRegionsFailed, strings.Join(failed, "; "))
+	}
+	return output, nil
+}
+
+// run runs the pipeline in a single region.
+func (h *Handler) run(cfg *cami.Config) RegionResult {
+	output := RegionResult{Region: cfg.Region}
+
+	r, err := h.newRunnerFn(cfg)
+	if err != nil {
+		output.Error = err.Error()
+		return output
+	}
+
+	plan, err := r.Plan()
+	if err != nil {
+		output.Error = err.Error()
+		return output
+	}
+	for _, ami := range plan.Candidates {
+		output.Candidates = append(output.Candidates, *ami.ImageId)
+	}
+
+	output.Outcome = cami.NewOutcome(r.Apply(plan))
+
+	return output
+}
@@ -0,0 +1,170 @@
+package lambda
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"testing"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"github.com/stretchr/testify/assert"
+)
+
+type mockRunner struct {
+	RespPlan     *cami.Plan
+	RespPlanErr  error
+	RespApply    *cami.Result
+	RespApplyErr error
+}
+
+func (m *mockRunner) Plan() (*cami.Plan, error) {
+	return m.RespPlan, m.RespPlanErr
+}
+
+func (m *mockRunner) Apply(*cami.Plan) (*cami.Result, error) {
+	return m.RespApply, m.RespApplyErr
+}
+
+func TestHandle(t *testing.T) {
+	t.Parallel()
+
+	plan := &cami.Plan{Candidates: []types.Image{{ImageId: aws.String("ami-123")}}}
+	result := &cami.Result{
+		RunID:          "run",
+		IDs:            []string{"ami-123", "snap-123"},
+		Reclaimed:      &cami.StorageReport{Bytes: 1024},
+		MonthlySavings: 0.05,
+	}
+
+	tests := []struct {
+		name          string
+		giveEvent     string
+		giveRunner    mockRunner
+		giveRunnerErr error
+		wantDryRun    bool
+		wantFilters   map[string][]string
+		wantResponse  *Response
+		wantErr       error
+	}{
+		{
+			name:       "defaults",
+			giveEvent:  `{}`,
+			giveRunner: mockRunner{RespPlan: plan, RespApply: result},
+			wantDryRun: true,
+			wantResponse: &Response{
+				DryRun: true,
+				Regions: []RegionResult{
+					{
+						Candidates: []string{"ami-123"},
+						Outcome: cami.Outcome{
+							RunID:          "run",
+							IDs:            []string{"ami-123", "snap-123"},
+							ReclaimedBytes: 1024,
+							MonthlySavings: 0.05,
+						},
+					},
+				},
+			},
+		},
+		{
+			name:        "overrides",
+			giveEvent:   `{"dryrun": false, "regions": ["us-east-1", "eu-west-1"], "selectors": {"tag:team": ["infra"]}}`,
+			giveRunner:  mockRunner{RespPlan: &cami.Plan{}, RespApply: &cami.Result{}},
+			wantDryRun:  false,
+			wantFilters: map[string][]string{"tag:team": {"infra"}},
+			wantResponse: &Response{
+				DryRun: false,
+				Regions: []RegionResult{
+					{Region: "us-east-1"},
+					{Region: "eu-west-1"},
+				},
+			},
+		},
+		{
+			name:          "auth error",
+			giveEvent:     `{}`,
+			giveRunnerErr: cami.ErrCreateSession,
+			wantDryRun:    true,
+			wantResponse: &Response{
+				DryRun:  true,
+				Regions: []RegionResult{{Outcome: cami.Outcome{Error: "create session"}}},
+			},
+			wantErr: ErrRegionsFailed,
+		},
+		{
+			name:       "plan error",
+			giveEvent:  `{}`,
+			giveRunner: mockRunner{RespPlanErr: cami.ErrDesribeImages},
+			wantDryRun: true,
+			wantResponse: &Response{
+				DryRun:  true,
+				Regions: []RegionResult{{Outcome: cami.Outcome{Error: "describe images"}}},
+			},
+			wantErr: ErrRegionsFailed,
+		},
+		{
+			name:      "apply error",
+			giveEvent: `{}`,
+			giveRunner: mockRunner{
+				RespPlan:     plan,
+				RespApply:    &cami.Result{IDs: []string{"ami-123"}},
+				RespApplyErr: &cami.ErrDeleteAMIs{IDs: []string{"snap-123"}},
+			},
+			wantDryRun: true,
+			wantResponse: &Response{
+				DryRun: true,
+				Regions: []RegionResult{
+					{
+						Candidates: []string{"ami-123"},
+						Outcome: cami.Outcome{
+							IDs:    []string{"ami-123"},
+							Failed: []string{"snap-123"},
+							Error:  "delete AMIs",
+						},
+					},
+				},
+			},
+			wantErr: ErrRegionsFailed,
+		},
+		{
+			name:      "invalid selector",
+			giveEvent: `{"selectors": {"name": []}}`,
+			wantErr:   ErrInvalidEvent,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			var e Event
+			assert.Nil(t, json.Unmarshal([]byte(tt.giveEvent), &e))
+
+			var cfgs []*cami.Config
+			h := New(cami.Config{DryRun: true})
+			h.newRunnerFn = func(cfg *cami.Config) (runner, error) {
+				cfgs = append(cfgs, cfg)
+				r := tt.giveRunner
+				return &r, tt.giveRunnerErr
+			}
+
+			resp, err := h.Handle(context.Background(), e)
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+			assert.Equal(t, tt.wantResponse, resp)
+
+			for _, cfg := range cfgs {
+				assert.Equal(t, tt.wantDryRun, cfg.DryRun)
+				assert.Equal(t, tt.wantFilters, cfg.ImageFilters)
+				assert.NotNil(t, cfg.Context)
+			}
+		})
+	}
+}
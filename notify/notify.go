@@ -0,0 +1,324 @@
+// Package notify sends a summary of a cami run to webhooks.
+package notify
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"text/template"
+	"time"
+)
+
+var (
+	// ErrInvalidOn is when the configured On is not supported.
+	ErrInvalidOn = errors.New("invalid notify on")
+	// ErrInvalidFormat is when the format of a webhook is not supported.
+	ErrInvalidFormat = errors.New("invalid webhook format")
+	// ErrSend is when we fail to send a notification after every attempt.
+	ErrSend = errors.New("send notification")
+)
+
+// On is when notifications are sent.
+type On string
+
+const (
+	// OnFailure sends a notification when a run fails or some resources could not be acted on.
+	OnFailure On = "failure"
+	// OnAlways sends a notification after every run.
+	OnAlways On = "always"
+	// OnChanges sends a notification when a run acted on something or failed.
+	OnChanges On = "changes"
+)
+
+// Format is the payload format of a webhook.
+type Format string
+
+const (
+	// FormatJSON posts the Summary as JSON.
+	FormatJSON Format = "json"
+	// FormatSlack posts {"text": "..."} with the Summary rendered by the webhook's
+	// template, which Slack incoming webhooks and most chat tools accept.
+	FormatSlack Format = "slack"
+)
+
+// DefaultSlackTemplate is the template used by FormatSlack webhooks without one.
+const DefaultSlackTemplate = `{{if .Error}}:x: cami run failed{{else}}:white_check_mark: cami run finished{{end}}` +
+	`{{if .DryRun}} (dry run){{end}} in {{.Region}}{{if .Owner}} for {{.Owner}}{{end}}` + "\n" +
+	`{{.Action}}: {{len .IDs}} acted on, {{len .Failed}} failed, {{.Candidates}} candidates` + "\n" +
+	`Reclaimed: {{.ReclaimedBytes}} bytes, saving ${{printf "%.2f" .MonthlySavings}} per month` +
+	`{{if .Failed}}` + "\n" + `Failed: {{join .Failed ", "}}{{end}}` +
+	`{{if .Error}}` + "\n" + `Error: {{.Error}}{{end}}`
+
+// Defaults for retrying webhooks.
+const (
+	// DefaultAttempts is how many times a webhook is tried.
+	DefaultAttempts = 3
+	// DefaultBackoff is how long to wait before the first retry, doubled for each retry.
+	DefaultBackoff = time.Second
+)
+
+// Summary is the outcome of a run.
+type Summary struct {
+	// Started is when the run started
+	Started time.Time `json:"started"`
+	// Finished is when the run finished
+	Finished time.Time `json:"finished"`
+	// RunID identifies the run in its result and the events it published
+	RunID string `json:"run_id,omitempty"`
+	// Region is the AWS region the run was in
+	Region string `json:"region"`
+	// DryRun is true if nothing was changed
+	DryRun bool `json:"dryrun"`
+	// Action is what was done to unused AMIs
+	Action string `json:"action"`
+	// Candidates is the number of unused AMIs found
+	Candidates int `json:"candidates"`
+	// IDs is the AMI and snapshot IDs that were acted on
+	IDs []string `json:"ids"`
+	// Failed is the AMI and snapshot IDs that could not be acted on
+	Failed []string `json:"failed"`
+	// ReclaimedBytes is the snapshot storage that was reclaimed
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// MonthlySavings is the estimated reduction in monthly storage cost in USD
+	MonthlySavings float64 `json:"monthly_savings"`
+	// Error is why the run failed, if it did
+	Error string `json:"error,omitempty"`
+	// Owner is set when the summary only covers the candidates of one owner
+	Owner string `json:"owner,omitempty"`
+	// Owners splits the run by the owner of each candidate, if owners are configured
+	Owners []OwnerSummary `json:"owners,omitempty"`
+}
+
+// OwnerSummary is the part of a run that covers the candidates of one owner.
+type OwnerSummary struct {
+	// Owner is the owner of the candidates
+	Owner string `json:"owner"`
+	// Candidates is the number of unused AMIs found that belong to the owner
+	Candidates int `json:"candidates"`
+	// IDs is the AMI and snapshot IDs of the owner that were acted on
+	IDs []string `json:"ids"`
+	// Failed is the AMI and snapshot IDs of the owner that could not be acted on
+	Failed []string `json:"failed"`
+	// ReclaimedBytes is the snapshot storage of the owner that was reclaimed
+	ReclaimedBytes int64 `json:"reclaimed_bytes"`
+	// MonthlySavings is the estimated reduction in the owner's monthly storage cost in USD
+	MonthlySavings float64 `json:"monthly_savings"`
+}
+
+// ForOwner returns the summary of the run restricted to the candidates of o.
+func (s Summary) ForOwner(o OwnerSummary) Summary {
+	s.Owner = o.Owner
+	s.Owners = nil
+	s.Candidates = o.Candidates
+	s.IDs = o.IDs
+	s.Failed = o.Failed
+	s.ReclaimedBytes = o.ReclaimedBytes
+	s.MonthlySavings = o.MonthlySavings
+	return s
+}
+
+// failed returns true if the run failed or some resources could not be acted on.
+func (s Summary) failed() bool {
+	return s.Error != "" || len(s.Failed) > 0
+}
+
+// Webhook is a single webhook notifications are posted to.
+type Webhook struct {
+	// URL is where the notification is posted
+	URL string
+	// Format is the payload format. Defaults to FormatJSON.
+	Format Format
+	// Template is a text/template rendered with the Summary for FormatSlack. Defaults to
+	// DefaultSlackTemplate. The function join is available.
+	Template string
+}
+
+// Notifier sends summaries to webhooks.
+type Notifier struct {
+	// Webhooks are the webhooks every notification is sent to
+	Webhooks []Webhook
+	// Routes are webhooks that only receive the part of a run that covers one owner,
+	// keyed by owner. See Summary.ForOwner.
+	Routes map[string][]Webhook
+	// On is when notifications are sent. Defaults to OnFailure.
+	On On
+	// Attempts is how many times each webhook is tried. Defaults to DefaultAttempts.
+	Attempts int
+	// Backoff is how long to wait before the first retry. Defaults to DefaultBackoff.
+	Backoff time.Duration
+	// Client is the HTTP client used to post. Defaults to http.DefaultClient.
+	Client *http.Client
+}
+
+// Validate returns an error if the notifier is not configured correctly.
+func (n *Notifier) Validate() error {
+	switch n.on() {
+	case OnFailure, OnAlways, OnChanges:
+	default:
+		return fmt.Errorf("%w: %s", ErrInvalidOn, n.On)
+	}
+
+	for _, wh := range n.webhooks() {
+		_, err := wh.payload(Summary{})
+		if err != nil {
+			return err
+		}
+	}
+
+	return nil
+}
+
+// Notify sends s to every webhook if it matches On, and the summary of each owner in
+// s.Owners to the routes of that owner if it matches On. Webhooks are retried with
+// exponential backoff on network errors, 429 and 5xx responses. Returns the errors of
+// every webhook that could not be sent.
+func (n *Notifier) Notify(ctx context.Context, s Summary) error {
+	if n == nil {
+		return nil
+	}
+
+	var errs []error
+	if n.matches(s) {
+		for _, wh := range n.Webhooks {
+			errs = append(errs, n.send(ctx, wh, s))
+		}
+	}
+
+	for _, o := range s.Owners {
+		owned := s.ForOwner(o)
+		if !n.matches(owned) {
+			continue
+		}
+		for _, wh := range n.Routes[o.Owner] {
+			errs = append(errs, n.send(ctx, wh, owned))
+		}
+	}
+
+	return errors.Join(errs...)
+}
+
+// webhooks returns every webhook, including routes.
+func (n *Notifier) webhooks() []Webhook {
+	output := append([]Webhook{}, n.Webhooks...)
+	for _, whs := range n.Routes {
+		output = append(output, whs...)
+	}
+	return output
+}
+
+// on returns the configured On or the default.
+func (n *Notifier) on() On {
+	if n.On == "" {
+		return OnFailure
+	}
+	return n.On
+}
+
+// matches returns true if a notification should be sent for s.
+func (n *Notifier) matches(s Summary) bool {
+	switch n.on() {
+	case OnAlways:
+		return true
+	case OnChanges:
+		return s.failed() || len(s.IDs) > 0
+	default:
+		return s.failed()
+	}
+}
+
+// send posts s to a single webhook, retrying on failure.
+func (n *Notifier) send(ctx context.Context, wh Webhook, s Summary) error {
+	body, err := wh.payload(s)
+	if err != nil {
+		return err
+	}
+
+	attempts := n.Attempts
+	if attempts <= 0 {
+		attempts = DefaultAttempts
+	}
+	backoff := n.Backoff
+	if backoff <= 0 {
+		backoff = DefaultBackoff
+	}
+	client := n.Client
+	if client == nil {
+		client = http.DefaultClient
+	}
+
+	for attempt := 1; ; attempt++ {
+		var retry bool
+		retry, err = post(ctx, client, wh.URL, body)
+		if err == nil {
+			return nil
+		}
+		if !retry || attempt >= attempts {
+			break
+		}
+
+		select {
+		case <-ctx.Done():
+			return fmt.Errorf("%w: %s: %w", ErrSend, wh.URL, ctx.Err())
+		case <-time.After(backoff):
+		}
+		backoff *= 2
+	}
+
+	return fmt.Errorf("%w: %s: %w", ErrSend, wh.URL, err)
+}
+
+// post posts body to url once. The bool is true if the post may succeed if retried.
+func post(ctx context.Context, client *http.Client, url string, body []byte) (bool, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, url, bytes.NewReader(body))
+	if err != nil {
+		return false, err
+	}
+	req.Header.Set("Content-Type", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return true, err
+	}
+	defer resp.Body.Close()
+	_, _ = io.Copy(io.Discard, resp.Body)
+
+	if resp.StatusCode >= http.StatusBadRequest {
+		retry := resp.StatusCode == http.StatusTooManyRequests || resp.StatusCode >= http.StatusInternalServerError
+		return retry, fmt.Errorf("status %s", resp.Status)
+	}
+
+	return false, nil
+}
+
+// payload returns the body posted to the webhook for s.
+func (wh Webhook) payload(s Summary) ([]byte, error) {
+	switch wh.Format {
+	case FormatJSON, "":
+		return json.Marshal(s)
+	case FormatSlack:
+		text := wh.Template
+		if text == "" {
+			text = DefaultSlackTemplate
+		}
+
+		tmpl, err := template.New("slack").Funcs(template.FuncMap{"join": strings.Join}).Parse(text)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFormat, err)
+		}
+
+		var buf bytes.Buffer
+		err = tmpl.Execute(&buf, s)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrInvalidFormat, err)
+		}
+
+		return json.Marshal(map[string]string{"text": buf.String()})
+	default:
+		return nil, fmt.Errorf("%w: %s", ErrInvalidFormat, wh.Format)
+	}
+}
@@ -0,0 +1,303 @@
+package notify
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/stretchr/testify/assert"
+)
+
+// standIn is a local webhook that records the bodies it receives and responds with
+// the next status in statuses, or 200 once they run out.
+type standIn struct {
+	mu       sync.Mutex
+	statuses []int
+	bodies   []string
+}
+
+func (s *standIn) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	b, _ := io.ReadAll(r.Body)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	s.bodies = append(s.bodies, string(b))
+	status := http.StatusOK
+	if len(s.statuses) > 0 {
+		status, s.statuses = s.statuses[0], s.statuses[1:]
+	}
+	w.WriteHeader(status)
+}
+
+func TestNotifyOn(t *testing.T) {
+	t.Parallel()
+
+	ok := Summary{IDs: []string{"ami-123"}}
+	noop := Summary{}
+	failed := Summary{Failed: []string{"snap-123"}}
+	errored := Summary{Error: "describe images"}
+
+	tests := []struct {
+		name string
+		on   On
+		give Summary
+		want bool
+	}{
+		{name: "failure ok", on: OnFailure, give: ok, want: false},
+		{name: "failure failed", on: OnFailure, give: failed, want: true},
+		{name: "failure errored", on: OnFailure, give: errored, want: true},
+		{name: "default errored", on: "", give: errored, want: true},
+		{name: "always noop", on: OnAlways, give: noop, want: true},
+		{name: "changes noop", on: OnChanges, give: noop, want: false},
+		{name: "changes ok", on: OnChanges, give: ok, want: true},
+		{name: "changes failed", on: OnChanges, give: failed, want: true},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			si := &standIn{}
+			srv := httptest.NewServer(si)
+			defer srv.Close()
+
+			n := &Notifier{Webhooks: []Webhook{{URL: srv.URL}}, On: tt.on}
+			assert.Nil(t, n.Notify(context.Background(), tt.give))
+
+			if tt.want {
+				assert.Len(t, si.bodies, 1)
+			} else {
+				assert.Empty(t, si.bodies)
+			}
+		})
+	}
+}
+
+func TestNotifyPayloads(t *testing.T) {
+	t.Parallel()
+
+	s := Summary{
+		Started:        time.Date(2021, 1, 10, 0, 0, 0, 0, time.UTC),
+		Finished:       time.Date(2021, 1, 10, 0, 1, 0, 0, time.UTC),
+		Region:         "us-east-1",
+		Action:         "deregister",
+		Candidates:     2,
+		IDs:            []string{"ami-123", "snap-123"},
+		Failed:         []string{"ami-456"},
+		ReclaimedBytes: 1024,
+		MonthlySavings: 1.5,
+	}
+
+	jsonSI := &standIn{}
+	jsonSrv := httptest.NewServer(jsonSI)
+	defer jsonSrv.Close()
+
+	slackSI := &standIn{}
+	slackSrv := httptest.NewServer(slackSI)
+	defer slackSrv.Close()
+
+	customSI := &standIn{}
+	customSrv := httptest.NewServer(customSI)
+	defer customSrv.Close()
+
+	n := &Notifier{
+		Webhooks: []Webhook{
+			{URL: jsonSrv.URL},
+			{URL: slackSrv.URL, Format: FormatSlack},
+			{URL: customSrv.URL, Format: FormatSlack, Template: "{{len .IDs}} deleted in {{.Region}}"},
+		},
+		On: OnAlways,
+	}
+	assert.Nil(t, n.Validate())
+	assert.Nil(t, n.Notify(context.Background(), s))
+
+	var got Summary
+	assert.Len(t, jsonSI.bodies, 1)
+	assert.Nil(t, json.Unmarshal([]byte(jsonSI.bodies[0]), &got))
+	assert.Equal(t, s, got)
+
+	var slack map[string]string
+	assert.Len(t, slackSI.bodies, 1)
+	assert.Nil(t, json.Unmarshal([]byte(slackSI.bodies[0]), &slack))
+	assert.Equal(t, ":white_check_mark: cami run finished in us-east-1\n"+
+		"deregister: 2 acted on, 1 failed, 2 candidates\n"+
+		"Reclaimed: 1024 bytes, saving $1.50 per month\n"+
+		"Failed: ami-456", slack["text"])
+
+	assert.Len(t, customSI.bodies, 1)
+	assert.Nil(t, json.Unmarshal([]byte(customSI.bodies[0]), &slack))
+	assert.Equal(t, "2 deleted in us-east-1", slack["text"])
+}
+
+func TestNotifyRetries(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name         string
+		giveStatuses []int
+		wantAttempts int
+		wantErr      error
+	}{
+		{
+			name:         "success",
+			giveStatuses: nil,
+			wantAttempts: 1,
+		},
+		{
+			name:         "retried",
+			giveStatuses: []int{http.StatusInternalServerError, http.StatusTooManyRequests},
+			wantAttempts: 3,
+		},
+		{
+			name:         "exhausted",
+			giveStatuses: []int{http.StatusBadGateway, http.StatusBadGateway, http.StatusBadGateway},
+			wantAttempts: 3,
+			wantErr:      ErrSend,
+		},
+		{
+			name:         "not retried",
+			giveStatuses: []int{http.StatusNotFound},
+			wantAttempts: 1,
+			wantErr:      ErrSend,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			si := &standIn{statuses: tt.giveStatuses}
+			srv := httptest.NewServer(si)
+			defer srv.Close()
+
+			n := &Notifier{Webhooks: []Webhook{{URL: srv.URL}}, On: OnAlways, Backoff: time.Millisecond}
+			err := n.Notify(context.Background(), Summary{})
+
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+			assert.Len(t, si.bodies, tt.wantAttempts)
+		})
+	}
+}
+
+func TestNotifyRoutes(t *testing.T) {
+	t.Parallel()
+
+	s := Summary{
+		Region:     "us-east-1",
+		Action:     "deregister",
+		Candidates: 3,
+		IDs:        []string{"ami-123", "ami-456"},
+		Failed:     []string{"ami-789"},
+		Owners: []OwnerSummary{
+			{Owner: "web", Candidates: 1, IDs: []string{"ami-123"}, ReclaimedBytes: 1024},
+			{Owner: "infra", Candidates: 1, Failed: []string{"ami-789"}},
+			{Owner: "unowned", Candidates: 1, IDs: []string{"ami-456"}},
+		},
+	}
+
+	allSI := &standIn{}
+	allSrv := httptest.NewServer(allSI)
+	defer allSrv.Close()
+
+	webSI := &standIn{}
+	webSrv := httptest.NewServer(webSI)
+	defer webSrv.Close()
+
+	infraSI := &standIn{}
+	infraSrv := httptest.NewServer(infraSI)
+	defer infraSrv.Close()
+
+	n := &Notifier{
+		Webhooks: []Webhook{{URL: allSrv.URL}},
+		Routes: map[string][]Webhook{
+			"web":   {{URL: webSrv.URL}},
+			"infra": {{URL: infraSrv.URL, Format: FormatSlack}},
+		},
+	}
+	assert.Nil(t, n.Validate())
+	assert.Nil(t, n.Notify(context.Background(), s))
+
+	var got Summary
+	assert.Len(t, allSI.bodies, 1)
+	assert.Nil(t, json.Unmarshal([]byte(allSI.bodies[0]), &got))
+	assert.Equal(t, s, got)
+
+	// web had no failures so it is not notified with the default OnFailure
+	assert.Empty(t, webSI.bodies)
+
+	var slack map[string]string
+	assert.Len(t, infraSI.bodies, 1)
+	assert.Nil(t, json.Unmarshal([]byte(infraSI.bodies[0]), &slack))
+	assert.Equal(t, ":white_check_mark: cami run finished in us-east-1 for infra\n"+
+		"deregister: 0 acted on, 1 failed, 1 candidates\n"+
+		"Reclaimed: 0 bytes, saving $0.00 per month\n"+
+		"Failed: ami-789", slack["text"])
+
+	n.On = OnChanges
+	assert.Nil(t, n.Notify(context.Background(), s))
+	var web Summary
+	assert.Len(t, webSI.bodies, 1)
+	assert.Nil(t, json.Unmarshal([]byte(webSI.bodies[0]), &web))
+	assert.Equal(t, s.ForOwner(s.Owners[0]), web)
+	assert.Equal(t, "web", web.Owner)
+	assert.Nil(t, web.Owners)
+}
+
+func TestValidate(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name    string
+		give    *Notifier
+		wantErr error
+	}{
+		{name: "default", give: &Notifier{}},
+		{name: "invalid on", give: &Notifier{On: "sometimes"}, wantErr: ErrInvalidOn},
+		{name: "invalid format", give: &Notifier{Webhooks: []Webhook{{Format: "xml"}}}, wantErr: ErrInvalidFormat},
+		{
+			name:    "invalid template",
+			give:    &Notifier{Webhooks: []Webhook{{Format: FormatSlack, Template: "{{"}}},
+			wantErr: ErrInvalidFormat,
+		},
+		{
+			name:    "invalid route",
+			give:    &Notifier{Routes: map[string][]Webhook{"web": {{Format: "xml"}}}},
+			wantErr: ErrInvalidFormat,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			err := tt.give.Validate()
+			if tt.wantErr == nil {
+				assert.Nil(t, err)
+			} else {
+				assert.True(t, errors.Is(err, tt.wantErr), fmt.Sprintf("expected: %s\ngot: %s", tt.wantErr, err))
+			}
+		})
+	}
+}
+
+func TestNotifyNil(t *testing.T) {
+	t.Parallel()
+
+	var n *Notifier
+	assert.Nil(t, n.Notify(context.Background(), Summary{Error: "FAIL"}))
+}
@@ -0,0 +1,556 @@
+// Package server provides an HTTP API for planning and applying cami runs on demand.
+package server
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"log/slog"
+	"net/http"
+	"sync"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/lingrino/cami/cami"
+)
+
+var (
+	// ErrNoToken is when the server is created without a bearer token.
+	ErrNoToken = errors.New("no token")
+	// ErrNotFound is when a plan or run does not exist.
+	ErrNotFound = errors.New("not found")
+	// ErrStore is when we fail to read or write a record on disk.
+	ErrStore = errors.New("store")
+	// ErrApplyInProgress is when a plan is applied while another apply runs in the same
+	// account and region.
+	ErrApplyInProgress = errors.New("apply in progress")
+	// ErrAccountChanged is when a plan is applied with credentials for a different account
+	// than it was created in.
+	ErrAccountChanged = errors.New("account changed")
+	// ErrPlanApplied is when a plan that was already applied is applied again.
+	ErrPlanApplied = errors.New("plan already applied")
+	// ErrPlanExpired is when a plan is applied more than the plan TTL after it was
+	// created.
+	ErrPlanExpired = errors.New("plan expired")
+	// ErrDryRunRequired is when a plan request turns off dry run on a server that was
+	// started in dry run mode.
+	ErrDryRunRequired = errors.New("dry run required")
+)
+
+// DefaultPlanTTL is how long a plan can be applied after it is created when
+// Server.PlanTTL is not set.
+const DefaultPlanTTL = time.Hour
+
+// Record kinds, which are also the names of the directories they are stored in.
+const (
+	kindPlans = "plans"
+	kindRuns  = "runs"
+)
+
+// Run statuses.
+const (
+	// StatusRunning is when the run has not finished yet.
+	StatusRunning = "running"
+	// StatusSucceeded is when the run acted on every candidate.
+	StatusSucceeded = "succeeded"
+	// StatusFailed is when the run failed or could not act on some candidates.
+	StatusFailed = "failed"
+)
+
+// maxBodyBytes is the largest request body the server reads.
+const maxBodyBytes = 1 << 20
+
+// PlanRequest is the body of POST /plans. Every field is optional and overrides the
+// base configuration of the server.
+type PlanRequest struct {
+	// DryRun overrides Config.DryRun for the plan and its applies. It can only be set
+	// to false if the server is not in dry run mode.
+	DryRun *bool `json:"dryrun"`
+	// Region to plan in. Defaults to the region of the server.
+	Region string `json:"region"`
+	// Selectors overrides Config.ImageFilters, e.g. {"tag:team": ["infra"]}
+	Selectors map[string][]string `json:"selectors"`
+}
+
+// Candidate is an AMI a plan would act on.
+type Candidate struct {
+	// ImageID is the ID of the AMI
+	ImageID string `json:"image_id"`
+	// Name is the name of the AMI
+	Name string `json:"name"`
+	// Reason is why the AMI is considered unused
+	Reason string `json:"reason"`
+	// Owner is the owner of the AMI, see cami.Config.OwnerTagKeys
+	Owner string `json:"owner"`
+}
+
+// Plan is a plan created by POST /plans.
+type Plan struct {
+	// ID is the ID of the plan
+	ID string `json:"id"`
+	// Created is when the plan was created
+	Created time.Time `json:"created"`
+	// Account is the AWS account the plan was created in
+	Account string `json:"account"`
+	// Region is the AWS region the plan was created in
+	Region string `json:"region"`
+	// DryRun is true if applying the plan changes nothing
+	DryRun bool `json:"dryrun"`
+	// Selectors are the image filters the plan was created with
+	Selectors map[string][]string `json:"selectors,omitempty"`
+	// Images is the number of AMIs that were considered
+	Images int `json:"images"`
+	// Candidates is the AMIs applying the plan acts on
+	Candidates []Candidate `json:"candidates"`
+	// Protected is why AMIs that are not used by any instance are kept, keyed by AMI ID
+	Protected map[string]string `json:"protected,omitempty"`
+	// Bytes is the snapshot storage used by the candidates
+	Bytes int64 `json:"bytes"`
+	// MonthlyCost is the estimated monthly cost of the candidates' snapshots in USD
+	MonthlyCost float64 `json:"monthly_cost"`
+	// Expires is when the plan can no longer be applied
+	Expires time.Time `json:"expires"`
+	// AppliedRun is the ID of the run that applied the plan, if it was applied. A plan
+	// can only be applied once.
+	AppliedRun string `json:"applied_run,omitempty"`
+}
+
+// storedPlan is a plan along with the full cami plan that is applied.
+type storedPlan struct {
+	Plan
+	Full *cami.Plan `json:"full"`
+}
+
+// Run is an apply of a plan started by POST /plans/{id}/apply.
+type Run struct {
+	// ID is the ID of the run
+	ID string `json:"id"`
+	// PlanID is the ID of the plan that was applied
+	PlanID string `json:"plan_id"`
+	// Status is one of StatusRunning, StatusSucceeded or StatusFailed
+	Status string `json:"status"`
+	// Started is when the run started
+	Started time.Time `json:"started"`
+	// Finished is when the run finished, if it has
+	Finished *time.Time `json:"finished,omitempty"`
+	// Skipped is the AMIs in the plan that were not acted on because they were no longer
+	// unused when the plan was applied
+	Skipped []string `json:"skipped,omitempty"`
+	// Outcome is what was acted on and why the run failed, if it did
+	cami.Outcome
+}
+
+// client is the part of cami.AWS the server uses.
+type client interface {
+	Plan() (*cami.Plan, error)
+	Apply(*cami.Plan) (*cami.Result, error)
+	Account() (string, error)
+	Region() string
+}
+
+// Server serves the cami HTTP API.
+type Server struct {
+	// PlanTTL is how long a plan can be applied after it is created. Defaults to
+	// DefaultPlanTTL.
+	PlanTTL time.Duration
+
+	cfg   cami.Config
+	token string
+	store *store
+
+	// applying maps account/region to the ID of the run applying there
+	applying   map[string]string
+	applyingMu sync.Mutex
+	wg         sync.WaitGroup
+
+	// Used for testing
+	newClientFn func(*cami.Config) (client, error)
+}
+
+// New returns a Server that runs with cfg, stores plans and runs in dir, and requires
+// every request to have the header "Authorization: Bearer <token>". Cancelling
+// cfg.Context stops running applies after their current AMI.
+func New(cfg cami.Config, dir, token string) (*Server, error) {
+	if token == "" {
+		return nil, ErrNoToken
+	}
+
+	st, err := newStore(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	return &Server{
+		cfg:         cfg,
+		token:       token,
+		store:       st,
+		applying:    make(map[string]string),
+		newClientFn: newClient,
+	}, nil
+}
+
+// newClient returns an authenticated cami client.
+func newClient(cfg *cami.Config) (client, error) {
+	a, err := cami.NewAWS(cfg)
+	if err != nil {
+		return nil, err
+	}
+
+	err = a.Auth()
+	if err != nil {
+		return nil, err
+	}
+
+	return a, nil
+}
+
+// Handler returns the HTTP handler serving the API.
+func (s *Server) Handler() http.Handler {
+	mux := http.NewServeMux()
+	mux.HandleFunc("POST /plans", s.createPlan)
+	mux.HandleFunc("GET /plans/{id}", s.getPlan)
+	mux.HandleFunc("POST /plans/{id}/apply", s.applyPlan)
+	mux.HandleFunc("GET /runs/{id}", s.getRun)
+
+	return s.authenticate(mux)
+}
+
+// planTTL returns the configured plan TTL or the default.
+func (s *Server) planTTL() time.Duration {
+	if s.PlanTTL <= 0 {
+		return DefaultPlanTTL
+	}
+	return s.PlanTTL
+}
+
+// Wait blocks until every running apply has finished.
+func (s *Server) Wait() {
+	s.wg.Wait()
+}
+
+// authenticate rejects requests without the bearer token.
+func (s *Server) authenticate(next http.Handler) http.Handler {
+	want := []byte("Bearer " + s.token)
+
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		got := []byte(r.Header.Get("Authorization"))
+		if subtle.ConstantTimeCompare(got, want) != 1 {
+			w.Header().Set("WWW-Authenticate", "Bearer")
+			writeError(w, http.StatusUnauthorized, errors.New("unauthorized"))
+			return
+		}
+		next.ServeHTTP(w, r)
+	})
+}
+
+// createPlan handles POST /plans.
+func (s *Server) createPlan(w http.ResponseWriter, r *http.Request) {
+	var req PlanRequest
+	err := json.NewDecoder(http.MaxBytesReader(w, r.Body, maxBodyBytes)).Decode(&req)
+	if err != nil && !errors.Is(err, io.EOF) {
+		writeError(w, http.StatusBadRequest, err)
+		return
+	}
+
+	cfg := s.cfg
+	if req.DryRun != nil {
+		if s.cfg.DryRun && !*req.DryRun {
+			writeError(w, http.StatusForbidden, fmt.Errorf("%w: the server is in dry run mode", ErrDryRunRequired))
+			return
+		}
+		cfg.DryRun = *req.DryRun
+	}
+	if req.Region != "" {
+		cfg.Region = req.Region
+	}
+	if req.Selectors != nil {
+		cfg.ImageFilters = req.Selectors
+	}
+
+	c, err := s.newClientFn(&cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	account, err := c.Account()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	full, err := c.Plan()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	created := time.Now().UTC()
+	plan := storedPlan{
+		Plan: Plan{
+			ID:        id,
+			Created:   created,
+			Expires:   created.Add(s.planTTL()),
+			Account:   account,
+			Region:    c.Region(),
+			DryRun:    cfg.DryRun,
+			Selectors: cfg.ImageFilters,
+			Images:    len(full.Images),
+			Protected: full.Protected,
+		},
+		Full: full,
+	}
+	for _, ami := range full.Candidates {
+		plan.Candidates = append(plan.Candidates, Candidate{
+			ImageID: *ami.ImageId,
+			Name:    aws.ToString(ami.Name),
+			Reason:  full.Reasons[*ami.ImageId],
+			Owner:   full.Owners[*ami.ImageId],
+		})
+	}
+	if full.Storage != nil {
+		plan.Bytes = full.Storage.Bytes
+		plan.MonthlyCost = full.Storage.MonthlyCost
+	}
+
+	err = s.store.put(kindPlans, id, plan)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	writeJSON(w, http.StatusCreated, plan.Plan)
+}
+
+// getPlan handles GET /plans/{id}.
+func (s *Server) getPlan(w http.ResponseWriter, r *http.Request) {
+	var plan storedPlan
+	err := s.store.get(kindPlans, r.PathValue("id"), &plan)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, plan.Plan)
+}
+
+// getRun handles GET /runs/{id}.
+func (s *Server) getRun(w http.ResponseWriter, r *http.Request) {
+	var run Run
+	err := s.store.get(kindRuns, r.PathValue("id"), &run)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+
+	writeJSON(w, http.StatusOK, run)
+}
+
+// applyPlan handles POST /plans/{id}/apply. The plan is applied in the background and
+// the response is the run that was started. A plan can only be applied once and before
+// it expires. Candidates of the plan that are no longer unused are skipped.
+func (s *Server) applyPlan(w http.ResponseWriter, r *http.Request) {
+	var plan storedPlan
+	err := s.store.get(kindPlans, r.PathValue("id"), &plan)
+	if err != nil {
+		writeStoreError(w, err)
+		return
+	}
+	if !s.applicable(w, plan) {
+		return
+	}
+
+	cfg := s.cfg
+	cfg.DryRun = plan.DryRun
+	cfg.Region = plan.Region
+	cfg.ImageFilters = plan.Selectors
+
+	c, err := s.newClientFn(&cfg)
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	account, err := c.Account()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	if account != plan.Account {
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: plan was created in %s, not %s",
+			ErrAccountChanged, plan.Account, account))
+		return
+	}
+
+	current, err := c.Plan()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	id, err := newID()
+	if err != nil {
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+	run := Run{ID: id, PlanID: plan.ID, Status: StatusRunning, Started: time.Now().UTC()}
+
+	key := account + "/" + plan.Region
+	s.applyingMu.Lock()
+	if other, ok := s.applying[key]; ok {
+		s.applyingMu.Unlock()
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: run %s in %s", ErrApplyInProgress, other, key))
+		return
+	}
+	s.applying[key] = id
+	s.applyingMu.Unlock()
+
+	// another apply of the plan may have finished since it was read
+	err = s.store.get(kindPlans, plan.ID, &plan)
+	if err != nil {
+		s.release(key)
+		writeStoreError(w, err)
+		return
+	}
+	if !s.applicable(w, plan) {
+		s.release(key)
+		return
+	}
+
+	full, skipped := revalidate(plan.Full, current)
+	run.Skipped = skipped
+	if len(skipped) > 0 {
+		s.logger().Info("skipping AMIs that are no longer unused", "run", id, "ids", skipped)
+	}
+
+	plan.AppliedRun = id
+	err = s.store.put(kindPlans, plan.ID, plan)
+	if err == nil {
+		err = s.store.put(kindRuns, id, run)
+	}
+	if err != nil {
+		s.release(key)
+		writeError(w, http.StatusInternalServerError, err)
+		return
+	}
+
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+		defer s.release(key)
+		s.apply(c, full, run)
+	}()
+
+	writeJSON(w, http.StatusAccepted, run)
+}
+
+// applicable writes a 409 and returns false if the plan was already applied or has
+// expired.
+func (s *Server) applicable(w http.ResponseWriter, plan storedPlan) bool {
+	if plan.AppliedRun != "" {
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: by run %s", ErrPlanApplied, plan.AppliedRun))
+		return false
+	}
+
+	expires := plan.Expires
+	if expires.IsZero() {
+		expires = plan.Created.Add(s.planTTL())
+	}
+	if time.Now().After(expires) {
+		writeError(w, http.StatusConflict, fmt.Errorf("%w: at %s", ErrPlanExpired, expires.Format(time.RFC3339)))
+		return false
+	}
+
+	return true
+}
+
+// revalidate returns the plan to apply for a stored plan: current, with only the
+// candidates that are in both. It also returns the IDs of the stored candidates that
+// are no longer candidates.
+func revalidate(stored, current *cami.Plan) (*cami.Plan, []string) {
+	unused := make(map[string]bool, len(current.Candidates))
+	for _, ami := range current.Candidates {
+		unused[*ami.ImageId] = true
+	}
+	planned := make(map[string]bool, len(stored.Candidates))
+	var skipped []string
+	for _, ami := range stored.Candidates {
+		planned[*ami.ImageId] = true
+		if !unused[*ami.ImageId] {
+			skipped = append(skipped, *ami.ImageId)
+		}
+	}
+
+	output := *current
+	output.Candidates = nil
+	for _, ami := range current.Candidates {
+		if planned[*ami.ImageId] {
+			output.Candidates = append(output.Candidates, ami)
+		}
+	}
+
+	return &output, skipped
+}
+
+// apply applies a plan and stores the finished run.
+func (s *Server) apply(c client, plan *cami.Plan, run Run) {
+	result, err := c.Apply(plan)
+
+	finished := time.Now().UTC()
+	run.Finished = &finished
+	run.Status = StatusSucceeded
+	if err != nil {
+		run.Status = StatusFailed
+	}
+	run.Outcome = cami.NewOutcome(result, err)
+
+	err = s.store.put(kindRuns, run.ID, run)
+	if err != nil {
+		s.logger().Error("failed to store run", "run", run.ID, "error", err)
+	}
+}
+
+// logger returns the configured logger or the default logger.
+func (s *Server) logger() *slog.Logger {
+	if s.cfg.Logger == nil {
+		return slog.Default()
+	}
+	return s.cfg.Logger
+}
+
+// release allows another apply in the account and region key.
+func (s *Server) release(key string) {
+	s.applyingMu.Lock()
+	delete(s.applying, key)
+	s.applyingMu.Unlock()
+}
+
+// writeJSON writes v as the JSON response body with status.
+func writeJSON(w http.ResponseWriter, status int, v any) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	_ = json.NewEncoder(w).Encode(v)
+}
+
+// writeError writes err as a JSON error response with status.
+func writeError(w http.ResponseWriter, status int, err error) {
+	writeJSON(w, status, map[string]string{"error": err.Error()})
+}
+
+// writeStoreError writes an error from the store, which is a 404 if the record does
+// not exist.
+func writeStoreError(w http.ResponseWriter, err error) {
+	if errors.Is(err, ErrNotFound) {
+		writeError(w, http.StatusNotFound, err)
+		return
+	}
+	writeError(w, http.StatusInternalServerError, err)
+}
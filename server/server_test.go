@@ -0,0 +1,483 @@
+package server
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	"github.com/aws/aws-sdk-go-v2/service/ec2/types"
+	"github.com/lingrino/cami/cami"
+	"github.com/stretchr/testify/assert"
+)
+
+const testToken = "secret"
+
+type mockClient struct {
+	cfg *cami.Config
+
+	RespPlan     *cami.Plan
+	RespPlanErr  error
+	RespApply    *cami.Result
+	RespApplyErr error
+	RespAccount  string
+	RespRegion   string
+
+	// Applied, if set, has every plan passed to Apply appended to it
+	Applied *[]*cami.Plan
+	// ApplyStarted, if set, is closed when Apply is called
+	ApplyStarted chan struct{}
+	// ApplyBlock, if set, is waited on before Apply returns
+	ApplyBlock chan struct{}
+}
+
+func (m *mockClient) Plan() (*cami.Plan, error) {
+	return m.RespPlan, m.RespPlanErr
+}
+
+func (m *mockClient) Apply(plan *cami.Plan) (*cami.Result, error) {
+	if m.Applied != nil {
+		*m.Applied = append(*m.Applied, plan)
+	}
+	if m.ApplyStarted != nil {
+		close(m.ApplyStarted)
+	}
+	if m.ApplyBlock != nil {
+		<-m.ApplyBlock
+	}
+	return m.RespApply, m.RespApplyErr
+}
+
+func (m *mockClient) Account() (string, error) {
+	return m.RespAccount, nil
+}
+
+func (m *mockClient) Region() string {
+	if m.cfg.Region != "" {
+		return m.cfg.Region
+	}
+	return m.RespRegion
+}
+
+// newTestServer returns a server whose clients are copies of mc.
+func newTestServer(t *testing.T, mc *mockClient) (*Server, *[]*cami.Config) {
+	t.Helper()
+
+	s, err := New(cami.Config{DryRun: true}, t.TempDir(), testToken)
+	assert.Nil(t, err)
+
+	var cfgs []*cami.Config
+	s.newClientFn = func(cfg *cami.Config) (client, error) {
+		cfgs = append(cfgs, cfg)
+		c := *mc
+		c.cfg = cfg
+		return &c, nil
+	}
+
+	return s, &cfgs
+}
+
+// do sends a request to the server and decodes the JSON response into v.
+func do(t *testing.T, h http.Handler, method, path, body string, v any) int {
+	t.Helper()
+
+	req := httptest.NewRequest(method, path, strings.NewReader(body))
+	req.Header.Set("Authorization", "Bearer "+testToken)
+	rec := httptest.NewRecorder()
+	h.ServeHTTP(rec, req)
+
+	if v != nil {
+		assert.Nil(t, json.Unmarshal(rec.Body.Bytes(), v))
+	}
+	return rec.Code
+}
+
+func testPlan() *cami.Plan {
+	return &cami.Plan{
+		Images: []types.Image{
+			{ImageId: aws.String("ami-123"), Name: aws.String("old")},
+			{ImageId: aws.String("ami-456")},
+		},
+		Candidates: []types.Image{{ImageId: aws.String("ami-123"), Name: aws.String("old")}},
+		Reasons:    map[string]string{"ami-123": "not used by any instance"},
+		Owners:     map[string]string{"ami-123": "web"},
+		Protected:  map[string]string{"ami-456": "referenced by aws_instance.web.ami in terraform state prod.tfstate"},
+		Storage:    &cami.StorageReport{Bytes: 1024, MonthlyCost: 0.05},
+	}
+}
+
+func TestNew(t *testing.T) {
+	t.Parallel()
+
+	_, err := New(cami.Config{}, t.TempDir(), "")
+	assert.True(t, errors.Is(err, ErrNoToken), fmt.Sprintf("expected: %s\ngot: %s", ErrNoToken, err))
+}
+
+func TestAuthenticate(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestServer(t, &mockClient{})
+
+	tests := []struct {
+		name       string
+		giveHeader string
+		wantCode   int
+	}{
+		{name: "missing", giveHeader: "", wantCode: http.StatusUnauthorized},
+		{name: "wrong", giveHeader: "Bearer wrong", wantCode: http.StatusUnauthorized},
+		{name: "not bearer", giveHeader: testToken, wantCode: http.StatusUnauthorized},
+		{name: "valid", giveHeader: "Bearer " + testToken, wantCode: http.StatusNotFound},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			req := httptest.NewRequest(http.MethodGet, "/plans/00000000000000000000000000000000", nil)
+			if tt.giveHeader != "" {
+				req.Header.Set("Authorization", tt.giveHeader)
+			}
+			rec := httptest.NewRecorder()
+			s.Handler().ServeHTTP(rec, req)
+
+			assert.Equal(t, tt.wantCode, rec.Code)
+		})
+	}
+}
+
+func TestPlanAndApply(t *testing.T) {
+	t.Parallel()
+
+	s, cfgs := newTestServer(t, &mockClient{
+		RespPlan:    testPlan(),
+		RespApply:   &cami.Result{RunID: "run", IDs: []string{"ami-123"}, Reclaimed: &cami.StorageReport{Bytes: 1024}},
+		RespAccount: "123456789012",
+		RespRegion:  "us-east-1",
+	})
+	s.cfg.DryRun = false
+	h := s.Handler()
+
+	var plan Plan
+	code := do(t, h, http.MethodPost, "/plans", `{"dryrun": false, "selectors": {"name": ["old*"]}}`, &plan)
+	assert.Equal(t, http.StatusCreated, code)
+	assert.Equal(t, "123456789012", plan.Account)
+	assert.Equal(t, "us-east-1", plan.Region)
+	assert.False(t, plan.DryRun)
+	assert.Equal(t, 2, plan.Images)
+	assert.Equal(t, []Candidate{{ImageID: "ami-123", Name: "old", Reason: "not used by any instance", Owner: "web"}}, plan.Candidates)
+	assert.Equal(t, testPlan().Protected, plan.Protected)
+	assert.Equal(t, int64(1024), plan.Bytes)
+
+	var got Plan
+	code = do(t, h, http.MethodGet, "/plans/"+plan.ID, "", &got)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, plan, got)
+
+	var run Run
+	code = do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &run)
+	assert.Equal(t, http.StatusAccepted, code)
+	assert.Equal(t, StatusRunning, run.Status)
+	assert.Equal(t, plan.ID, run.PlanID)
+
+	s.Wait()
+
+	code = do(t, h, http.MethodGet, "/runs/"+run.ID, "", &run)
+	assert.Equal(t, http.StatusOK, code)
+	assert.Equal(t, StatusSucceeded, run.Status)
+	assert.Equal(t, "run", run.RunID)
+	assert.Equal(t, []string{"ami-123"}, run.IDs)
+	assert.Equal(t, int64(1024), run.ReclaimedBytes)
+	assert.NotNil(t, run.Finished)
+
+	// the apply uses the configuration the plan was created with
+	assert.Len(t, *cfgs, 2)
+	apply := (*cfgs)[1]
+	assert.False(t, apply.DryRun)
+	assert.Equal(t, "us-east-1", apply.Region)
+	assert.Equal(t, map[string][]string{"name": {"old*"}}, apply.ImageFilters)
+}
+
+func TestPlanDryRun(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name       string
+		giveDryRun bool
+		giveBody   string
+		wantCode   int
+		wantDryRun bool
+	}{
+		{name: "server default", giveDryRun: true, giveBody: "", wantCode: http.StatusCreated, wantDryRun: true},
+		{name: "turn off", giveDryRun: true, giveBody: `{"dryrun": false}`, wantCode: http.StatusForbidden},
+		{name: "keep on", giveDryRun: true, giveBody: `{"dryrun": true}`, wantCode: http.StatusCreated, wantDryRun: true},
+		{name: "turn on", giveDryRun: false, giveBody: `{"dryrun": true}`, wantCode: http.StatusCreated, wantDryRun: true},
+		{name: "keep off", giveDryRun: false, giveBody: `{"dryrun": false}`, wantCode: http.StatusCreated, wantDryRun: false},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, cfgs := newTestServer(t, &mockClient{RespPlan: testPlan(), RespAccount: "123456789012"})
+			s.cfg.DryRun = tt.giveDryRun
+			h := s.Handler()
+
+			var plan Plan
+			assert.Equal(t, tt.wantCode, do(t, h, http.MethodPost, "/plans", tt.giveBody, &plan))
+			if tt.wantCode != http.StatusCreated {
+				assert.Empty(t, *cfgs)
+				return
+			}
+			assert.Equal(t, tt.wantDryRun, plan.DryRun)
+			assert.Equal(t, tt.wantDryRun, (*cfgs)[0].DryRun)
+		})
+	}
+}
+
+func TestApplyFailed(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestServer(t, &mockClient{
+		RespPlan:     testPlan(),
+		RespApply:    &cami.Result{},
+		RespApplyErr: &cami.ErrDeleteAMIs{IDs: []string{"ami-123"}},
+		RespAccount:  "123456789012",
+	})
+	h := s.Handler()
+
+	var plan Plan
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", "", &plan))
+	assert.True(t, plan.DryRun)
+
+	var run Run
+	assert.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &run))
+	s.Wait()
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/runs/"+run.ID, "", &run))
+	assert.Equal(t, StatusFailed, run.Status)
+	assert.Equal(t, []string{"ami-123"}, run.Failed)
+	assert.Equal(t, "delete AMIs", run.Error)
+}
+
+func TestApplyConcurrency(t *testing.T) {
+	t.Parallel()
+
+	started := make(chan struct{})
+	block := make(chan struct{})
+	mc := &mockClient{
+		RespPlan:     testPlan(),
+		RespApply:    &cami.Result{},
+		RespAccount:  "123456789012",
+		ApplyStarted: started,
+		ApplyBlock:   block,
+	}
+	s, _ := newTestServer(t, mc)
+	h := s.Handler()
+
+	var east, east2, west Plan
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", `{"region": "us-east-1"}`, &east))
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", `{"region": "us-east-1"}`, &east2))
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", `{"region": "us-west-2"}`, &west))
+
+	assert.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/plans/"+east.ID+"/apply", "", nil))
+	<-started
+
+	// only ApplyStarted of the first client is closed, the rest must not close it again
+	mc.ApplyStarted = nil
+
+	var errResp map[string]string
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/plans/"+east2.ID+"/apply", "", &errResp))
+	assert.Contains(t, errResp["error"], ErrApplyInProgress.Error())
+
+	assert.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/plans/"+west.ID+"/apply", "", nil))
+
+	close(block)
+	s.Wait()
+
+	assert.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/plans/"+east2.ID+"/apply", "", nil))
+	s.Wait()
+}
+
+func TestApplyTwice(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestServer(t, &mockClient{RespPlan: testPlan(), RespApply: &cami.Result{}, RespAccount: "123456789012"})
+	h := s.Handler()
+
+	var plan Plan
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", "", &plan))
+
+	var run Run
+	assert.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &run))
+	s.Wait()
+
+	var errResp map[string]string
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &errResp))
+	assert.Contains(t, errResp["error"], ErrPlanApplied.Error())
+	assert.Contains(t, errResp["error"], run.ID)
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/plans/"+plan.ID, "", &plan))
+	assert.Equal(t, run.ID, plan.AppliedRun)
+}
+
+func TestApplyExpired(t *testing.T) {
+	t.Parallel()
+
+	s, _ := newTestServer(t, &mockClient{RespPlan: testPlan(), RespApply: &cami.Result{}, RespAccount: "123456789012"})
+	s.PlanTTL = time.Nanosecond
+	h := s.Handler()
+
+	var plan Plan
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", "", &plan))
+	assert.Equal(t, plan.Created.Add(time.Nanosecond), plan.Expires)
+	time.Sleep(time.Millisecond)
+
+	var errResp map[string]string
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &errResp))
+	assert.Contains(t, errResp["error"], ErrPlanExpired.Error())
+}
+
+func TestApplyStale(t *testing.T) {
+	t.Parallel()
+
+	var applied []*cami.Plan
+	mc := &mockClient{
+		RespPlan: &cami.Plan{Candidates: []types.Image{
+			{ImageId: aws.String("ami-123")},
+			{ImageId: aws.String("ami-456")},
+		}},
+		RespApply:   &cami.Result{},
+		RespAccount: "123456789012",
+		Applied:     &applied,
+	}
+	s, _ := newTestServer(t, mc)
+	h := s.Handler()
+
+	var plan Plan
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", "", &plan))
+
+	// ami-456 is used again and ami-789 became unused after the plan was created
+	mc.RespPlan = &cami.Plan{
+		Candidates: []types.Image{
+			{ImageId: aws.String("ami-123"), Name: aws.String("now")},
+			{ImageId: aws.String("ami-789")},
+		},
+		Storage: &cami.StorageReport{Bytes: 2048},
+	}
+
+	var run Run
+	assert.Equal(t, http.StatusAccepted, do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &run))
+	assert.Equal(t, []string{"ami-456"}, run.Skipped)
+	s.Wait()
+
+	assert.Len(t, applied, 1)
+	assert.Equal(t, []types.Image{{ImageId: aws.String("ami-123"), Name: aws.String("now")}}, applied[0].Candidates)
+	assert.Equal(t, int64(2048), applied[0].Storage.Bytes)
+
+	assert.Equal(t, http.StatusOK, do(t, h, http.MethodGet, "/runs/"+run.ID, "", &run))
+	assert.Equal(t, []string{"ami-456"}, run.Skipped)
+}
+
+func TestApplyAccountChanged(t *testing.T) {
+	t.Parallel()
+
+	mc := &mockClient{RespPlan: testPlan(), RespAccount: "123456789012"}
+	s, _ := newTestServer(t, mc)
+	h := s.Handler()
+
+	var plan Plan
+	assert.Equal(t, http.StatusCreated, do(t, h, http.MethodPost, "/plans", "", &plan))
+
+	mc.RespAccount = "210987654321"
+	var errResp map[string]string
+	assert.Equal(t, http.StatusConflict, do(t, h, http.MethodPost, "/plans/"+plan.ID+"/apply", "", &errResp))
+	assert.Contains(t, errResp["error"], ErrAccountChanged.Error())
+}
+
+func TestErrors(t *testing.T) {
+	t.Parallel()
+
+	tests := []struct {
+		name     string
+		giveMock *mockClient
+		method   string
+		path     string
+		body     string
+		wantCode int
+	}{
+		{
+			name:     "plan not found",
+			giveMock: &mockClient{},
+			method:   http.MethodGet,
+			path:     "/plans/00000000000000000000000000000000",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "invalid id",
+			giveMock: &mockClient{},
+			method:   http.MethodGet,
+			path:     "/plans/not-an-id",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "run not found",
+			giveMock: &mockClient{},
+			method:   http.MethodGet,
+			path:     "/runs/00000000000000000000000000000000",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "apply not found",
+			giveMock: &mockClient{},
+			method:   http.MethodPost,
+			path:     "/plans/00000000000000000000000000000000/apply",
+			wantCode: http.StatusNotFound,
+		},
+		{
+			name:     "invalid body",
+			giveMock: &mockClient{},
+			method:   http.MethodPost,
+			path:     "/plans",
+			body:     "{",
+			wantCode: http.StatusBadRequest,
+		},
+		{
+			name:     "plan error",
+			giveMock: &mockClient{RespPlanErr: cami.ErrDesribeImages},
+			method:   http.MethodPost,
+			path:     "/plans",
+			wantCode: http.StatusInternalServerError,
+		},
+	}
+
+	for _, tt := range tests {
+		tt := tt
+		t.Run(tt.name, func(t *testing.T) {
+			t.Parallel()
+
+			s, _ := newTestServer(t, tt.giveMock)
+			assert.Equal(t, tt.wantCode, do(t, s.Handler(), tt.method, tt.path, tt.body, nil))
+		})
+	}
+}
+
+func TestNewClientContext(t *testing.T) {
+	t.Parallel()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+
+	s, cfgs := newTestServer(t, &mockClient{RespPlan: testPlan()})
+	s.cfg.Context = ctx
+
+	assert.Equal(t, http.StatusCreated, do(t, s.Handler(), http.MethodPost, "/plans", "", nil))
+	assert.Equal(t, ctx, (*cfgs)[0].Context)
+}
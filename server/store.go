@@ -0,0 +1,107 @@
+package server
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+)
+
+// storeFileMode is the file mode of records written to the store.
+const storeFileMode = 0o600
+
+// storeDirMode is the file mode of directories created by the store.
+const storeDirMode = 0o700
+
+// idBytes is the number of random bytes in an ID.
+const idBytes = 16
+
+// idRe matches the IDs generated by newID.
+var idRe = regexp.MustCompile(`^[0-9a-f]{32}$`) //nolint:gochecknoglobals
+
+// store keeps JSON records on local disk, one file per record, in a directory per kind.
+type store struct {
+	dir string
+}
+
+// newStore returns a store in dir, creating it if needed.
+func newStore(dir string) (*store, error) {
+	for _, kind := range []string{kindPlans, kindRuns} {
+		err := os.MkdirAll(filepath.Join(dir, kind), storeDirMode)
+		if err != nil {
+			return nil, fmt.Errorf("%w: %w", ErrStore, err)
+		}
+	}
+	return &store{dir: dir}, nil
+}
+
+// newID returns a new random ID.
+func newID() (string, error) {
+	b := make([]byte, idBytes)
+	_, err := rand.Read(b)
+	if err != nil {
+		return "", fmt.Errorf("%w: %w", ErrStore, err)
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// path returns the path of a record, or an error if id is not a valid ID.
+func (s *store) path(kind, id string) (string, error) {
+	if !idRe.MatchString(id) {
+		return "", ErrNotFound
+	}
+	return filepath.Join(s.dir, kind, id+".json"), nil
+}
+
+// put writes a record, replacing any existing record with the same ID atomically.
+func (s *store) put(kind, id string, v any) error {
+	path, err := s.path(kind, id)
+	if err != nil {
+		return err
+	}
+
+	b, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	tmp := path + ".tmp"
+	err = os.WriteFile(tmp, b, storeFileMode)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	err = os.Rename(tmp, path)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	return nil
+}
+
+// get reads a record into v. Returns ErrNotFound if there is no such record.
+func (s *store) get(kind, id string, v any) error {
+	path, err := s.path(kind, id)
+	if err != nil {
+		return err
+	}
+
+	b, err := os.ReadFile(path)
+	if errors.Is(err, os.ErrNotExist) {
+		return ErrNotFound
+	}
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	err = json.Unmarshal(b, v)
+	if err != nil {
+		return fmt.Errorf("%w: %w", ErrStore, err)
+	}
+
+	return nil
+}
@@ -0,0 +1,44 @@
+package server
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"testing"
+
+	"github.com/stretchr/testify/assert"
+)
+
+func TestStore(t *testing.T) {
+	t.Parallel()
+
+	dir := t.TempDir()
+	st, err := newStore(dir)
+	assert.Nil(t, err)
+
+	id, err := newID()
+	assert.Nil(t, err)
+	assert.Regexp(t, idRe, id)
+
+	assert.Nil(t, st.put(kindRuns, id, Run{ID: id, Status: StatusRunning}))
+	assert.Nil(t, st.put(kindRuns, id, Run{ID: id, Status: StatusSucceeded}))
+
+	var run Run
+	assert.Nil(t, st.get(kindRuns, id, &run))
+	assert.Equal(t, Run{ID: id, Status: StatusSucceeded}, run)
+
+	info, err := os.Stat(filepath.Join(dir, kindRuns, id+".json"))
+	assert.Nil(t, err)
+	assert.Equal(t, os.FileMode(storeFileMode), info.Mode().Perm())
+
+	err = st.get(kindPlans, id, &run)
+	assert.True(t, errors.Is(err, ErrNotFound), fmt.Sprintf("expected: %s\ngot: %s", ErrNotFound, err))
+
+	err = st.put(kindPlans, "../escape", run)
+	assert.True(t, errors.Is(err, ErrNotFound), fmt.Sprintf("expected: %s\ngot: %s", ErrNotFound, err))
+
+	assert.Nil(t, os.WriteFile(filepath.Join(dir, kindRuns, id+".json"), []byte("{"), storeFileMode))
+	err = st.get(kindRuns, id, &run)
+	assert.True(t, errors.Is(err, ErrStore), fmt.Sprintf("expected: %s\ngot: %s", ErrStore, err))
+}